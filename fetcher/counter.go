@@ -0,0 +1,64 @@
+package fetcher
+
+import (
+	"sync"
+)
+
+// ArticleCounter counts the articles saved during a single call to Fetch,
+// stopping at an optional limit. It is safe for concurrent use by the
+// callbacks of an asynchronous collector.
+type ArticleCounter struct {
+	mu    sync.Mutex
+	count int
+	limit int
+}
+
+// NewArticleCounter returns a counter that allows up to limit articles.
+// A limit of 0 or less means no limit.
+func NewArticleCounter(limit int) *ArticleCounter {
+	return &ArticleCounter{
+		limit: limit,
+	}
+}
+
+// Claim reserves a place for one more article, returning false if the
+// limit has already been reached. Callers should only save an article
+// after a successful claim.
+func (c *ArticleCounter) Claim() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.limit > 0 && c.count >= c.limit {
+		return false
+	}
+
+	c.count++
+	return true
+}
+
+// Release gives back a place claimed for an article that was not saved
+// after all.
+func (c *ArticleCounter) Release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.count > 0 {
+		c.count--
+	}
+}
+
+// LimitReached reports whether no more articles may be claimed.
+func (c *ArticleCounter) LimitReached() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.limit > 0 && c.count >= c.limit
+}
+
+// Count returns the number of articles claimed so far.
+func (c *ArticleCounter) Count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.count
+}
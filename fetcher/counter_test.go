@@ -0,0 +1,49 @@
+package fetcher
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestArticleCounter(t *testing.T) {
+	counter := NewArticleCounter(10)
+
+	var wg sync.WaitGroup
+	claimed := make(chan bool, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			claimed <- counter.Claim()
+		}()
+	}
+	wg.Wait()
+	close(claimed)
+
+	successes := 0
+	for ok := range claimed {
+		if ok {
+			successes++
+		}
+	}
+
+	if successes != 10 {
+		t.Errorf("ArticleCounter.Claim() succeeded %d times; want 10", successes)
+	}
+	if counter.Count() != 10 {
+		t.Errorf("ArticleCounter.Count() = %d; want 10", counter.Count())
+	}
+	if !counter.LimitReached() {
+		t.Errorf("ArticleCounter.LimitReached() = false; want true")
+	}
+
+	unlimited := NewArticleCounter(0)
+	for i := 0; i < 100; i++ {
+		if !unlimited.Claim() {
+			t.Fatalf("ArticleCounter.Claim() = false on unlimited counter")
+		}
+	}
+	if unlimited.LimitReached() {
+		t.Errorf("ArticleCounter.LimitReached() = true on unlimited counter; want false")
+	}
+}
@@ -0,0 +1,418 @@
+package fetcher
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/qwwqe/colly"
+	"github.com/qwwqe/tcsuite/content"
+	"github.com/qwwqe/tcsuite/logging"
+)
+
+// ArticleProcessor extracts the content of an article page.
+// doc may be nil, in which case the processor parses the response body itself.
+type ArticleProcessor func(r *colly.Response, doc *goquery.Document) (*content.FetchedContent, error)
+
+// FeedFetcher fetches the articles listed in an outlet's RSS or Atom feeds,
+// handing each new article to the outlet's own article processor. Only
+// articles not yet in the repository are requested, making it suited to
+// incremental updates.
+//
+// MaxDepth and State do not apply; DeparturePoint, if set, replaces the
+// fetcher's feeds with a single feed, and Sitemap, if set, replaces the
+// feeds with a sitemap.
+type FeedFetcher struct {
+	FetcherOptions *FetcherOptions
+
+	Name           string   // canon name of the outlet
+	Feeds          []string // feed urls
+	AllowedDomains []string
+	ArticleUrl     *regexp.Regexp // if set, feed entries not matching it are ignored
+	StripQueries   bool           // drop query strings (tracking parameters) from entry urls
+	Process        ArticleProcessor
+}
+
+// feedEntry is an article listed in a feed.
+type feedEntry struct {
+	Url  string
+	Date time.Time // zero if the feed gives none
+}
+
+// feedDocument holds the parts of an RSS 2.0 or Atom feed of use to us.
+type feedDocument struct {
+	Items   []rssItem   `xml:"channel>item"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type rssItem struct {
+	Link    string `xml:"link"`
+	Guid    string `xml:"guid"`
+	PubDate string `xml:"pubDate"`
+}
+
+type atomEntry struct {
+	Links     []atomLink `xml:"link"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+}
+
+// Layouts observed in the dates of RSS and Atom feeds.
+var feedTimeLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	time.RFC3339,
+}
+
+// Location of the dates of processed articles, which are given without a
+// zone, and of the outlets fetched from feeds
+var feedArticleLocation = time.FixedZone("CST", 8*60*60)
+
+// Key of the request context value marking an article whose feed entry
+// gave no date
+const feedUndatedKey = "feedUndated"
+
+func (f *FeedFetcher) SetFetcherOptions(fetcherOptions *FetcherOptions) {
+	f.FetcherOptions = fetcherOptions
+}
+
+func (f *FeedFetcher) GetFetcherOptions() *FetcherOptions {
+	return f.FetcherOptions
+}
+
+func (f *FeedFetcher) CanonName() string {
+	return f.Name
+}
+
+func (f *FeedFetcher) Fetch(fetchOptions FetchOptions) error {
+	if fetchOptions.Uri != "" {
+		return f.fetchUri(fetchOptions.Uri)
+	}
+
+	repo := f.GetFetcherOptions().Repository
+	logger := f.GetFetcherOptions().SiteLogger(f.Name)
+	counter := NewArticleCounter(fetchOptions.ArticleLimit)
+	stats := NewFetchStats(f.CanonName())
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	entries, err := f.discover(client, fetchOptions, stats)
+	if err != nil {
+		return err
+	}
+	logger.Info("new articles discovered", "count", len(entries))
+
+	c := colly.NewCollector(
+		colly.AllowedDomains(f.AllowedDomains...),
+		colly.IgnoreRobotsTxt(),
+		colly.MaxDepth(1),
+		colly.Async(fetchOptions.Async),
+	)
+
+	if fetchOptions.Parallelism > 1 {
+		c.Limit(&colly.LimitRule{DomainGlob: "*", Parallelism: fetchOptions.Parallelism})
+	}
+
+	c.OnRequest(func(r *colly.Request) {
+		if counter.LimitReached() {
+			r.Abort()
+			return
+		}
+		logger.Debug("visiting", logging.UrlKey, r.URL.String())
+	})
+
+	c.OnResponse(func(r *colly.Response) {
+		logger.Debug("response", logging.UrlKey, r.Request.URL.String())
+		stats.PageVisited()
+
+		// Every entry of a feed is an article
+		stats.ArticleFound()
+		fc, err := f.Process(r, nil)
+		if err != nil {
+			logger.Warn("extraction failed", logging.UrlKey, r.Request.URL.String(), "field", err.Error())
+			stats.ExtractionFailed(err)
+			return
+		}
+
+		// Articles whose entries gave no date are filtered by their own
+		if undated, _ := r.Ctx.GetAny(feedUndatedKey).(bool); undated {
+			articleDate, err := time.ParseInLocation("2006-01-02 15:04:05", fc.Date, feedArticleLocation)
+			if err == nil {
+				if !fetchOptions.BeforeTime.IsZero() && !articleDate.Before(fetchOptions.BeforeTime) {
+					logger.Debug("article too new", logging.UrlKey, fc.Uri)
+					return
+				}
+				if !fetchOptions.AfterTime.IsZero() && !articleDate.After(fetchOptions.AfterTime) {
+					logger.Debug("article too old", logging.UrlKey, fc.Uri)
+					return
+				}
+			}
+		}
+
+		saved, err := stats.SaveArticle(f.GetFetcherOptions(), counter, fc)
+		if err != nil {
+			logger.Error("error saving article", logging.UrlKey, fc.Uri, logging.ErrorKey, err)
+		} else if saved {
+			logger.Info("article saved", logging.UrlKey, fc.Uri)
+		}
+	})
+
+	c.OnError(func(r *colly.Response, err error) {
+		logger.Warn("request failed", logging.UrlKey, r.Request.URL.String(), logging.ErrorKey, err)
+	})
+
+	for _, entry := range entries {
+		ctx := colly.NewContext()
+		ctx.Put(feedUndatedKey, entry.Date.IsZero())
+		c.Request("GET", entry.Url, nil, ctx, nil)
+	}
+
+	if fetchOptions.Async {
+		c.Wait()
+	}
+
+	logger.Info("fetch finished", "saved", counter.Count())
+
+	if err := stats.Save(repo); err != nil {
+		logger.Error("error saving fetch statistics", logging.ErrorKey, err)
+	}
+
+	return nil
+}
+
+// fetchUri fetches, processes and saves the single article found at uri.
+func (f *FeedFetcher) fetchUri(uri string) error {
+	logger := f.GetFetcherOptions().SiteLogger(f.Name)
+
+	if f.ArticleUrl != nil && !f.ArticleUrl.MatchString(uri) {
+		logger.Warn("not an article", logging.UrlKey, uri)
+		return &NotArticleError{Uri: uri}
+	}
+
+	c := colly.NewCollector(
+		colly.AllowedDomains(f.AllowedDomains...),
+		colly.IgnoreRobotsTxt(),
+		colly.AllowURLRevisit(),
+	)
+
+	var fetchErr error
+
+	c.OnRequest(func(r *colly.Request) {
+		logger.Debug("visiting", logging.UrlKey, r.URL.String())
+	})
+
+	c.OnResponse(func(r *colly.Response) {
+		logger.Debug("response", logging.UrlKey, r.Request.URL.String())
+
+		fc, err := f.Process(r, nil)
+		if err != nil {
+			logger.Warn("extraction failed", logging.UrlKey, uri, "field", err.Error())
+			fetchErr = err
+			return
+		}
+		if _, err := f.GetFetcherOptions().SaveContent(fc); err != nil {
+			logger.Error("error saving article", logging.UrlKey, fc.Uri, logging.ErrorKey, err)
+			fetchErr = err
+			return
+		}
+		logger.Info("article saved", logging.UrlKey, fc.Uri)
+	})
+
+	c.OnError(func(r *colly.Response, err error) {
+		logger.Warn("request failed", logging.UrlKey, r.Request.URL.String(), logging.ErrorKey, err)
+		fetchErr = err
+	})
+
+	if err := c.Visit(uri); err != nil {
+		return err
+	}
+
+	return fetchErr
+}
+
+// discover reads the fetcher's feeds, or the sitemap of fetchOptions, and
+// returns the entries of the articles that are not yet in the repository
+// and fall within the time bounds of fetchOptions, if dated, counting the
+// others in stats as duplicates. A feed that cannot be read is logged and
+// skipped.
+func (f *FeedFetcher) discover(client *http.Client, fetchOptions FetchOptions, stats *FetchStats) ([]feedEntry, error) {
+	repo := f.GetFetcherOptions().Repository
+	logger := f.GetFetcherOptions().SiteLogger(f.Name)
+
+	feeds := f.Feeds
+	if fetchOptions.DeparturePoint != "" {
+		feeds = []string{fetchOptions.DeparturePoint}
+	}
+
+	entries := []feedEntry{}
+	if fetchOptions.Sitemap != "" {
+		// Sitemap entries dated by 'lastmod' have been filtered already, and
+		// all are filtered by the date of their article once processed
+		logger.Debug("reading sitemap", logging.UrlKey, fetchOptions.Sitemap)
+		var isArticle func(string) bool
+		if f.ArticleUrl != nil {
+			isArticle = func(u string) bool {
+				return f.ArticleUrl.MatchString(f.entryUrl(u))
+			}
+		}
+		sitemapUrls, err := ReadSitemap(client, fetchOptions.Sitemap, fetchOptions, isArticle, logger)
+		if err != nil {
+			logger.Error("error reading sitemap", logging.UrlKey, fetchOptions.Sitemap, logging.ErrorKey, err)
+			return nil, err
+		}
+		for _, sitemapUrl := range sitemapUrls {
+			entries = append(entries, feedEntry{Url: sitemapUrl})
+		}
+	} else {
+		for _, feedUrl := range feeds {
+			logger.Debug("reading feed", logging.UrlKey, feedUrl)
+
+			feedEntries, err := readFeed(client, feedUrl)
+			if err != nil {
+				logger.Warn("error reading feed", logging.UrlKey, feedUrl, logging.ErrorKey, err)
+				continue
+			}
+			entries = append(entries, feedEntries...)
+		}
+	}
+
+	newEntries := []feedEntry{}
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		articleUrl := f.entryUrl(entry.Url)
+
+		if seen[articleUrl] {
+			continue
+		}
+		seen[articleUrl] = true
+
+		if f.ArticleUrl != nil && !f.ArticleUrl.MatchString(articleUrl) {
+			continue
+		}
+
+		// Entries without a date are filtered by the date of their article
+		// once processed
+		if !entry.Date.IsZero() {
+			if !fetchOptions.BeforeTime.IsZero() && !entry.Date.Before(fetchOptions.BeforeTime) {
+				continue
+			}
+			if !fetchOptions.AfterTime.IsZero() && !entry.Date.After(fetchOptions.AfterTime) {
+				continue
+			}
+		}
+
+		exists, err := repo.ContentExists(articleUrl)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			stats.Duplicate()
+		} else {
+			newEntries = append(newEntries, feedEntry{Url: articleUrl, Date: entry.Date})
+		}
+	}
+
+	return newEntries, nil
+}
+
+// entryUrl returns the url of the article listed at entryUrl, stripped of
+// its query if the fetcher strips queries.
+func (f *FeedFetcher) entryUrl(entryUrl string) string {
+	if !f.StripQueries {
+		return entryUrl
+	}
+	u, err := url.Parse(entryUrl)
+	if err != nil {
+		return entryUrl
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
+
+// readFeed retrieves an RSS or Atom feed and returns its entries.
+func readFeed(client *http.Client, feedUrl string) ([]feedEntry, error) {
+	resp, err := client.Get(feedUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	buf := &bytes.Buffer{}
+	if _, err := buf.ReadFrom(resp.Body); err != nil {
+		return nil, err
+	}
+
+	return parseFeed(buf.Bytes())
+}
+
+// parseFeed returns the entries of an RSS 2.0 or Atom feed.
+func parseFeed(data []byte) ([]feedEntry, error) {
+	var doc feedDocument
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	entries := []feedEntry{}
+	for _, item := range doc.Items {
+		link := strings.TrimSpace(item.Link)
+		if link == "" {
+			link = strings.TrimSpace(item.Guid)
+		}
+		if link == "" {
+			continue
+		}
+		entries = append(entries, feedEntry{Url: link, Date: parseFeedTime(item.PubDate)})
+	}
+
+	for _, entry := range doc.Entries {
+		var link string
+		for _, l := range entry.Links {
+			if l.Rel == "" || l.Rel == "alternate" {
+				link = strings.TrimSpace(l.Href)
+				break
+			}
+		}
+		if link == "" {
+			continue
+		}
+
+		date := parseFeedTime(entry.Published)
+		if date.IsZero() {
+			date = parseFeedTime(entry.Updated)
+		}
+		entries = append(entries, feedEntry{Url: link, Date: date})
+	}
+
+	return entries, nil
+}
+
+// Return Time representation of a feed date, or the zero Time if
+// the date is missing or malformed.
+func parseFeedTime(rawTime string) time.Time {
+	rawTime = strings.TrimSpace(rawTime)
+	for _, layout := range feedTimeLayouts {
+		t, err := time.Parse(layout, rawTime)
+		if err == nil {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,159 @@
+package fetcher
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/qwwqe/tcsuite/content"
+)
+
+var testRssFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+<channel>
+	<title>自由時報</title>
+	<link>https://news.ltn.com.tw</link>
+	<item>
+		<title>新文章</title>
+		<link>https://news.ltn.com.tw/news/politics/breakingnews/3001236?utm_source=rss</link>
+		<pubDate>Wed, 27 Nov 2019 09:10:00 +0800</pubDate>
+	</item>
+	<item>
+		<title>已儲存的文章</title>
+		<link>https://news.ltn.com.tw/news/politics/breakingnews/3001235</link>
+		<pubDate>Wed, 27 Nov 2019 08:00:00 +0800</pubDate>
+	</item>
+	<item>
+		<title>舊文章</title>
+		<link>https://news.ltn.com.tw/news/politics/breakingnews/3001234</link>
+		<pubDate>Tue, 26 Nov 2019 15:42:00 +0800</pubDate>
+	</item>
+	<item>
+		<title>專題</title>
+		<guid>https://news.ltn.com.tw/topic/election</guid>
+	</item>
+</channel>
+</rss>`
+
+var testAtomFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+	<title>女人迷</title>
+	<entry>
+		<title>單身日記</title>
+		<link rel="alternate" href="https://womany.net/read/article/21145"/>
+		<link rel="enclosure" href="https://images.womany.net/21145.jpg"/>
+		<published>2019-07-20T23:17:00+08:00</published>
+	</entry>
+	<entry>
+		<title>不是愛情</title>
+		<link href="https://womany.net/read/article/21146"/>
+		<updated>2019-07-21T10:00:00+08:00</updated>
+	</entry>
+</feed>`
+
+func TestParseFeed(t *testing.T) {
+	taipei := time.FixedZone("", 8*60*60)
+
+	entries, err := parseFeed([]byte(testRssFeed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []feedEntry{
+		{"https://news.ltn.com.tw/news/politics/breakingnews/3001236?utm_source=rss", time.Date(2019, 11, 27, 9, 10, 0, 0, taipei)},
+		{"https://news.ltn.com.tw/news/politics/breakingnews/3001235", time.Date(2019, 11, 27, 8, 0, 0, 0, taipei)},
+		{"https://news.ltn.com.tw/news/politics/breakingnews/3001234", time.Date(2019, 11, 26, 15, 42, 0, 0, taipei)},
+		{"https://news.ltn.com.tw/topic/election", time.Time{}},
+	}
+	checkFeedEntries(t, "rss", entries, want)
+
+	entries, err = parseFeed([]byte(testAtomFeed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []feedEntry{
+		{"https://womany.net/read/article/21145", time.Date(2019, 7, 20, 23, 17, 0, 0, taipei)},
+		{"https://womany.net/read/article/21146", time.Date(2019, 7, 21, 10, 0, 0, 0, taipei)},
+	}
+	checkFeedEntries(t, "atom", entries, want)
+
+	if _, err := parseFeed([]byte("<html><body>")); err == nil {
+		t.Errorf("parseFeed(html) = _, nil; want error")
+	}
+}
+
+func checkFeedEntries(t *testing.T, name string, got []feedEntry, want []feedEntry) {
+	if len(got) != len(want) {
+		t.Fatalf("%s: parseFeed() = %v; want %v", name, got, want)
+	}
+	for i := range got {
+		if got[i].Url != want[i].Url || !got[i].Date.Equal(want[i].Date) {
+			t.Errorf("%s: parseFeed()[%d] = %v; want %v", name, i, got[i], want[i])
+		}
+	}
+}
+
+func TestFeedFetcherDiscover(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rss/all.xml" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Write([]byte(testRssFeed))
+	}))
+	defer server.Close()
+
+	repo := newTestRepository()
+	repo.SaveContent(&content.FetchedContent{Uri: "https://news.ltn.com.tw/news/politics/breakingnews/3001235", Language: "zh-TW"})
+
+	// Unreadable feeds are skipped
+	f := NewLibertyFeedFetcher()
+	f.Feeds = []string{server.URL + "/missing.xml", server.URL + "/rss/all.xml"}
+	f.SetFetcherOptions(&FetcherOptions{Repository: repo})
+
+	stats := NewFetchStats(f.Name)
+	got, err := f.discover(server.Client(), FetchOptions{
+		AfterTime: time.Date(2019, 11, 26, 23, 0, 0, 0, time.UTC),
+	}, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []feedEntry{
+		{"https://news.ltn.com.tw/news/politics/breakingnews/3001236", time.Date(2019, 11, 27, 9, 10, 0, 0, time.FixedZone("", 8*60*60))},
+	}
+	if len(got) != len(want) || got[0].Url != want[0].Url || !got[0].Date.Equal(want[0].Date) {
+		t.Errorf("FeedFetcher.discover() = %v; want %v", got, want)
+	}
+	if duplicates := stats.Run().Duplicates; duplicates != 1 {
+		t.Errorf("FeedFetcher.discover() counted %d duplicates; want 1", duplicates)
+	}
+}
+
+func TestFeedFetcherDiscoverSitemap(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<url><loc>https://whogovernstw.org/2019/06/22/mingjuiyeh5/</loc></url>
+	<url><loc>https://whogovernstw.org/category/politics/</loc></url>
+	<url><loc>https://whogovernstw.org/about/</loc></url>
+</urlset>`))
+	}))
+	defer server.Close()
+
+	f := NewWhoGovernsTwFeedFetcher()
+	f.SetFetcherOptions(&FetcherOptions{Repository: newTestRepository()})
+
+	// Sitemap pages that are not articles are left out
+	got, err := f.discover(server.Client(), FetchOptions{Sitemap: server.URL + "/sitemap.xml"}, NewFetchStats(f.Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []feedEntry{{Url: "https://whogovernstw.org/2019/06/22/mingjuiyeh5/"}}
+	if len(got) != len(want) || got[0] != want[0] {
+		t.Errorf("FeedFetcher.discover() = %v; want %v", got, want)
+	}
+}
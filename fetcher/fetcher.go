@@ -1,8 +1,13 @@
 package fetcher
 
 import (
-	"github.com/qwwqe/tcsuite/repository"
+	"fmt"
+	"log/slog"
 	"time"
+
+	"github.com/qwwqe/tcsuite/content"
+	"github.com/qwwqe/tcsuite/logging"
+	"github.com/qwwqe/tcsuite/repository"
 )
 
 const CacheDir = "./cache/"
@@ -11,19 +16,27 @@ type Fetcher interface {
 	Fetch(options FetchOptions) error
 	SetFetcherOptions(options *FetcherOptions)
 	GetFetcherOptions() *FetcherOptions
+	// CanonName returns the canon name of the outlet fetched, under which
+	// the fetcher saves its crawl state and statistics.
+	CanonName() string
 }
 
 var Fetchers = []Fetcher{
-	&LibertyFetcher{}, &WhoGovernsTwFetcher{},
+	&TianxiaFetcher{},
 }
 
 type FetchOptions struct {
 	ArticleLimit int
 	BeforeTime   time.Time
 	AfterTime    time.Time
-	State        interface{}
+	State        *CrawlState // resume a crawl from a saved state
+
+	// Update marks a crawl for the articles published since the last,
+	// which revisits pages and so keeps its request history to itself.
+	Update bool
 
 	DeparturePoint string // starting url
+	Sitemap        string // if set, visit the articles listed in this sitemap instead of following links
 
 	MaxDepth    int
 	Async       bool
@@ -34,5 +47,44 @@ type FetchOptions struct {
 
 type FetcherOptions struct {
 	Repository repository.Repository
+	Logger     *slog.Logger // if nil, slog's default logger is used
 	//CanonName  string
+
+	// OnContentSaved, if set, is called with each article once it has been
+	// saved, along with its id, e.g. to tokenize it straight away. It is
+	// called concurrently by asynchronous fetchers, and holds up the fetch
+	// for as long as it runs.
+	OnContentSaved func(id int, c *content.FetchedContent)
+}
+
+// SaveContent saves c to the repository, calling the OnContentSaved hook
+// if it is saved.
+func (o *FetcherOptions) SaveContent(c *content.FetchedContent) (int, error) {
+	id, err := o.Repository.SaveContent(c)
+	if err != nil {
+		return id, err
+	}
+	if o.OnContentSaved != nil {
+		o.OnContentSaved(id, c)
+	}
+	return id, nil
+}
+
+// SiteLogger returns the logger of the fetcher of the named site.
+func (o *FetcherOptions) SiteLogger(site string) *slog.Logger {
+	var logger *slog.Logger
+	if o != nil {
+		logger = o.Logger
+	}
+	return logging.Component(logger, "fetcher").With(logging.SiteKey, site)
+}
+
+// NotArticleError is returned by Fetch in single-article mode
+// (FetchOptions.Uri) when the page found at Uri is not an article.
+type NotArticleError struct {
+	Uri string
+}
+
+func (e *NotArticleError) Error() string {
+	return fmt.Sprintf("not an article: %s", e.Uri)
 }
@@ -0,0 +1,45 @@
+package fetcher
+
+import (
+	"sync"
+
+	"github.com/qwwqe/tcsuite/content"
+	"github.com/qwwqe/tcsuite/repository"
+	"github.com/qwwqe/tcsuite/repository/memory"
+)
+
+// testRepository is an in-memory repository.Repository that records the
+// uris of saved content and can be made to fail to save.
+type testRepository struct {
+	*memory.Repository
+
+	mu      sync.Mutex
+	saved   []string
+	saveErr error // if set, returned by SaveContent
+}
+
+func newTestRepository() *testRepository {
+	return &testRepository{
+		Repository: memory.New(repository.RepositoryOptions{}),
+	}
+}
+
+func (r *testRepository) savedUris() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string{}, r.saved...)
+}
+
+func (r *testRepository) SaveContent(c *content.FetchedContent) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.saveErr != nil {
+		return 0, r.saveErr
+	}
+
+	id, err := r.Repository.SaveContent(c)
+	if err == nil {
+		r.saved = append(r.saved, c.Uri)
+	}
+	return id, err
+}
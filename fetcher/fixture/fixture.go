@@ -0,0 +1,142 @@
+// Package fixture tests article extraction offline, against pages saved
+// from each outlet's website.
+//
+// A fixture directory holds saved pages (<page>.html) next to the golden
+// result of extracting each one (<page>.json): the FetchedContent, or the
+// error if extraction fails. The url of a page is given by its canonical
+// link. Running the tests with -update regenerates the golden files:
+//
+//	go test ./fetcher/... -update
+package fixture
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/qwwqe/colly"
+	"github.com/qwwqe/tcsuite/content"
+)
+
+var update = flag.Bool("update", false, "regenerate the golden files of article fixtures")
+
+// Processor extracts the content of an article page, as the fetchers'
+// processArticle functions do.
+type Processor func(r *colly.Response, doc *goquery.Document) (*content.FetchedContent, error)
+
+// Golden is the recorded result of extracting a saved page.
+type Golden struct {
+	Error string `json:",omitempty"`
+	*content.FetchedContent
+}
+
+// Run extracts every saved page in dir with process, comparing the
+// result to the page's golden file or, with -update, rewriting it.
+func Run(t *testing.T, dir string, process Processor) {
+	pages, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) == 0 {
+		t.Fatalf("no saved pages in %s", dir)
+	}
+
+	for _, page := range pages {
+		page := page
+		t.Run(strings.TrimSuffix(filepath.Base(page), ".html"), func(t *testing.T) {
+			got, err := json.MarshalIndent(extract(t, page, process), "", "\t")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+
+			goldenFile := strings.TrimSuffix(page, ".html") + ".json"
+			if *update {
+				if err := os.WriteFile(goldenFile, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := os.ReadFile(goldenFile)
+			if os.IsNotExist(err) {
+				t.Fatalf("no golden file %s (run with -update to create it)", goldenFile)
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if !equalJson(t, got, want) {
+				t.Errorf("%s: extracted\n%s\nwant\n%s", page, got, want)
+			}
+		})
+	}
+}
+
+// Load parses a saved page, returning it along with its url.
+func Load(t *testing.T, page string) (*goquery.Document, string) {
+	data, err := os.ReadFile(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pageUrl, exists := doc.Find(`link[rel="canonical"]`).Attr("href")
+	if !exists {
+		t.Fatalf("%s: no canonical link", page)
+	}
+
+	return doc, pageUrl
+}
+
+// extract runs a saved page through process as if it had been fetched.
+func extract(t *testing.T, page string, process Processor) *Golden {
+	doc, pageUrl := Load(t, page)
+
+	u, err := url.Parse(pageUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := os.ReadFile(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &colly.Response{
+		StatusCode: 200,
+		Body:       body,
+		Request:    &colly.Request{URL: u},
+	}
+
+	fc, err := process(r, doc)
+	if err != nil {
+		return &Golden{Error: err.Error()}
+	}
+	return &Golden{FetchedContent: fc}
+}
+
+// equalJson reports whether two JSON documents hold the same values,
+// regardless of formatting.
+func equalJson(t *testing.T, a []byte, b []byte) bool {
+	var va, vb interface{}
+	if err := json.Unmarshal(a, &va); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &vb); err != nil {
+		t.Fatal(err)
+	}
+
+	ja, _ := json.Marshal(va)
+	jb, _ := json.Marshal(vb)
+	return bytes.Equal(ja, jb)
+}
@@ -0,0 +1,376 @@
+// Package generic implements a fetcher driven by a declarative site
+// definition, so that outlets which only differ in their selectors do
+// not each need a fetcher of their own.
+package generic
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/qwwqe/colly"
+	"github.com/qwwqe/tcsuite/content"
+	"github.com/qwwqe/tcsuite/fetcher"
+	"github.com/qwwqe/tcsuite/logging"
+)
+
+type Fetcher struct {
+	Site           *Site
+	FetcherOptions *fetcher.FetcherOptions
+}
+
+// New returns a fetcher for the outlet described by site.
+func New(site *Site) *Fetcher {
+	return &Fetcher{Site: site}
+}
+
+func (f *Fetcher) SetFetcherOptions(fetcherOptions *fetcher.FetcherOptions) {
+	f.FetcherOptions = fetcherOptions
+}
+
+func (f *Fetcher) GetFetcherOptions() *fetcher.FetcherOptions {
+	return f.FetcherOptions
+}
+
+func (f *Fetcher) CanonName() string {
+	return f.Site.Name
+}
+
+func (f *Fetcher) Fetch(fetchOptions fetcher.FetchOptions) error {
+	if fetchOptions.Uri != "" {
+		return f.fetchUri(fetchOptions.Uri)
+	}
+
+	site := f.Site
+	repo := f.GetFetcherOptions().Repository
+	logger := f.GetFetcherOptions().SiteLogger(site.Name)
+	counter := fetcher.NewArticleCounter(fetchOptions.ArticleLimit)
+	tracker := fetcher.NewCrawlTracker(repo, f.CanonName(), fetchOptions, logger)
+	stats := fetcher.NewFetchStats(f.CanonName())
+
+	c := colly.NewCollector(
+		colly.AllowedDomains(site.AllowedDomains...),
+		colly.DisallowedURLFilters(site.disallowedUrls...),
+		colly.CacheDir(fetcher.CacheDir+site.CacheDir),
+		colly.DisallowedCacheURLFilters(site.disallowedCacheUrls...),
+		colly.IgnoreRobotsTxt(),
+		colly.MaxDepth(fetchOptions.MaxDepth),
+		colly.Async(fetchOptions.Async),
+	)
+
+	if fetchOptions.Parallelism > 1 {
+		c.Limit(&colly.LimitRule{DomainGlob: "*", Parallelism: fetchOptions.Parallelism})
+	}
+
+	if err := c.SetStorage(fetcher.CrawlStorage(repo, fetchOptions)); err != nil {
+		logger.Error("error setting storage", logging.ErrorKey, err)
+		return err
+	}
+
+	c.OnRequest(func(r *colly.Request) {
+		// Requests cut short by the article limit stay on the frontier
+		tracker.QueueRequest(r)
+		if counter.LimitReached() {
+			r.Abort()
+			return
+		}
+		logger.Debug("visiting", logging.UrlKey, r.URL.String())
+	})
+
+	c.OnResponse(func(r *colly.Response) {
+		url := r.Request.URL.String()
+		logger.Debug("response", logging.UrlKey, url)
+		stats.PageVisited()
+
+		// Filter response by url
+		if !site.isArticle(url, nil) {
+			return
+		}
+
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(r.Body))
+		if err != nil {
+			logger.Warn("error parsing response body", logging.UrlKey, url, logging.ErrorKey, err)
+			return
+		}
+
+		// Filter response by content
+		if !site.isArticle(url, doc) {
+			return
+		}
+
+		// Filter response by date
+		articleDate := site.Date.time(doc)
+
+		if !fetchOptions.BeforeTime.IsZero() && !articleDate.Before(fetchOptions.BeforeTime) {
+			return
+		}
+
+		if !fetchOptions.AfterTime.IsZero() && !articleDate.After(fetchOptions.AfterTime) {
+			return
+		}
+
+		stats.ArticleFound()
+		fc, err := f.processArticle(url, doc)
+		if err != nil {
+			logger.Warn("extraction failed", logging.UrlKey, url, "field", err.Error())
+			stats.ExtractionFailed(err)
+			return
+		}
+		saved, err := stats.SaveArticle(f.GetFetcherOptions(), counter, fc)
+		if err != nil {
+			logger.Error("error saving article", logging.UrlKey, fc.Uri, logging.ErrorKey, err)
+		} else if saved {
+			logger.Info("article saved", logging.UrlKey, fc.Uri)
+			tracker.ArticleSaved(articleDate)
+		}
+	})
+
+	c.OnHTML("html", func(e *colly.HTMLElement) {
+		// Stop queuing requests once the article limit is reached
+		if counter.LimitReached() {
+			return
+		}
+
+		// Sitemap crawls visit only the articles listed in the sitemap
+		if fetchOptions.Sitemap != "" {
+			return
+		}
+
+		// The collector of a resumed crawl only knows the depth since resumption
+		if fetchOptions.MaxDepth > 0 && fetcher.RequestDepth(e.Request) >= fetchOptions.MaxDepth {
+			return
+		}
+
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(e.Response.Body))
+		if err != nil {
+			logger.Warn("error parsing response body", logging.UrlKey, e.Request.URL.String(), logging.ErrorKey, err)
+			return
+		}
+
+		isArticle := site.isArticle(e.Request.URL.String(), doc)
+		if isArticle {
+			articleDate := site.Date.time(doc)
+			if !articleDate.IsZero() {
+				if !fetchOptions.BeforeTime.IsZero() && !articleDate.Before(fetchOptions.BeforeTime) {
+					logger.Debug("article too new", logging.UrlKey, e.Request.URL.String())
+					return
+				}
+
+				if !fetchOptions.AfterTime.IsZero() && !articleDate.After(fetchOptions.AfterTime) {
+					logger.Debug("article too old", logging.UrlKey, e.Request.URL.String())
+					return
+				}
+			}
+		}
+
+		// colly's Visit() method does not support unspecified ('//' - http or https) notation,
+		// so add the schema before calling Visit()
+		hrefSelection := doc.Find(`a[href]`)
+		hrefSelection.Each(func(_ int, s *goquery.Selection) {
+			link, _ := s.Attr("href")
+			origUrl, err := url.Parse(link)
+			if err != nil {
+				logger.Debug("invalid link", logging.UrlKey, link, logging.ErrorKey, err)
+				return
+			}
+			origUrl.Scheme = "https"
+			if isArticle && site.StripLinkQueriesOnArticles {
+				origUrl.RawQuery = ""
+			}
+			e.Request.Visit(origUrl.String())
+		})
+	})
+
+	c.OnScraped(func(r *colly.Response) {
+		tracker.CompleteRequest(r.Request)
+	})
+
+	c.OnError(func(r *colly.Response, err error) {
+		tracker.CompleteRequest(r.Request)
+		logger.Warn("request failed", logging.UrlKey, r.Request.URL.String(), logging.ErrorKey, err)
+	})
+
+	if fetchOptions.State != nil && !fetchOptions.State.Finished() {
+		fetcher.ResumeCrawl(c, fetchOptions.State)
+	} else if fetchOptions.Sitemap != "" {
+		articleUrls, err := fetcher.ReadSitemap(nil, fetchOptions.Sitemap, fetchOptions, func(u string) bool {
+			return site.isArticle(u, nil)
+		}, logger)
+		if err != nil {
+			logger.Error("error reading sitemap", logging.UrlKey, fetchOptions.Sitemap, logging.ErrorKey, err)
+			return err
+		}
+		for _, articleUrl := range articleUrls {
+			c.Visit(articleUrl)
+		}
+	} else if fetchOptions.DeparturePoint != "" {
+		c.Visit(fetchOptions.DeparturePoint)
+	} else {
+		c.Visit(site.DeparturePoint)
+	}
+
+	if fetchOptions.Async {
+		c.Wait()
+	}
+
+	logger.Info("fetch finished", "saved", counter.Count())
+
+	if err := stats.Save(repo); err != nil {
+		logger.Error("error saving fetch statistics", logging.ErrorKey, err)
+	}
+
+	if err := tracker.Save(); err != nil {
+		logger.Error("error saving crawl state", logging.ErrorKey, err)
+		return err
+	}
+
+	return nil
+}
+
+// fetchUri fetches, processes and saves the single article found at uri.
+// Request history is neither consulted nor recorded, so that known
+// articles can be re-ingested.
+func (f *Fetcher) fetchUri(uri string) error {
+	site := f.Site
+	logger := f.GetFetcherOptions().SiteLogger(site.Name)
+
+	c := colly.NewCollector(
+		colly.AllowedDomains(site.AllowedDomains...),
+		colly.IgnoreRobotsTxt(),
+		colly.AllowURLRevisit(),
+	)
+
+	var fetchErr error
+
+	c.OnRequest(func(r *colly.Request) {
+		logger.Debug("visiting", logging.UrlKey, r.URL.String())
+	})
+
+	c.OnResponse(func(r *colly.Response) {
+		url := r.Request.URL.String()
+		logger.Debug("response", logging.UrlKey, url)
+
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(r.Body))
+		if err != nil {
+			logger.Warn("error parsing response body", logging.UrlKey, url, logging.ErrorKey, err)
+			fetchErr = errors.New("DOCPARSE")
+			return
+		}
+
+		if !site.isArticle(url, doc) {
+			logger.Warn("not an article", logging.UrlKey, url)
+			fetchErr = &fetcher.NotArticleError{Uri: uri}
+			return
+		}
+
+		fc, err := f.processArticle(url, doc)
+		if err != nil {
+			logger.Warn("extraction failed", logging.UrlKey, url, "field", err.Error())
+			fetchErr = err
+			return
+		}
+		if _, err := f.GetFetcherOptions().SaveContent(fc); err != nil {
+			logger.Error("error saving article", logging.UrlKey, fc.Uri, logging.ErrorKey, err)
+			fetchErr = err
+			return
+		}
+		logger.Info("article saved", logging.UrlKey, fc.Uri)
+	})
+
+	c.OnError(func(r *colly.Response, err error) {
+		logger.Warn("request failed", logging.UrlKey, r.Request.URL.String(), logging.ErrorKey, err)
+		fetchErr = err
+	})
+
+	if err := c.Visit(uri); err != nil {
+		return err
+	}
+
+	return fetchErr
+}
+
+func (f *Fetcher) processArticle(articleUrl string, doc *goquery.Document) (*content.FetchedContent, error) {
+	site := f.Site
+	fc := &content.FetchedContent{}
+
+	fc.Uri = articleUrl
+
+	// TITLE
+	// If no title is present, skip the article (it probably isn't an article).
+	title := site.Title.value(doc)
+	if title == "" {
+		return nil, errors.New("TITLE")
+	} else {
+		fc.Title = title
+	}
+
+	// PUBLICATION DATE
+	dateFormat := "2006-01-02 15:04:05"
+	date := site.Date.time(doc)
+
+	if date.IsZero() {
+		fc.Date = time.Now().Format(dateFormat)
+	} else {
+		fc.Date = date.Format(dateFormat)
+	}
+
+	// AUTHOR
+	author := site.Author.value(doc)
+	if author == "" {
+		fc.Author = site.DefaultAuthor
+	} else {
+		fc.Author = author
+	}
+
+	// ABSTRACT
+	abstract := site.Abstract.value(doc)
+	if abstract == "" {
+		fc.Abstract = fc.Title
+	} else {
+		fc.Abstract = abstract
+	}
+
+	// TAGS
+	tags := site.Tags.values(doc)
+
+	// Add the tag associated to this hostname
+	parsedUrl, err := url.Parse(fc.Uri)
+	if err == nil {
+		if tag, ok := site.DomainTags[parsedUrl.Hostname()]; ok {
+			tags = append(tags, tag)
+		}
+	}
+
+	// Add default media tags
+	for _, tag := range site.UniversalTags {
+		tags = append(tags, tag)
+	}
+
+	// Filter unique tags
+	tagMap := map[string]bool{}
+	for _, tag := range tags {
+		if !tagMap[tag] {
+			tagMap[tag] = true
+			fc.Tags = append(fc.Tags, tag)
+		}
+	}
+
+	// CANON NAME
+	fc.CanonName = site.Name
+
+	// BODY
+	bodyText := site.Body.text(doc)
+
+	if bodyText == "" {
+		return nil, errors.New("BODY")
+	} else {
+		fc.Body = bodyText
+	}
+
+	// LANGUAGE
+	fc.Language = site.Language
+
+	return fc, nil
+}
@@ -0,0 +1,174 @@
+package generic
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/qwwqe/colly"
+	"github.com/qwwqe/tcsuite/content"
+	"github.com/qwwqe/tcsuite/fetcher"
+	"github.com/qwwqe/tcsuite/fetcher/fixture"
+	"github.com/qwwqe/tcsuite/repository"
+	"github.com/qwwqe/tcsuite/repository/memory"
+)
+
+// TestSiteGolden runs the pages saved from each built-in site through its
+// definition. The golden files are shared with the site's own fetcher,
+// so the definition must extract exactly what that fetcher does.
+func TestSiteGolden(t *testing.T) {
+	sites, err := BuiltinSites()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, site := range sites {
+		f := New(site)
+		t.Run(site.Id, func(t *testing.T) {
+			fixture.Run(t, filepath.Join("..", "testdata", site.Id), func(r *colly.Response, doc *goquery.Document) (*content.FetchedContent, error) {
+				return f.processArticle(r.Request.URL.String(), doc)
+			})
+		})
+	}
+}
+
+func TestSiteIsArticle(t *testing.T) {
+	tests := []struct {
+		site string
+		page string
+		want bool
+	}{
+		{"liberty", "news-politics-3001234", true},
+		{"whogovernstw", "2019-06-22-china-dream", true},
+		{"whogovernstw", "category-politics", false},
+		{"womany", "read-article-21145", true},
+	}
+
+	for _, test := range tests {
+		site, err := BuiltinSite(test.site)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		doc, pageUrl := fixture.Load(t, filepath.Join("..", "testdata", test.site, test.page+".html"))
+		if got := site.isArticle(pageUrl, doc); got != test.want {
+			t.Errorf("%s: isArticle(%s) = %v; want %v", test.site, test.page, got, test.want)
+		}
+	}
+}
+
+// newSitesServer serves every saved page of the built-in sites at the path
+// of its canonical url, along with an article page lacking a title at
+// /read/article/0.
+func newSitesServer(t *testing.T) *httptest.Server {
+	pages, err := filepath.Glob(filepath.Join("..", "testdata", "*", "*.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	for _, page := range pages {
+		page := page
+		_, pageUrl := fixture.Load(t, page)
+		u, err := url.Parse(pageUrl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mux.HandleFunc(u.Path, func(w http.ResponseWriter, r *http.Request) {
+			http.ServeFile(w, r, page)
+		})
+	}
+	mux.HandleFunc("/read/article/0", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<html><body><article class="post"><p>沒有標題</p></article></body></html>`))
+	})
+
+	return httptest.NewServer(mux)
+}
+
+func TestFetchUri(t *testing.T) {
+	server := newSitesServer(t)
+	defer server.Close()
+
+	serverUrl, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		site    string
+		article string
+	}{
+		{"liberty", "/news/politics/breakingnews/3001234"},
+		{"whogovernstw", "/2019/06/22/mingjuiyeh5/"},
+		{"womany", "/read/article/21145"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.site, func(t *testing.T) {
+			site, err := BuiltinSite(test.site)
+			if err != nil {
+				t.Fatal(err)
+			}
+			site.AllowedDomains = append(site.AllowedDomains, serverUrl.Hostname())
+
+			repo := memory.New(repository.RepositoryOptions{})
+			f := New(site)
+			f.SetFetcherOptions(&fetcher.FetcherOptions{Repository: repo})
+
+			uri := server.URL + test.article
+			if err := f.Fetch(fetcher.FetchOptions{Uri: uri}); err != nil {
+				t.Fatalf("Fetcher.Fetch(%q) = %v; want nil", uri, err)
+			}
+			if saved, err := repo.ContentExists(uri); err != nil || !saved {
+				t.Errorf("Fetcher.Fetch(%q) did not save the article", uri)
+			}
+
+			uri = server.URL + "/category/politics/"
+			err = f.Fetch(fetcher.FetchOptions{Uri: uri})
+			if notArticle, ok := err.(*fetcher.NotArticleError); !ok || notArticle.Uri != uri {
+				t.Errorf("Fetcher.Fetch(%q) = %v; want &NotArticleError{%q}", uri, err, uri)
+			}
+
+			uri = server.URL + "/read/article/0"
+			err = f.Fetch(fetcher.FetchOptions{Uri: uri})
+			if err == nil || err.Error() != "TITLE" {
+				t.Errorf("Fetcher.Fetch(%q) = %v; want TITLE", uri, err)
+			}
+			if saved, _ := repo.ContentExists(uri); saved {
+				t.Errorf("Fetcher.Fetch(%q) saved the article", uri)
+			}
+		})
+	}
+}
+
+func TestParseSite(t *testing.T) {
+	tests := []struct {
+		name       string
+		definition string
+	}{
+		{"malformed", `{"id": "x"`},
+		{"no name", `{"id": "x", "language": "zh-TW"}`},
+		{"no article rule", `{"id": "x", "name": "X", "language": "zh-TW", "departure_point": "https://x.tw/", "allowed_domains": ["x.tw"],
+			"title": {"selector": "title"}, "body": {"selectors": ["article"], "paragraphs": "p"}}`},
+		{"bad regexp", `{"id": "x", "name": "X", "language": "zh-TW", "departure_point": "https://x.tw/", "allowed_domains": ["x.tw"],
+			"article": {"url": "/news/("}, "title": {"selector": "title"}, "body": {"selectors": ["article"], "paragraphs": "p"}}`},
+	}
+
+	for _, test := range tests {
+		if _, err := ParseSite([]byte(test.definition)); err == nil {
+			t.Errorf("%s: ParseSite() = _, nil; want error", test.name)
+		}
+	}
+
+	site, err := BuiltinSite("womany")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if site.Name != "女人迷" || !site.StripLinkQueriesOnArticles {
+		t.Errorf("BuiltinSite(womany) = %+v", site)
+	}
+}
@@ -0,0 +1,312 @@
+package generic
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Site definitions of the outlets built into tcsuite.
+//
+//go:embed sites/*.json
+var builtinSites embed.FS
+
+// Site is the declarative definition of an outlet: where to crawl,
+// how to recognise article pages and where on those pages each
+// field of the content is found.
+type Site struct {
+	Id             string `json:"id"`   // short ascii name, used for logging
+	Name           string `json:"name"` // canon name of the outlet
+	Language       string `json:"language"`
+	DeparturePoint string `json:"departure_point"`
+	CacheDir       string `json:"cache_dir"` // relative to fetcher.CacheDir
+
+	AllowedDomains      []string `json:"allowed_domains"`
+	DisallowedUrls      []string `json:"disallowed_urls"`       // regular expressions
+	DisallowedCacheUrls []string `json:"disallowed_cache_urls"` // regular expressions
+
+	UniversalTags []string          `json:"universal_tags"` // added to every article
+	DomainTags    map[string]string `json:"domain_tags"`    // added to articles on a given host
+
+	Article ArticleRule `json:"article"`
+
+	// Query strings are dropped from the links followed from article pages
+	StripLinkQueriesOnArticles bool `json:"strip_link_queries_on_articles"`
+
+	DefaultAuthor string    `json:"default_author"` // used when Author is unset or finds nothing
+	Date          Field     `json:"date"`
+	Title         Field     `json:"title"`
+	Author        Field     `json:"author"`
+	Abstract      Field     `json:"abstract"`
+	Tags          Field     `json:"tags"`
+	Body          BodyField `json:"body"`
+
+	disallowedUrls      []*regexp.Regexp
+	disallowedCacheUrls []*regexp.Regexp
+}
+
+// ArticleRule decides whether a page is an article. A page is an article
+// if its url matches Url and it contains an element matching Selector;
+// either may be left empty.
+type ArticleRule struct {
+	Url      string `json:"url"` // regular expression
+	Selector string `json:"selector"`
+
+	url *regexp.Regexp
+}
+
+// Field locates a single value on an article page. The value is taken from
+// the last element matching Selector, either its text or, if Attr is set,
+// the value of that attribute.
+type Field struct {
+	Selector  string `json:"selector"`
+	Attr      string `json:"attr"`
+	Trim      string `json:"trim"`      // regular expression; matching text is removed
+	Separator string `json:"separator"` // splits list values such as tags
+	Layout    string `json:"layout"`    // time layout of dates; RFC3339 if empty
+
+	trim *regexp.Regexp
+}
+
+// BodyField locates the article body. The first selector finding an
+// element wins; elements matching Remove are dropped from it, and the
+// text of the remaining elements matching Paragraphs forms the body.
+type BodyField struct {
+	Selectors  []string `json:"selectors"`
+	Remove     []string `json:"remove"`
+	Paragraphs string   `json:"paragraphs"`
+}
+
+// ParseSite parses and validates a JSON site definition.
+func ParseSite(data []byte) (*Site, error) {
+	site := &Site{}
+	if err := json.Unmarshal(data, site); err != nil {
+		return nil, err
+	}
+
+	if site.Id == "" || site.Name == "" || site.Language == "" {
+		return nil, fmt.Errorf("site definition lacks id, name or language")
+	}
+	if site.DeparturePoint == "" || len(site.AllowedDomains) == 0 {
+		return nil, fmt.Errorf("site %s: no departure point or allowed domains", site.Id)
+	}
+	if site.Article.Url == "" && site.Article.Selector == "" {
+		return nil, fmt.Errorf("site %s: no article rule", site.Id)
+	}
+	if site.Title.Selector == "" || len(site.Body.Selectors) == 0 || site.Body.Paragraphs == "" {
+		return nil, fmt.Errorf("site %s: no title or body selectors", site.Id)
+	}
+
+	var err error
+	if site.disallowedUrls, err = compileAll(site.DisallowedUrls); err != nil {
+		return nil, fmt.Errorf("site %s: %v", site.Id, err)
+	}
+	if site.disallowedCacheUrls, err = compileAll(site.DisallowedCacheUrls); err != nil {
+		return nil, fmt.Errorf("site %s: %v", site.Id, err)
+	}
+	if site.Article.url, err = compile(site.Article.Url); err != nil {
+		return nil, fmt.Errorf("site %s: %v", site.Id, err)
+	}
+	for _, field := range []*Field{&site.Date, &site.Title, &site.Author, &site.Abstract, &site.Tags} {
+		if field.trim, err = compile(field.Trim); err != nil {
+			return nil, fmt.Errorf("site %s: %v", site.Id, err)
+		}
+	}
+
+	return site, nil
+}
+
+// LoadSite reads a JSON site definition from a file.
+func LoadSite(filename string) (*Site, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSite(data)
+}
+
+// LoadSites reads every JSON site definition in dir.
+func LoadSites(dir string) ([]*Site, error) {
+	filenames, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	sites := []*Site{}
+	for _, filename := range filenames {
+		site, err := LoadSite(filename)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+		sites = append(sites, site)
+	}
+
+	return sites, nil
+}
+
+// BuiltinSites returns the site definitions built into tcsuite.
+func BuiltinSites() ([]*Site, error) {
+	filenames, err := builtinSites.ReadDir("sites")
+	if err != nil {
+		return nil, err
+	}
+
+	sites := []*Site{}
+	for _, filename := range filenames {
+		data, err := builtinSites.ReadFile(path.Join("sites", filename.Name()))
+		if err != nil {
+			return nil, err
+		}
+		site, err := ParseSite(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", filename.Name(), err)
+		}
+		sites = append(sites, site)
+	}
+
+	return sites, nil
+}
+
+// BuiltinSite returns the built-in site definition with the given id.
+func BuiltinSite(id string) (*Site, error) {
+	sites, err := BuiltinSites()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, site := range sites {
+		if site.Id == id {
+			return site, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no built-in site %q", id)
+}
+
+func compile(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile(expr)
+}
+
+func compileAll(exprs []string) ([]*regexp.Regexp, error) {
+	regexps := []*regexp.Regexp{}
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		regexps = append(regexps, re)
+	}
+	return regexps, nil
+}
+
+// isArticle reports whether the page at pageUrl is an article.
+// If doc is nil, only the url is considered.
+func (s *Site) isArticle(pageUrl string, doc *goquery.Document) bool {
+	if s.Article.url != nil && !s.Article.url.MatchString(pageUrl) {
+		return false
+	}
+	if s.Article.Selector != "" && doc != nil {
+		return doc.Find(s.Article.Selector).Length() > 0
+	}
+	return true
+}
+
+// value returns the value of field on the page, or "" if it is not found.
+func (f *Field) value(doc *goquery.Document) string {
+	values := f.values(doc)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[len(values)-1]
+}
+
+// values returns the values of every element matching field, split by
+// the field's separator.
+func (f *Field) values(doc *goquery.Document) []string {
+	values := []string{}
+	if f.Selector == "" {
+		return values
+	}
+
+	doc.Find(f.Selector).Each(func(_ int, s *goquery.Selection) {
+		var value string
+		if f.Attr == "" {
+			value = s.Text()
+		} else {
+			attr, exists := s.Attr(f.Attr)
+			if !exists {
+				return
+			}
+			value = attr
+		}
+
+		if f.trim != nil {
+			value = f.trim.ReplaceAllLiteralString(value, "")
+		}
+
+		parts := []string{value}
+		if f.Separator != "" {
+			parts = strings.Split(value, f.Separator)
+		}
+		for _, part := range parts {
+			trimmed := strings.TrimSpace(part)
+			if trimmed != "" {
+				values = append(values, trimmed)
+			}
+		}
+	})
+
+	return values
+}
+
+// time returns the value of a date field as a Time, or the zero
+// Time if it is not found.
+func (f *Field) time(doc *goquery.Document) time.Time {
+	layout := f.Layout
+	if layout == "" {
+		layout = time.RFC3339
+	}
+
+	date, _ := time.Parse(layout, f.value(doc))
+	return date
+}
+
+// text returns the article body, or "" if it is not found.
+func (b *BodyField) text(doc *goquery.Document) string {
+	var bodySelector *goquery.Selection
+	for _, selector := range b.Selectors {
+		bodySelector = doc.Find(selector).First()
+		if bodySelector.Length() > 0 {
+			break
+		}
+	}
+
+	if bodySelector == nil || bodySelector.Length() == 0 {
+		return ""
+	}
+
+	for _, selector := range b.Remove {
+		bodySelector.Find(selector).Remove()
+	}
+	paraSelectors := bodySelector.Find(b.Paragraphs)
+	paras := []string{}
+	paraSelectors.Each(func(_ int, s *goquery.Selection) {
+		trimmedText := strings.TrimSpace(s.Text())
+		if trimmedText != "" {
+			paras = append(paras, trimmedText)
+		}
+	})
+
+	return strings.Join(paras, "\n\n")
+}
@@ -3,7 +3,6 @@ package fetcher
 import (
 	"bytes"
 	"errors"
-	"fmt"
 	"golang.org/x/text/language"
 	"net/url"
 	"regexp"
@@ -16,10 +15,6 @@ import (
 	"github.com/qwwqe/tcsuite/content"
 )
 
-type LibertyFetcher struct {
-	FetcherOptions *FetcherOptions
-}
-
 var domains = map[string]string{
 	"www.ltn.com.tw":     "新聞",
 	"news.ltn.com.tw":    "新聞",
@@ -36,163 +31,33 @@ var domains = map[string]string{
 	"estate.ltn.com.tw":  "地產",
 }
 
-var disallowedUrls = []*regexp.Regexp{
-	regexp.MustCompile("/assets/"),
-	regexp.MustCompile("/print$"),
-	regexp.MustCompile("/m/"),
-}
-
-var disallowedCacheUrls = []*regexp.Regexp{
-	regexp.MustCompile("ltn.com.tw/?$"),
-	regexp.MustCompile("/list/"),
-}
-
 var universalTags = []string{
 	"新聞",
 	"報紙",
 	"自由時報",
 }
 
-var defaultDeparturePoint = "https://www.ltn.com.tw/"
 var canonName = "自由時報"
-var cacheDir = "./cache/liberty_cache"
 var ltyLanguage = language.MustParse("zh-tw").String()
-
-var successful = 0
-
-func fetchLogf(format string, a ...interface{}) (n int, err error) {
-	return fmt.Printf("[LIBERTY FETCHER] "+format, a...)
-}
-
-func (f *LibertyFetcher) SetFetcherOptions(fetcherOptions *FetcherOptions) {
-	f.FetcherOptions = fetcherOptions
+var feeds = []string{
+	"https://news.ltn.com.tw/rss/all.xml",
 }
 
-func (f *LibertyFetcher) GetFetcherOptions() *FetcherOptions {
-	return f.FetcherOptions
-}
-
-func (f *LibertyFetcher) Fetch(fetchOptions FetchOptions) error {
-	successful = 0
-
-	repo := f.GetFetcherOptions().Repository
-
+// NewLibertyFeedFetcher returns a fetcher of the articles in the Liberty Times feeds.
+func NewLibertyFeedFetcher() *FeedFetcher {
 	allowedDomains := make([]string, 0, len(domains))
 	for domain := range domains {
 		allowedDomains = append(allowedDomains, domain)
 	}
 
-	c := colly.NewCollector(
-		colly.AllowedDomains(allowedDomains...),
-		colly.DisallowedURLFilters(disallowedUrls...),
-		colly.CacheDir(cacheDir),
-		colly.DisallowedCacheURLFilters(disallowedCacheUrls...),
-		colly.IgnoreRobotsTxt(),
-		colly.MaxDepth(fetchOptions.MaxDepth),
-		colly.Async(fetchOptions.Async),
-	)
-
-	if fetchOptions.Parallelism > 1 {
-		c.Limit(&colly.LimitRule{DomainGlob: "*", Parallelism: fetchOptions.Parallelism})
-	}
-
-	if err := c.SetStorage(repo); err != nil {
-		fetchLogf("Error setting storage: %v\n", err)
-		return err
-	}
-
-	c.OnRequest(func(r *colly.Request) {
-		fetchLogf("VISITING: %s\n", r.URL.String())
-	})
-
-	c.OnResponse(func(r *colly.Response) {
-		url := r.Request.URL.String()
-		fetchLogf("RESPONSE: %s\n", url)
-
-		// Filter response by url
-		isArticle, _ := regexp.MatchString(`/\d+$`, url)
-		if !isArticle {
-			return
-		}
-
-		// Filter response by date
-		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(r.Body))
-		if err != nil {
-			fetchLogf("ERROR PROCESSING RESPONSE BODY: %v\n", err)
-			return
-		}
-		articleDate := getArticleDate(doc)
-
-		if !fetchOptions.BeforeTime.IsZero() && !articleDate.Before(fetchOptions.BeforeTime) {
-			return
-		}
-
-		if !fetchOptions.AfterTime.IsZero() && !articleDate.After(fetchOptions.AfterTime) {
-			return
-		}
-
-		fc, err := processArticle(r, doc)
-		if err == nil {
-			repo.SaveContent(fc)
-		}
-	})
-
-	c.OnHTML("html", func(e *colly.HTMLElement) {
-		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(e.Response.Body))
-		if err != nil {
-			fetchLogf("ERROR PROCESSING RESPONSE BODY: %v\n", err)
-			return
-		}
-
-		isArticle, _ := regexp.MatchString(`/\d+$`, e.Request.URL.String())
-		if isArticle {
-			articleDate := getArticleDate(doc)
-			if !articleDate.IsZero() {
-				if !fetchOptions.BeforeTime.IsZero() && !articleDate.Before(fetchOptions.BeforeTime) {
-					fetchLogf("FAILED (TOO NEW)\n")
-					return
-				}
-
-				if !fetchOptions.AfterTime.IsZero() && !articleDate.After(fetchOptions.AfterTime) {
-					fetchLogf("FAILED (TOO OLD)\n")
-					return
-				}
-			}
-		}
-
-		// colly's Visit() method does not support unspecified ('//' - http or https) notation,
-		// so add the schema before calling Visit()
-		hrefSelection := doc.Find(`a[href]`)
-		hrefSelection.Each(func(_ int, s *goquery.Selection) {
-			link, _ := s.Attr("href")
-			origUrl, err := url.Parse(link)
-			if err != nil {
-				fetchLogf("ERROR: %v (%s)\n", err, link)
-				return
-			}
-			origUrl.Scheme = "https"
-			//c.Visit(e.Request.AbsoluteURL(origUrl.String()))
-			e.Request.Visit(origUrl.String())
-		})
-	})
-
-	c.OnError(func(r *colly.Response, err error) {
-		fetchLogf("ERROR: %v\n", err)
-	})
-
-	if fetchOptions.DeparturePoint != "" {
-		c.Visit(fetchOptions.DeparturePoint)
-	} else {
-		c.Visit(defaultDeparturePoint)
-	}
-
-	if fetchOptions.Async {
-		c.Wait()
+	return &FeedFetcher{
+		Name:           canonName,
+		Feeds:          feeds,
+		AllowedDomains: allowedDomains,
+		ArticleUrl:     regexp.MustCompile(`/\d+$`),
+		StripQueries:   true,
+		Process:        processArticle,
 	}
-
-	fetchLogf("TOTAL SUCCESSFUL: %d\n", successful)
-
-	return nil
 }
 
 // Return Time representation of article's publication date.
@@ -303,14 +168,12 @@ func getArticleBody(doc *goquery.Document) string {
 }
 
 func processArticle(r *colly.Response, doc *goquery.Document) (*content.FetchedContent, error) {
-	fetchLogf("PROCESS: %s\n", r.Request.URL.String())
 	fc := &content.FetchedContent{}
 
 	var err error
 	if doc == nil {
 		doc, err = goquery.NewDocumentFromReader(bytes.NewReader(r.Body))
 		if err != nil {
-			fetchLogf("ERROR PROCESSING RESPONSE BODY: %v\n", err)
 			return nil, errors.New("DOCPARSE")
 		}
 	}
@@ -321,7 +184,6 @@ func processArticle(r *colly.Response, doc *goquery.Document) (*content.FetchedC
 	// If no <title> tag is present, skip the article (it probably isn't an article).
 	title := getArticleTitle(doc)
 	if title == "" {
-		fetchLogf("FAILED (TITLE): %s\n", fc.Uri)
 		return nil, errors.New("TITLE")
 	} else {
 		fc.Title = title
@@ -385,7 +247,6 @@ func processArticle(r *colly.Response, doc *goquery.Document) (*content.FetchedC
 	bodyText := getArticleBody(doc)
 
 	if bodyText == "" {
-		fetchLogf("FAILED (BODY): %s\n", fc.Uri)
 		return nil, errors.New("BODY")
 	} else {
 		fc.Body = bodyText
@@ -394,7 +255,5 @@ func processArticle(r *colly.Response, doc *goquery.Document) (*content.FetchedC
 	// LANGUAGE
 	fc.Language = ltyLanguage
 
-	fetchLogf("SUCCESS: %s\n", fc.Uri)
-	successful++
 	return fc, nil
 }
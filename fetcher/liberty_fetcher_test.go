@@ -0,0 +1,11 @@
+package fetcher
+
+import (
+	"testing"
+
+	"github.com/qwwqe/tcsuite/fetcher/fixture"
+)
+
+func TestLibertyProcessArticle(t *testing.T) {
+	fixture.Run(t, "testdata/liberty", processArticle)
+}
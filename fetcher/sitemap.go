@@ -0,0 +1,165 @@
+package fetcher
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/qwwqe/tcsuite/logging"
+)
+
+// Sitemap indexes nested deeper than this are not followed.
+const maxSitemapDepth = 5
+
+// sitemapDocument holds either a sitemap index or a url set.
+type sitemapDocument struct {
+	XMLName  xml.Name
+	Sitemaps []sitemapLocation `xml:"sitemap"`
+	Urls     []sitemapLocation `xml:"url"`
+}
+
+type sitemapLocation struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// Layouts of the W3C datetime format used by 'lastmod'.
+var sitemapTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// ReadSitemap reads the sitemap at sitemapUrl, following sitemap indexes
+// and decompressing gzipped sitemaps, and returns the urls it lists.
+// Entries whose 'lastmod' falls outside the AfterTime and BeforeTime of
+// fetchOptions are left out, as are entries isArticle rejects (if it is
+// not nil). Nested sitemaps that cannot be read are logged to logger,
+// which may be nil, and skipped.
+func ReadSitemap(client *http.Client, sitemapUrl string, fetchOptions FetchOptions, isArticle func(string) bool, logger *slog.Logger) ([]string, error) {
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	if logger == nil {
+		logger = logging.Component(nil, "fetcher")
+	}
+
+	urls := []string{}
+	seen := map[string]bool{}
+
+	var read func(sitemapUrl string, depth int) error
+	read = func(sitemapUrl string, depth int) error {
+		doc, err := getSitemap(client, sitemapUrl)
+		if err != nil {
+			return err
+		}
+
+		for _, sitemap := range doc.Sitemaps {
+			loc := strings.TrimSpace(sitemap.Loc)
+			if loc == "" || seen[loc] || depth >= maxSitemapDepth {
+				continue
+			}
+			seen[loc] = true
+
+			// A sitemap last modified before AfterTime holds nothing newer
+			lastMod := parseSitemapTime(sitemap.LastMod)
+			if !lastMod.IsZero() && !fetchOptions.AfterTime.IsZero() && !lastMod.After(fetchOptions.AfterTime) {
+				continue
+			}
+
+			if err := read(loc, depth+1); err != nil {
+				logger.Warn("error reading sitemap", logging.UrlKey, loc, logging.ErrorKey, err)
+			}
+		}
+
+		for _, entry := range doc.Urls {
+			loc := strings.TrimSpace(entry.Loc)
+			if loc == "" || seen[loc] {
+				continue
+			}
+			seen[loc] = true
+
+			lastMod := parseSitemapTime(entry.LastMod)
+			if !lastMod.IsZero() {
+				if !fetchOptions.BeforeTime.IsZero() && !lastMod.Before(fetchOptions.BeforeTime) {
+					continue
+				}
+				if !fetchOptions.AfterTime.IsZero() && !lastMod.After(fetchOptions.AfterTime) {
+					continue
+				}
+			}
+
+			if isArticle != nil && !isArticle(loc) {
+				continue
+			}
+
+			urls = append(urls, loc)
+		}
+
+		return nil
+	}
+
+	if err := read(sitemapUrl, 0); err != nil {
+		return nil, err
+	}
+
+	return urls, nil
+}
+
+// getSitemap retrieves and decodes a single sitemap or sitemap index.
+// Gzipped sitemaps are recognised by their content rather than their
+// name, as servers label them inconsistently.
+func getSitemap(client *http.Client, sitemapUrl string) (*sitemapDocument, error) {
+	resp, err := client.Get(sitemapUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	body := bufio.NewReader(resp.Body)
+	var reader io.Reader = body
+	if magic, err := body.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzipReader, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	doc := &sitemapDocument{}
+	if err := xml.NewDecoder(reader).Decode(doc); err != nil {
+		return nil, err
+	}
+
+	if doc.XMLName.Local != "sitemapindex" && doc.XMLName.Local != "urlset" {
+		return nil, fmt.Errorf("not a sitemap: <%s>", doc.XMLName.Local)
+	}
+
+	return doc, nil
+}
+
+// Return Time representation of a 'lastmod' value, or the zero Time if
+// it is missing or malformed.
+func parseSitemapTime(rawTime string) time.Time {
+	rawTime = strings.TrimSpace(rawTime)
+	for _, layout := range sitemapTimeLayouts {
+		t, err := time.Parse(layout, rawTime)
+		if err == nil {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,116 @@
+package fetcher
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newSitemapServer serves a sitemap index at /sitemap.xml, pointing to a
+// recent gzipped sitemap, an old sitemap and a missing one.
+func newSitemapServer(t *testing.T) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		base := server.URL
+		switch r.URL.Path {
+		case "/sitemap.xml":
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<sitemap><loc>` + base + `/sitemap-2019-11.xml.gz</loc><lastmod>2019-11-27T09:10:00+08:00</lastmod></sitemap>
+	<sitemap><loc>` + base + `/sitemap-2019-10.xml</loc><lastmod>2019-10-31</lastmod></sitemap>
+	<sitemap><loc>` + base + `/sitemap-missing.xml</loc></sitemap>
+</sitemapindex>`))
+		case "/sitemap-2019-11.xml.gz":
+			buf := &bytes.Buffer{}
+			gz := gzip.NewWriter(buf)
+			gz.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<url><loc>` + base + `/news/politics/3001236</loc><lastmod>2019-11-27T09:10:00+08:00</lastmod></url>
+	<url><loc>` + base + `/news/politics/3001234</loc><lastmod>2019-11-26T15:42:00+08:00</lastmod></url>
+	<url><loc>` + base + `/list/politics</loc><lastmod>2019-11-27T10:00:00+08:00</lastmod></url>
+	<url><loc>` + base + `/news/life/3001240</loc></url>
+	<url><loc>` + base + `/news/politics/3001236</loc></url>
+</urlset>`))
+			gz.Close()
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(buf.Bytes())
+		case "/sitemap-2019-10.xml":
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<url><loc>` + base + `/news/politics/2987654</loc><lastmod>2019-10-17</lastmod></url>
+</urlset>`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return server
+}
+
+func TestReadSitemap(t *testing.T) {
+	server := newSitemapServer(t)
+	defer server.Close()
+
+	isArticle := regexp.MustCompile(`/\d+$`).MatchString
+
+	tests := []struct {
+		name    string
+		options FetchOptions
+		want    []string
+	}{
+		{
+			name:    "all",
+			options: FetchOptions{},
+			want: []string{
+				"/news/politics/3001236",
+				"/news/politics/3001234",
+				"/news/life/3001240",
+				"/news/politics/2987654",
+			},
+		},
+		{
+			name: "after time",
+			options: FetchOptions{
+				AfterTime: time.Date(2019, 11, 27, 0, 0, 0, 0, time.UTC),
+			},
+			want: []string{
+				"/news/politics/3001236",
+				"/news/life/3001240",
+			},
+		},
+		{
+			name: "before time",
+			options: FetchOptions{
+				BeforeTime: time.Date(2019, 11, 1, 0, 0, 0, 0, time.UTC),
+			},
+			want: []string{
+				"/news/life/3001240",
+				"/news/politics/2987654",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		urls, err := ReadSitemap(server.Client(), server.URL+"/sitemap.xml", test.options, isArticle, nil)
+		if err != nil {
+			t.Fatalf("%s: ReadSitemap() = _, %v", test.name, err)
+		}
+
+		got := []string{}
+		for _, u := range urls {
+			got = append(got, strings.TrimPrefix(u, server.URL))
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: ReadSitemap() = %v; want %v", test.name, got, test.want)
+		}
+	}
+
+	if _, err := ReadSitemap(server.Client(), server.URL+"/sitemap-missing.xml", FetchOptions{}, nil, nil); err == nil {
+		t.Errorf("ReadSitemap(missing) = _, nil; want error")
+	}
+}
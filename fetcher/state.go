@@ -0,0 +1,403 @@
+package fetcher
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/qwwqe/colly"
+	"github.com/qwwqe/colly/storage"
+	"github.com/qwwqe/tcsuite/logging"
+	"github.com/qwwqe/tcsuite/repository"
+)
+
+// CrawlState is the frontier of a crawl: the requests that were queued but
+// not yet completed, and the publication date of the newest article saved.
+// Fetchers record their state in the repository as they go, and passing a
+// saved state as FetchOptions.State resumes the crawl where it left off.
+type CrawlState struct {
+	Pending         []PendingRequest `json:"pending"`
+	LastArticleDate time.Time        `json:"last_article_date"`
+
+	// Visited holds the ids of the articles visited by fetchers that keep
+	// no request history, so that a resumed crawl does not visit them
+	// again.
+	Visited IdRanges `json:"visited,omitempty"`
+}
+
+// IdRanges is a set of ids held as sorted, disjoint ranges, which stays
+// small for crawls working their way through consecutive ids.
+type IdRanges []IdRange
+
+// IdRange is the range of ids from First to Last, inclusive.
+type IdRange struct {
+	First int `json:"first"`
+	Last  int `json:"last"`
+}
+
+// Contains reports whether id is in r.
+func (r IdRanges) Contains(id int) bool {
+	i := sort.Search(len(r), func(i int) bool { return r[i].Last >= id })
+	return i < len(r) && r[i].First <= id
+}
+
+// Add returns r with id added, modifying r in place.
+func (r IdRanges) Add(id int) IdRanges {
+	// The first range ending no earlier than just before id is the only one
+	// id can join
+	i := sort.Search(len(r), func(i int) bool { return r[i].Last >= id-1 })
+	if i == len(r) || r[i].First > id+1 {
+		r = append(r, IdRange{})
+		copy(r[i+1:], r[i:])
+		r[i] = IdRange{First: id, Last: id}
+		return r
+	}
+
+	if id < r[i].First {
+		r[i].First = id
+	} else if id > r[i].Last {
+		r[i].Last = id
+		if i+1 < len(r) && r[i+1].First == id+1 {
+			r[i].Last = r[i+1].Last
+			r = append(r[:i+1], r[i+2:]...)
+		}
+	}
+	return r
+}
+
+// Union returns the ids in either r or other, leaving both as they are.
+func (r IdRanges) Union(other IdRanges) IdRanges {
+	all := append(append(IdRanges{}, r...), other...)
+	sort.Slice(all, func(i, j int) bool { return all[i].First < all[j].First })
+
+	union := IdRanges{}
+	for _, next := range all {
+		if n := len(union); n > 0 && next.First <= union[n-1].Last+1 {
+			if next.Last > union[n-1].Last {
+				union[n-1].Last = next.Last
+			}
+			continue
+		}
+		union = append(union, next)
+	}
+	return union
+}
+
+// PendingRequest is a queued request and its depth within the crawl.
+type PendingRequest struct {
+	Url   string `json:"url"`
+	Depth int    `json:"depth"`
+}
+
+// Finished reports whether the crawl ran to completion.
+func (s *CrawlState) Finished() bool {
+	return len(s.Pending) == 0
+}
+
+// UpdateAfterTime returns the AfterTime of an update following the crawl
+// that left s: no earlier than the newest article saved by the crawl, if
+// it finished, as it has seen the articles before it. afterTime is
+// returned unchanged if s is nil or the crawl was interrupted, in which
+// case it is for a resumed crawl to catch up.
+func (s *CrawlState) UpdateAfterTime(afterTime time.Time) time.Time {
+	if s == nil || !s.Finished() || !s.LastArticleDate.After(afterTime) {
+		return afterTime
+	}
+	return s.LastArticleDate
+}
+
+// LoadCrawlState retrieves the state last saved by the named fetcher.
+// If no state has been saved, nil is returned.
+func LoadCrawlState(repo repository.Repository, name string) (*CrawlState, error) {
+	data, err := repo.GetFetchState(name)
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	state := &CrawlState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// Number of changes to the frontier between saves of the crawl state.
+const crawlStateSaveInterval = 25
+
+// CrawlTracker keeps track of the frontier of a crawl and periodically
+// saves it to the repository under the fetcher's name. It is safe for
+// concurrent use by the callbacks of an asynchronous collector.
+type CrawlTracker struct {
+	mu              sync.Mutex
+	repo            repository.Repository
+	name            string
+	logger          *slog.Logger
+	pending         map[string]int
+	requests        map[uint32]string
+	lastArticleDate time.Time
+	visited         IdRanges
+	update          bool // the saved frontier is left as it is
+	unsaved         int
+}
+
+// NewCrawlTracker returns a tracker for the named fetcher crawling with
+// fetchOptions. If fetchOptions.State is set, the tracker starts out with
+// its frontier. The tracker of an update saves the newest article and the
+// visited ids alongside the frontier saved last, so that an interrupted
+// crawl can still be resumed. Periodic saves that fail are reported to
+// logger, which may be nil.
+func NewCrawlTracker(repo repository.Repository, name string, fetchOptions FetchOptions, logger *slog.Logger) *CrawlTracker {
+	if logger == nil {
+		logger = logging.Component(nil, "fetcher").With(logging.SiteKey, name)
+	}
+
+	t := &CrawlTracker{
+		repo:     repo,
+		name:     name,
+		logger:   logger,
+		pending:  map[string]int{},
+		requests: map[uint32]string{},
+		update:   fetchOptions.Update,
+	}
+
+	if state := fetchOptions.State; state != nil {
+		for _, p := range state.Pending {
+			t.pending[p.Url] = p.Depth
+		}
+		t.lastArticleDate = state.LastArticleDate
+		t.visited = state.Visited.Union(nil)
+	}
+
+	return t
+}
+
+// Queue adds url at the given depth to the frontier.
+func (t *CrawlTracker) Queue(url string, depth int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.pending[url] = depth
+	t.changed()
+}
+
+// Complete removes url from the frontier.
+func (t *CrawlTracker) Complete(url string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.pending, url)
+	t.changed()
+}
+
+// QueueRequest adds a colly request to the frontier.
+// It should be called from the collector's OnRequest callback.
+func (t *CrawlTracker) QueueRequest(r *colly.Request) {
+	t.mu.Lock()
+	t.requests[r.ID] = r.URL.String()
+	t.mu.Unlock()
+
+	t.Queue(r.URL.String(), RequestDepth(r))
+}
+
+// CompleteRequest removes a colly request from the frontier. It should be
+// called from the collector's OnScraped and OnError callbacks.
+// Requests are matched by id, as a redirect changes the request's url.
+func (t *CrawlTracker) CompleteRequest(r *colly.Request) {
+	t.mu.Lock()
+	url, ok := t.requests[r.ID]
+	delete(t.requests, r.ID)
+	t.mu.Unlock()
+
+	if !ok {
+		url = r.URL.String()
+	}
+	t.Complete(url)
+}
+
+// ArticleSaved records the publication date of a saved article, if it is
+// the newest saved so far. Asynchronous crawls save articles in no
+// particular order.
+func (t *CrawlTracker) ArticleSaved(date time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if date.After(t.lastArticleDate) {
+		t.lastArticleDate = date
+	}
+}
+
+// ArticleVisited records the id of a visited article, for fetchers that
+// keep no request history. It should be called before the article's
+// request is completed, so that the two are saved together.
+func (t *CrawlTracker) ArticleVisited(id int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.visited = t.visited.Add(id)
+}
+
+// State returns a snapshot of the crawl state.
+func (t *CrawlTracker) State() *CrawlState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.state()
+}
+
+// Save writes the crawl state to the repository.
+func (t *CrawlTracker) Save() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.save()
+}
+
+func (t *CrawlTracker) changed() {
+	t.unsaved++
+	if t.unsaved < crawlStateSaveInterval {
+		return
+	}
+
+	if err := t.save(); err != nil {
+		t.logger.Error("error saving crawl state", logging.ErrorKey, err)
+	}
+}
+
+func (t *CrawlTracker) state() *CrawlState {
+	state := &CrawlState{
+		Pending:         []PendingRequest{},
+		LastArticleDate: t.lastArticleDate,
+	}
+
+	for url, depth := range t.pending {
+		state.Pending = append(state.Pending, PendingRequest{Url: url, Depth: depth})
+	}
+
+	// Shallowest requests first, so a resumed crawl proceeds breadth first
+	sort.Slice(state.Pending, func(i, j int) bool {
+		if state.Pending[i].Depth != state.Pending[j].Depth {
+			return state.Pending[i].Depth < state.Pending[j].Depth
+		}
+		return state.Pending[i].Url < state.Pending[j].Url
+	})
+
+	if len(t.visited) > 0 {
+		state.Visited = append(IdRanges{}, t.visited...)
+	}
+
+	return state
+}
+
+func (t *CrawlTracker) save() error {
+	state := t.state()
+	if t.update {
+		saved, err := LoadCrawlState(t.repo, t.name)
+		if err != nil {
+			return err
+		}
+		if saved != nil {
+			state.Pending = saved.Pending
+			if saved.LastArticleDate.After(state.LastArticleDate) {
+				state.LastArticleDate = saved.LastArticleDate
+			}
+			state.Visited = state.Visited.Union(saved.Visited)
+		}
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	if err := t.repo.SaveFetchState(t.name, data); err != nil {
+		return err
+	}
+
+	t.unsaved = 0
+	return nil
+}
+
+// Key of the request context value holding the depth a resumed crawl
+// had already reached before it was interrupted.
+const depthOffsetKey = "crawlDepthOffset"
+
+// RequestDepth returns the depth of r within the whole crawl, counting
+// the depth reached before the crawl was resumed.
+// Collectors only know the depth since resumption, so fetchers must check
+// MaxDepth against this depth themselves before following links.
+func RequestDepth(r *colly.Request) int {
+	if offset, ok := r.Ctx.GetAny(depthOffsetKey).(int); ok {
+		return r.Depth + offset
+	}
+	return r.Depth
+}
+
+// ResumeCrawl queues the pending requests of state on c. The collector's
+// storage must have been wrapped with ResumeStorage, as pending requests
+// were recorded as visited when they were first queued.
+func ResumeCrawl(c *colly.Collector, state *CrawlState) {
+	for _, p := range state.Pending {
+		ctx := colly.NewContext()
+		ctx.Put(depthOffsetKey, p.Depth-1)
+		c.Request("GET", p.Url, nil, ctx, nil)
+	}
+}
+
+// CrawlStorage returns the storage of the request history of a crawl with
+// fetchOptions: that of repo, resuming fetchOptions.State if set, or, for
+// an update, a history of its own, which leaves that of an interrupted
+// crawl as it is.
+func CrawlStorage(repo repository.CollyStorage, fetchOptions FetchOptions) repository.CollyStorage {
+	if fetchOptions.Update {
+		return &storage.InMemoryStorage{}
+	}
+	return ResumeStorage(repo, fetchOptions.State)
+}
+
+// ResumeStorage wraps base so that the pending requests of state are
+// reported as not yet visited, once each. If state is nil, base is
+// returned unchanged.
+func ResumeStorage(base repository.CollyStorage, state *CrawlState) repository.CollyStorage {
+	if state == nil || state.Finished() {
+		return base
+	}
+
+	s := &resumeStorage{
+		CollyStorage: base,
+		pending:      map[uint64]bool{},
+	}
+	for _, p := range state.Pending {
+		s.pending[requestHash(p.Url)] = true
+	}
+
+	return s
+}
+
+type resumeStorage struct {
+	repository.CollyStorage
+	mu      sync.Mutex
+	pending map[uint64]bool
+}
+
+func (s *resumeStorage) IsVisited(requestId uint64) (bool, error) {
+	s.mu.Lock()
+	pending := s.pending[requestId]
+	delete(s.pending, requestId)
+	s.mu.Unlock()
+
+	if pending {
+		return false, nil
+	}
+	return s.CollyStorage.IsVisited(requestId)
+}
+
+// requestHash returns the id colly's storage knows a GET request by.
+func requestHash(url string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(url))
+	return h.Sum64()
+}
@@ -0,0 +1,175 @@
+package fetcher
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCrawlTracker(t *testing.T) {
+	repo := newTestRepository()
+	tracker := NewCrawlTracker(repo, "test", FetchOptions{State: &CrawlState{
+		Pending: []PendingRequest{{Url: "https://example.com/a", Depth: 3}},
+	}}, nil)
+
+	tracker.Queue("https://example.com/c", 4)
+	tracker.Queue("https://example.com/b", 4)
+	tracker.Queue("https://example.com/d", 2)
+	tracker.Complete("https://example.com/a")
+
+	newest := time.Date(2019, 11, 26, 15, 42, 0, 0, time.UTC)
+	tracker.ArticleSaved(newest)
+	tracker.ArticleSaved(newest.Add(-time.Hour))
+
+	if err := tracker.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := LoadCrawlState(repo, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []PendingRequest{
+		{Url: "https://example.com/d", Depth: 2},
+		{Url: "https://example.com/b", Depth: 4},
+		{Url: "https://example.com/c", Depth: 4},
+	}
+	if !reflect.DeepEqual(state.Pending, want) {
+		t.Errorf("LoadCrawlState(): Pending = %v; want %v", state.Pending, want)
+	}
+	if !state.LastArticleDate.Equal(newest) {
+		t.Errorf("LoadCrawlState(): LastArticleDate = %v; want %v", state.LastArticleDate, newest)
+	}
+
+	if state, err := LoadCrawlState(repo, "unknown"); state != nil || err != nil {
+		t.Errorf("LoadCrawlState(unknown) = %v, %v; want nil, nil", state, err)
+	}
+}
+
+func TestCrawlTrackerUpdate(t *testing.T) {
+	repo := newTestRepository()
+	newest := time.Date(2019, 11, 26, 15, 42, 0, 0, time.UTC)
+	interrupted := NewCrawlTracker(repo, "test", FetchOptions{}, nil)
+	interrupted.Queue("https://example.com/a", 3)
+	interrupted.ArticleSaved(newest.Add(-time.Hour))
+	if err := interrupted.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// An update leaves the frontier of the interrupted crawl to resume
+	update := NewCrawlTracker(repo, "test", FetchOptions{Update: true}, nil)
+	update.Queue("https://example.com/feed", 0)
+	update.ArticleSaved(newest)
+	if err := update.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := LoadCrawlState(repo, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PendingRequest{{Url: "https://example.com/a", Depth: 3}}
+	if !reflect.DeepEqual(state.Pending, want) || !state.LastArticleDate.Equal(newest) {
+		t.Errorf("LoadCrawlState() after update = %+v; want Pending %v, LastArticleDate %v", state, want, newest)
+	}
+}
+
+func TestUpdateAfterTime(t *testing.T) {
+	newest := time.Date(2019, 11, 26, 15, 42, 0, 0, time.UTC)
+	day := newest.Add(-24 * time.Hour)
+	finished := &CrawlState{LastArticleDate: newest}
+	interrupted := &CrawlState{
+		Pending:         []PendingRequest{{Url: "https://example.com/a", Depth: 3}},
+		LastArticleDate: newest,
+	}
+
+	tests := []struct {
+		state     *CrawlState
+		afterTime time.Time
+		want      time.Time
+	}{
+		{finished, day, newest},
+		{finished, time.Time{}, newest},
+		{finished, newest.Add(time.Hour), newest.Add(time.Hour)},
+		{interrupted, day, day},
+		{&CrawlState{}, day, day},
+		{nil, day, day},
+	}
+	for _, test := range tests {
+		if got := test.state.UpdateAfterTime(test.afterTime); !got.Equal(test.want) {
+			t.Errorf("%+v.UpdateAfterTime(%v) = %v; want %v", test.state, test.afterTime, got, test.want)
+		}
+	}
+}
+
+func TestResumeStorage(t *testing.T) {
+	repo := newTestRepository()
+	pendingUrl := "https://example.com/pending"
+	doneUrl := "https://example.com/done"
+	repo.Visited(requestHash(pendingUrl))
+	repo.Visited(requestHash(doneUrl))
+
+	s := ResumeStorage(repo, &CrawlState{
+		Pending: []PendingRequest{{Url: pendingUrl, Depth: 2}},
+	})
+
+	// Pending requests are let through once
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{pendingUrl, false},
+		{pendingUrl, true},
+		{doneUrl, true},
+	}
+	for _, test := range tests {
+		if got, _ := s.IsVisited(requestHash(test.url)); got != test.want {
+			t.Errorf("IsVisited(%s) = %v; want %v", test.url, got, test.want)
+		}
+	}
+}
+
+func TestCrawlStorageUpdate(t *testing.T) {
+	repo := newTestRepository()
+	url := "https://example.com/"
+	repo.Visited(requestHash(url))
+
+	// Updates neither consult nor add to the request history
+	s := CrawlStorage(repo, FetchOptions{Update: true})
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if visited, _ := s.IsVisited(requestHash(url)); visited {
+		t.Errorf("IsVisited(%s) in update = true; want false", url)
+	}
+	s.Visited(requestHash(url + "new"))
+	if visited, _ := repo.IsVisited(requestHash(url + "new")); visited {
+		t.Errorf("IsVisited(%snew) in repository after update = true; want false", url)
+	}
+}
+
+func TestIdRanges(t *testing.T) {
+	var ids IdRanges
+	for _, id := range []int{5, 3, 7, 4, 10, 6, 1} {
+		ids = ids.Add(id)
+	}
+	want := IdRanges{{First: 1, Last: 1}, {First: 3, Last: 7}, {First: 10, Last: 10}}
+	if !reflect.DeepEqual(ids, want) {
+		t.Errorf("Add() = %v; want %v", ids, want)
+	}
+	if ids = ids.Add(5); !reflect.DeepEqual(ids, want) {
+		t.Errorf("Add(5) again = %v; want %v", ids, want)
+	}
+
+	for id, want := range map[int]bool{0: false, 1: true, 2: false, 3: true, 5: true, 7: true, 8: false, 10: true, 11: false} {
+		if got := ids.Contains(id); got != want {
+			t.Errorf("%v.Contains(%d) = %v; want %v", ids, id, got, want)
+		}
+	}
+
+	union := ids.Union(IdRanges{{First: 2, Last: 2}, {First: 8, Last: 8}, {First: 12, Last: 20}})
+	if want := (IdRanges{{First: 1, Last: 8}, {First: 10, Last: 10}, {First: 12, Last: 20}}); !reflect.DeepEqual(union, want) {
+		t.Errorf("Union() = %v; want %v", union, want)
+	}
+}
@@ -0,0 +1,190 @@
+package fetcher
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/qwwqe/tcsuite/content"
+	"github.com/qwwqe/tcsuite/repository"
+)
+
+// FetchStats collects the statistics of a single call to Fetch, to be
+// recorded in the repository as a FetchRun. It is safe for concurrent use
+// by the callbacks of an asynchronous collector.
+type FetchStats struct {
+	mu  sync.Mutex
+	run repository.FetchRun
+}
+
+// NewFetchStats starts collecting statistics for the named fetcher.
+func NewFetchStats(name string) *FetchStats {
+	return &FetchStats{
+		run: repository.FetchRun{
+			Fetcher:  name,
+			Started:  time.Now(),
+			Failures: map[string]int{},
+		},
+	}
+}
+
+// PageVisited counts a page received from the site.
+func (s *FetchStats) PageVisited() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.run.PagesVisited++
+}
+
+// ArticleFound counts a page taken to be an article, just before its
+// content is extracted.
+func (s *FetchStats) ArticleFound() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.run.Articles++
+}
+
+// ExtractionFailed counts a failure to extract an article's content. The
+// errors of the processArticle functions name the field that failed.
+func (s *FetchStats) ExtractionFailed(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.run.Failures[err.Error()]++
+}
+
+// Duplicate counts an article skipped as already saved.
+func (s *FetchStats) Duplicate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.run.Duplicates++
+}
+
+// SaveArticle saves fc as options.SaveContent does unless the article
+// limit has been reached, counting the outcome. It reports whether fc was
+// saved; articles saved already are counted as duplicates and not reported
+// as errors.
+func (s *FetchStats) SaveArticle(options *FetcherOptions, counter *ArticleCounter, fc *content.FetchedContent) (bool, error) {
+	if !counter.Claim() {
+		return false, nil
+	}
+
+	if _, err := options.SaveContent(fc); err != nil {
+		counter.Release()
+		if _, ok := err.(*repository.DuplicateContentError); ok {
+			s.Duplicate()
+			return false, nil
+		}
+
+		s.mu.Lock()
+		s.run.SaveFailures++
+		s.mu.Unlock()
+		return false, err
+	}
+
+	s.mu.Lock()
+	s.run.Saved++
+	s.mu.Unlock()
+	return true, nil
+}
+
+// Run returns a snapshot of the statistics collected so far.
+func (s *FetchStats) Run() *repository.FetchRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	run := s.run
+	run.Failures = map[string]int{}
+	for field, n := range s.run.Failures {
+		run.Failures[field] = n
+	}
+	return &run
+}
+
+// Save marks the fetch as finished and records its statistics.
+func (s *FetchStats) Save(repo repository.Repository) error {
+	s.mu.Lock()
+	s.run.Finished = time.Now()
+	s.mu.Unlock()
+
+	return repo.SaveFetchRun(s.Run())
+}
+
+// A site is flagged as broken when the extraction success rate of its
+// latest fetch falls below this fraction of its usual rate.
+const breakageThreshold = 0.5
+
+// SiteReport summarises the recent fetches of one fetcher.
+type SiteReport struct {
+	Fetcher string
+	Latest  *repository.FetchRun
+	Runs    int
+
+	// Fraction of articles extracted without failure, in the latest
+	// fetch and on average over the earlier fetches
+	SuccessRate  float64
+	BaselineRate float64
+
+	Broken bool
+	Reason string
+}
+
+// Report summarises fetch runs by fetcher, flagging the sites whose
+// extraction success rate has suddenly dropped or that no longer yield
+// any articles, which usually means the site has been redesigned.
+// Runs are expected oldest first, as returned by GetFetchRuns.
+func Report(runs []*repository.FetchRun) []*SiteReport {
+	byFetcher := map[string][]*repository.FetchRun{}
+	for _, run := range runs {
+		byFetcher[run.Fetcher] = append(byFetcher[run.Fetcher], run)
+	}
+
+	reports := []*SiteReport{}
+	for name, fetcherRuns := range byFetcher {
+		latest := fetcherRuns[len(fetcherRuns)-1]
+		earlier := fetcherRuns[:len(fetcherRuns)-1]
+
+		report := &SiteReport{
+			Fetcher:     name,
+			Latest:      latest,
+			Runs:        len(fetcherRuns),
+			SuccessRate: successRate(latest),
+		}
+
+		// The baseline only counts fetches that found articles
+		baselineRuns := 0
+		for _, run := range earlier {
+			if run.Articles > 0 {
+				report.BaselineRate += successRate(run)
+				baselineRuns++
+			}
+		}
+		if baselineRuns > 0 {
+			report.BaselineRate /= float64(baselineRuns)
+		}
+
+		switch {
+		case baselineRuns == 0:
+			// Nothing to compare against
+		case latest.PagesVisited > 0 && latest.Articles == 0:
+			report.Broken = true
+			report.Reason = "no pages classified as articles"
+		case latest.Articles > 0 && report.SuccessRate < report.BaselineRate*breakageThreshold:
+			report.Broken = true
+			report.Reason = "extraction success rate dropped"
+		}
+
+		reports = append(reports, report)
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].Fetcher < reports[j].Fetcher
+	})
+
+	return reports
+}
+
+func successRate(run *repository.FetchRun) float64 {
+	if run.Articles == 0 {
+		return 0
+	}
+	return float64(run.Articles-run.FailureCount()) / float64(run.Articles)
+}
@@ -0,0 +1,135 @@
+package fetcher
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/qwwqe/tcsuite/content"
+	"github.com/qwwqe/tcsuite/repository"
+)
+
+func TestFetchStats(t *testing.T) {
+	stats := NewFetchStats("test")
+	for i := 0; i < 4; i++ {
+		stats.PageVisited()
+	}
+	stats.ArticleFound()
+	stats.ArticleFound()
+	stats.ExtractionFailed(errors.New("TITLE"))
+	stats.ExtractionFailed(errors.New("BODY"))
+	stats.ExtractionFailed(errors.New("BODY"))
+
+	run := stats.Run()
+	if run.PagesVisited != 4 || run.Articles != 2 || run.Failures["BODY"] != 2 || run.FailureCount() != 3 {
+		t.Errorf("FetchStats.Run() = %+v", run)
+	}
+
+	// Snapshots are not affected by later counts
+	stats.ExtractionFailed(errors.New("BODY"))
+	if run.Failures["BODY"] != 2 {
+		t.Errorf("FetchStats.Run() snapshot changed to %+v", run)
+	}
+}
+
+func TestFetchStatsSaveArticle(t *testing.T) {
+	repo := newTestRepository()
+	counter := NewArticleCounter(2)
+	stats := NewFetchStats("test")
+
+	// The hook is only called with the articles saved
+	hooked := []string{}
+	options := &FetcherOptions{
+		Repository: repo,
+		OnContentSaved: func(id int, c *content.FetchedContent) {
+			hooked = append(hooked, c.Uri)
+		},
+	}
+
+	a := &content.FetchedContent{Uri: "https://example.com/a", Language: "en"}
+	b := &content.FetchedContent{Uri: "https://example.com/b", Language: "en"}
+	c := &content.FetchedContent{Uri: "https://example.com/c", Language: "en"}
+
+	if saved, err := stats.SaveArticle(options, counter, a); !saved || err != nil {
+		t.Fatalf("SaveArticle(a) = %v, %v; want true, nil", saved, err)
+	}
+
+	// Duplicates do not count towards the article limit
+	if saved, err := stats.SaveArticle(options, counter, a); saved || err != nil {
+		t.Errorf("SaveArticle(a) again = %v, %v; want false, nil", saved, err)
+	}
+
+	// Neither do failures
+	repo.saveErr = &repository.SaveContentError{Uri: b.Uri, Err: errors.New("connection reset")}
+	if saved, err := stats.SaveArticle(options, counter, b); saved || err == nil {
+		t.Errorf("SaveArticle(b) = %v, %v; want false, error", saved, err)
+	}
+	repo.saveErr = nil
+
+	if saved, err := stats.SaveArticle(options, counter, b); !saved || err != nil {
+		t.Errorf("SaveArticle(b) again = %v, %v; want true, nil", saved, err)
+	}
+	if saved, _ := stats.SaveArticle(options, counter, c); saved {
+		t.Errorf("SaveArticle(c) = true; want false past the article limit")
+	}
+
+	run := stats.Run()
+	if run.Saved != 2 || run.Duplicates != 1 || run.SaveFailures != 1 || counter.Count() != 2 {
+		t.Errorf("FetchStats.Run() = %+v with %d articles counted", run, counter.Count())
+	}
+	if want := []string{a.Uri, b.Uri}; !reflect.DeepEqual(hooked, want) {
+		t.Errorf("OnContentSaved called with %v; want %v", hooked, want)
+	}
+}
+
+func TestReport(t *testing.T) {
+	start := time.Date(2019, 11, 1, 0, 0, 0, 0, time.UTC)
+	runs := []*repository.FetchRun{}
+	addRun := func(fetcher string, visited int, articles int, failures int) {
+		runs = append(runs, &repository.FetchRun{
+			Fetcher:      fetcher,
+			Started:      start.Add(time.Duration(len(runs)) * time.Hour),
+			PagesVisited: visited,
+			Articles:     articles,
+			Failures:     map[string]int{"BODY": failures},
+		})
+	}
+
+	// Steady: a few failures every time
+	addRun("steady", 100, 50, 5)
+	addRun("steady", 100, 50, 4)
+	addRun("steady", 100, 50, 6)
+
+	// Redesigned article pages: extraction starts failing
+	addRun("redesigned", 100, 40, 0)
+	addRun("redesigned", 100, 40, 2)
+	addRun("redesigned", 100, 40, 35)
+
+	// Redesigned article urls: no page is taken to be an article
+	addRun("relocated", 100, 40, 0)
+	addRun("relocated", 100, 0, 0)
+
+	// A single run has nothing to compare against
+	addRun("new", 100, 10, 10)
+
+	want := map[string]bool{
+		"new":        false,
+		"redesigned": true,
+		"relocated":  true,
+		"steady":     false,
+	}
+
+	reports := Report(runs)
+	if len(reports) != len(want) {
+		t.Fatalf("Report() returned %d reports; want %d", len(reports), len(want))
+	}
+	for _, report := range reports {
+		if report.Broken != want[report.Fetcher] {
+			t.Errorf("Report(): %s broken = %v (%s); want %v", report.Fetcher, report.Broken, report.Reason, want[report.Fetcher])
+		}
+	}
+	if reports[0].Fetcher != "new" || reports[3].Latest != runs[2] {
+		t.Errorf("Report() = %v; want reports sorted by fetcher with their latest run", reports)
+	}
+}
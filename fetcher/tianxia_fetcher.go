@@ -1,5 +1,22 @@
 package fetcher
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/qwwqe/tcsuite/content"
+	"github.com/qwwqe/tcsuite/entities/languages"
+	"github.com/qwwqe/tcsuite/logging"
+)
+
 // json article api format: https://api-app.cw.com.tw/cw-app/article/5002959
 // json format:
 // {
@@ -31,3 +48,548 @@ package fetcher
 // 		}
 // 	]
 // }
+
+type TianxiaFetcher struct {
+	FetcherOptions *FetcherOptions
+}
+
+type txResponse struct {
+	Success string      `json:"success"`
+	Code    string      `json:"code"`
+	Msg     string      `json:"msg"`
+	Items   []txArticle `json:"items"`
+}
+
+type txArticle struct {
+	Id              json.Number        `json:"id"`
+	Channel         string             `json:"channel"`
+	Title           string             `json:"title"`
+	Author          string             `json:"author"`
+	PublishTime     string             `json:"publish_time"`
+	ShareUrl        string             `json:"share_url"`
+	Preface         string             `json:"preface"`
+	Content         string             `json:"content"`
+	Keywords        []string           `json:"keywords"`
+	RelatedArticles []txRelatedArticle `json:"related_articles"`
+}
+
+type txRelatedArticle struct {
+	Id    json.Number `json:"id"`
+	Title string      `json:"title"`
+}
+
+var txUniversalTags = []string{
+	"天下雜誌",
+	"雜誌",
+}
+
+var txApiBase = "https://api-app.cw.com.tw/cw-app/article/"
+var txDefaultDeparturePoint = txApiBase + "5002959"
+var txCanonName = "天下雜誌"
+var txLanguage = languages.ZH_TW
+var txLocation = time.FixedZone("CST", 8*60*60)
+var txArticleRegex = regexp.MustCompile(`/article/\d+$`)
+
+// Number of consecutive failed requests after which a crawl is stopped, as
+// the api is then most likely down or refusing the crawler.
+var txMaxFailures = 20
+
+// Number of consecutive ids without an article after which an update stops
+// looking for newer articles.
+var txUpdateMisses = 10
+
+// Layouts observed in the 'publish_time' field of the article api.
+var txTimeLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006/01/02 15:04:05",
+	"2006/01/02 15:04",
+	time.RFC3339,
+}
+
+func (f *TianxiaFetcher) SetFetcherOptions(fetcherOptions *FetcherOptions) {
+	f.FetcherOptions = fetcherOptions
+}
+
+func (f *TianxiaFetcher) GetFetcherOptions() *FetcherOptions {
+	return f.FetcherOptions
+}
+
+func (f *TianxiaFetcher) CanonName() string {
+	return txCanonName
+}
+
+// Fetch walks the CommonWealth article api, beginning at the departure point.
+// Each article leads on to its related articles and to the article
+// with the preceding id, so the crawl works its way back through the archive.
+// MaxDepth bounds the number of such steps away from the departure point.
+// The queue is recorded as the crawl state, so an interrupted crawl can be
+// resumed by passing its saved state as FetchOptions.State. A crawl is
+// stopped with an error once txMaxFailures requests in a row have failed.
+//
+// Updates instead walk upward from the article after the newest visited
+// by earlier crawls, or from the departure point if there is none, as
+// newer articles have greater ids, until txUpdateMisses ids in a row have
+// no article.
+func (f *TianxiaFetcher) Fetch(fetchOptions FetchOptions) error {
+	if fetchOptions.Uri != "" {
+		return f.fetchUri(fetchOptions.Uri)
+	}
+
+	repo := f.GetFetcherOptions().Repository
+	logger := f.GetFetcherOptions().SiteLogger(txCanonName)
+	counter := NewArticleCounter(fetchOptions.ArticleLimit)
+	tracker := NewCrawlTracker(repo, f.CanonName(), fetchOptions, logger)
+	stats := NewFetchStats(f.CanonName())
+
+	type queuedArticle struct {
+		id    int
+		depth int
+	}
+
+	queue := []queuedArticle{}
+	seen := map[int]bool{}
+	var visited IdRanges // before the crawl was resumed
+
+	// A resumed crawl starts out with the pending articles of the saved
+	// state, and does not return to the articles visited before it
+	departures := []PendingRequest{{Url: txDefaultDeparturePoint, Depth: 1}}
+	if fetchOptions.State != nil && !fetchOptions.State.Finished() {
+		departures = fetchOptions.State.Pending
+		visited = fetchOptions.State.Visited
+	} else if fetchOptions.Sitemap != "" {
+		// Sitemaps list website urls; the articles are requested from the api,
+		// found at the departure point if one is given
+		sitemapApiBase := txApiBase
+		if fetchOptions.DeparturePoint != "" {
+			base, _, err := txSplitArticleUrl(fetchOptions.DeparturePoint)
+			if err != nil {
+				logger.Error("invalid departure point", logging.UrlKey, fetchOptions.DeparturePoint, logging.ErrorKey, err)
+				return err
+			}
+			sitemapApiBase = base
+		}
+
+		articleUrls, err := ReadSitemap(nil, fetchOptions.Sitemap, fetchOptions, txArticleRegex.MatchString, logger)
+		if err != nil {
+			logger.Error("error reading sitemap", logging.UrlKey, fetchOptions.Sitemap, logging.ErrorKey, err)
+			return err
+		}
+
+		departures = []PendingRequest{}
+		for _, articleUrl := range articleUrls {
+			_, id, err := txSplitArticleUrl(articleUrl)
+			if err != nil {
+				continue
+			}
+			departures = append(departures, PendingRequest{Url: sitemapApiBase + strconv.Itoa(id), Depth: 1})
+		}
+	} else if fetchOptions.DeparturePoint != "" {
+		departures[0].Url = fetchOptions.DeparturePoint
+	}
+
+	if fetchOptions.Update && fetchOptions.Sitemap == "" {
+		saved, err := LoadCrawlState(repo, f.CanonName())
+		if err != nil {
+			logger.Error("error loading crawl state", logging.ErrorKey, err)
+			return err
+		}
+		if saved != nil && len(saved.Visited) > 0 {
+			base, _, err := txSplitArticleUrl(departures[0].Url)
+			if err != nil {
+				logger.Error("invalid departure point", logging.UrlKey, departures[0].Url, logging.ErrorKey, err)
+				return err
+			}
+			newest := saved.Visited[len(saved.Visited)-1].Last
+			departures = []PendingRequest{{Url: base + strconv.Itoa(newest+1), Depth: 1}}
+		}
+	}
+
+	var apiBase string
+	for _, departure := range departures {
+		base, id, err := txSplitArticleUrl(departure.Url)
+		if err != nil {
+			logger.Error("invalid departure point", logging.UrlKey, departure.Url, logging.ErrorKey, err)
+			return err
+		}
+		apiBase = base
+		if !seen[id] && !visited.Contains(id) {
+			seen[id] = true
+			queue = append(queue, queuedArticle{id: id, depth: departure.Depth})
+			tracker.Queue(apiBase+strconv.Itoa(id), departure.Depth)
+		}
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	enqueue := func(id int, depth int) {
+		// Sitemap crawls visit only the articles listed in the sitemap
+		if fetchOptions.Sitemap != "" {
+			return
+		}
+		if id <= 0 || seen[id] || visited.Contains(id) {
+			return
+		}
+		if fetchOptions.MaxDepth > 0 && depth > fetchOptions.MaxDepth {
+			return
+		}
+		seen[id] = true
+		queue = append(queue, queuedArticle{id: id, depth: depth})
+		tracker.Queue(apiBase+strconv.Itoa(id), depth)
+	}
+
+	// Crawls walk back to earlier ids, and updates on to later ones
+	step := -1
+	if fetchOptions.Update {
+		step = 1
+	}
+	misses := 0
+
+	// visit returns the error of requesting the article, if any
+	visit := func(next queuedArticle, articleUrl string) error {
+		logger.Debug("visiting", logging.UrlKey, articleUrl)
+
+		article, err := txGetArticle(client, articleUrl)
+		if err != nil {
+			logger.Warn("request failed", logging.UrlKey, articleUrl, logging.ErrorKey, err)
+			misses++
+			if !fetchOptions.Update || misses < txUpdateMisses {
+				enqueue(next.id+step, next.depth+1)
+			}
+			return err
+		}
+		misses = 0
+		stats.PageVisited()
+
+		// Filter article by date
+		articleDate := txParseTime(article.PublishTime)
+		if !articleDate.IsZero() {
+			if !fetchOptions.BeforeTime.IsZero() && !articleDate.Before(fetchOptions.BeforeTime) {
+				logger.Debug("article too new", logging.UrlKey, articleUrl)
+				if !fetchOptions.Update {
+					enqueue(next.id-1, next.depth+1)
+				}
+				return nil
+			}
+
+			// Related articles and earlier ids of an old article are at least as old
+			if !fetchOptions.AfterTime.IsZero() && !articleDate.After(fetchOptions.AfterTime) {
+				logger.Debug("article too old", logging.UrlKey, articleUrl)
+				if fetchOptions.Update {
+					enqueue(next.id+1, next.depth+1)
+				}
+				return nil
+			}
+		}
+
+		// Updates keep to the articles after those already visited
+		if !fetchOptions.Update {
+			for _, related := range article.RelatedArticles {
+				relatedId, err := strconv.Atoi(related.Id.String())
+				if err != nil {
+					continue
+				}
+				enqueue(relatedId, next.depth+1)
+			}
+		}
+		enqueue(next.id+step, next.depth+1)
+
+		stats.ArticleFound()
+		fc, err := txProcessArticle(articleUrl, article)
+		if err != nil {
+			logger.Warn("extraction failed", logging.UrlKey, articleUrl, "field", err.Error())
+			stats.ExtractionFailed(err)
+			return nil
+		}
+		saved, err := stats.SaveArticle(f.GetFetcherOptions(), counter, fc)
+		if err != nil {
+			logger.Error("error saving article", logging.UrlKey, fc.Uri, logging.ErrorKey, err)
+		} else if saved {
+			logger.Info("article saved", logging.UrlKey, fc.Uri)
+			tracker.ArticleSaved(articleDate)
+		}
+		return nil
+	}
+
+	// The queue is left as it is when the crawl is stopped, to be resumed
+	var fetchErr error
+	failures := 0
+	for len(queue) > 0 {
+		if counter.LimitReached() {
+			break
+		}
+
+		next := queue[0]
+		queue = queue[1:]
+
+		articleUrl := apiBase + strconv.Itoa(next.id)
+		err := visit(next, articleUrl)
+		if err == nil {
+			failures = 0
+		} else {
+			failures++
+		}
+		// The newest id visited is where the next update starts, so updates
+		// do not record the ids they found no article at
+		if err == nil || !fetchOptions.Update {
+			tracker.ArticleVisited(next.id)
+		}
+		tracker.Complete(articleUrl)
+
+		if failures >= txMaxFailures {
+			fetchErr = fmt.Errorf("%d consecutive requests failed", failures)
+			logger.Error("stopping fetch", logging.ErrorKey, fetchErr)
+			break
+		}
+	}
+
+	logger.Info("fetch finished", "saved", counter.Count())
+
+	if err := stats.Save(repo); err != nil {
+		logger.Error("error saving fetch statistics", logging.ErrorKey, err)
+	}
+
+	if err := tracker.Save(); err != nil {
+		logger.Error("error saving crawl state", logging.ErrorKey, err)
+		return err
+	}
+
+	return fetchErr
+}
+
+// fetchUri fetches, processes and saves the single article found at uri.
+// Both article api urls and website article urls (the 'share_url'
+// of an article) are accepted.
+func (f *TianxiaFetcher) fetchUri(uri string) error {
+	logger := f.GetFetcherOptions().SiteLogger(txCanonName)
+
+	articleUrl, ok := txArticleApiUrl(uri)
+	if !ok {
+		logger.Warn("not an article", logging.UrlKey, uri)
+		return &NotArticleError{Uri: uri}
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	logger.Debug("visiting", logging.UrlKey, articleUrl)
+
+	article, err := txGetArticle(client, articleUrl)
+	if err != nil {
+		logger.Warn("request failed", logging.UrlKey, articleUrl, logging.ErrorKey, err)
+		if _, ok := err.(*NotArticleError); ok {
+			return &NotArticleError{Uri: uri}
+		}
+		return err
+	}
+
+	fc, err := txProcessArticle(articleUrl, article)
+	if err != nil {
+		logger.Warn("extraction failed", logging.UrlKey, articleUrl, "field", err.Error())
+		return err
+	}
+	if _, err := f.GetFetcherOptions().SaveContent(fc); err != nil {
+		logger.Error("error saving article", logging.UrlKey, fc.Uri, logging.ErrorKey, err)
+		return err
+	}
+	logger.Info("article saved", logging.UrlKey, fc.Uri)
+
+	return nil
+}
+
+// txArticleApiUrl returns the api url of the article at uri, either an
+// article api url, returned as it is, or the website url of an article on
+// a cw.com.tw host, whose id is looked up at txApiBase.
+func txArticleApiUrl(uri string) (string, bool) {
+	if !txArticleRegex.MatchString(uri) {
+		return "", false
+	}
+	u, err := url.Parse(uri)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", false
+	}
+
+	apiBase, id, err := txSplitArticleUrl(uri)
+	if err != nil {
+		return "", false
+	}
+	if strings.HasSuffix(apiBase, "/cw-app/article/") {
+		return uri, true
+	}
+
+	host := u.Hostname()
+	if host != "cw.com.tw" && !strings.HasSuffix(host, ".cw.com.tw") {
+		return "", false
+	}
+	return txApiBase + strconv.Itoa(id), true
+}
+
+// txSplitArticleUrl splits an article api url into the api base (everything
+// up to and including the final '/') and the numeric article id.
+func txSplitArticleUrl(articleUrl string) (string, int, error) {
+	i := strings.LastIndex(articleUrl, "/")
+	if i < 0 {
+		return "", 0, errors.New("not an article api url")
+	}
+
+	id, err := strconv.Atoi(articleUrl[i+1:])
+	if err != nil {
+		return "", 0, errors.New("not an article api url")
+	}
+
+	return articleUrl[:i+1], id, nil
+}
+
+// txGetArticle retrieves and decodes a single article from the api.
+// An error is returned if the api reports no such article.
+func txGetArticle(client *http.Client, articleUrl string) (*txArticle, error) {
+	resp, err := client.Get(articleUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	var txResp txResponse
+	if err := json.NewDecoder(resp.Body).Decode(&txResp); err != nil {
+		return nil, err
+	}
+
+	if len(txResp.Items) == 0 {
+		return nil, &NotArticleError{Uri: articleUrl}
+	}
+
+	return &txResp.Items[0], nil
+}
+
+// Return Time representation of an article's 'publish_time'.
+// Timestamps without a zone are taken to be Taiwan time.
+func txParseTime(rawTime string) time.Time {
+	rawTime = strings.TrimSpace(rawTime)
+	for _, layout := range txTimeLayouts {
+		t, err := time.ParseInLocation(layout, rawTime, txLocation)
+		if err == nil {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// Return the article body as a string.
+// The 'content' field holds an HTML fragment; paragraphs and headings
+// are kept while figures, captions and embedded scripts are dropped.
+func txGetArticleBody(rawContent string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(rawContent))
+	if err != nil {
+		return ""
+	}
+
+	doc.Find(`script, style, figure, figcaption, img`).Remove()
+	paraSelectors := doc.Find(`p, h1, h2, h3, h4, h5, h6`)
+	paras := []string{}
+	paraSelectors.Each(func(_ int, s *goquery.Selection) {
+		trimmedText := strings.TrimSpace(s.Text())
+		if trimmedText != "" {
+			paras = append(paras, trimmedText)
+		}
+	})
+
+	// Some articles are plain text without any markup
+	if len(paras) == 0 {
+		for _, para := range strings.Split(doc.Text(), "\n") {
+			trimmedText := strings.TrimSpace(para)
+			if trimmedText != "" {
+				paras = append(paras, trimmedText)
+			}
+		}
+	}
+
+	return strings.Join(paras, "\n\n")
+}
+
+func txProcessArticle(articleUrl string, article *txArticle) (*content.FetchedContent, error) {
+	fc := &content.FetchedContent{}
+
+	// The share url is the article's canonical location on the website
+	fc.Uri = article.ShareUrl
+	if fc.Uri == "" {
+		fc.Uri = articleUrl
+	}
+
+	// TITLE
+	title := strings.TrimSpace(article.Title)
+	if title == "" {
+		return nil, errors.New("TITLE")
+	} else {
+		fc.Title = title
+	}
+
+	// PUBLICATION DATE
+	dateFormat := "2006-01-02 15:04:05"
+	date := txParseTime(article.PublishTime)
+
+	if date.IsZero() {
+		fc.Date = time.Now().Format(dateFormat)
+	} else {
+		fc.Date = date.Format(dateFormat)
+	}
+
+	// AUTHOR
+	author := strings.TrimSpace(article.Author)
+	if author == "" {
+		fc.Author = txCanonName
+	} else {
+		fc.Author = author
+	}
+
+	// ABSTRACT
+	abstract := strings.TrimSpace(article.Preface)
+	if abstract == "" {
+		fc.Abstract = fc.Title
+	} else {
+		fc.Abstract = abstract
+	}
+
+	// TAGS
+	tags := []string{}
+	for _, keyword := range article.Keywords {
+		trimTag := strings.TrimSpace(keyword)
+		if trimTag != "" {
+			tags = append(tags, trimTag)
+		}
+	}
+
+	// Add default media tags
+	for _, tag := range txUniversalTags {
+		tags = append(tags, tag)
+	}
+
+	// Filter unique tags
+	tagMap := map[string]bool{}
+	for _, tag := range tags {
+		if !tagMap[tag] {
+			tagMap[tag] = true
+			fc.Tags = append(fc.Tags, tag)
+		}
+	}
+
+	// CANON NAME
+	fc.CanonName = txCanonName
+
+	// BODY
+	bodyText := txGetArticleBody(article.Content)
+
+	if bodyText == "" {
+		return nil, errors.New("BODY")
+	} else {
+		fc.Body = bodyText
+	}
+
+	// LANGUAGE
+	fc.Language = txLanguage
+
+	return fc, nil
+}
@@ -0,0 +1,480 @@
+package fetcher
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTianxiaServer serves the recorded api payloads and sitemap in testdata/tianxia.
+// Unrecorded article ids are answered as the api answers unknown ids.
+func newTianxiaServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(tianxiaHandler(t))
+}
+
+func tianxiaHandler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/sitemap.xml" {
+			http.ServeFile(w, r, filepath.Join("testdata", "tianxia", "sitemap.xml"))
+			return
+		}
+
+		payload, err := os.ReadFile(filepath.Join("testdata", "tianxia", path.Base(r.URL.Path)+".json"))
+		if err != nil {
+			payload = []byte(`{"success":"ok","code":"0000","msg":"loading success","items":[]}`)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(payload)
+	})
+}
+
+func TestTianxiaFetch(t *testing.T) {
+	server := newTianxiaServer(t)
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		options FetchOptions
+		want    []string
+	}{
+		{
+			name:    "related and preceding",
+			options: FetchOptions{MaxDepth: 2},
+			want: []string{
+				"https://www.cw.com.tw/article/5002959",
+				"https://www.cw.com.tw/article/5002960",
+				"https://www.cw.com.tw/article/5002958",
+			},
+		},
+		{
+			name:    "departure only",
+			options: FetchOptions{MaxDepth: 1},
+			want: []string{
+				"https://www.cw.com.tw/article/5002959",
+			},
+		},
+		{
+			name:    "article limit",
+			options: FetchOptions{ArticleLimit: 2},
+			want: []string{
+				"https://www.cw.com.tw/article/5002959",
+				"https://www.cw.com.tw/article/5002960",
+			},
+		},
+		{
+			name: "after time",
+			options: FetchOptions{
+				AfterTime: time.Date(2019, 6, 10, 0, 0, 0, 0, txLocation),
+			},
+			want: []string{
+				"https://www.cw.com.tw/article/5002959",
+				"https://www.cw.com.tw/article/5002960",
+			},
+		},
+		{
+			name: "before time",
+			options: FetchOptions{
+				BeforeTime: time.Date(2019, 6, 20, 0, 0, 0, 0, txLocation),
+				MaxDepth:   3,
+			},
+			want: []string{
+				"https://www.cw.com.tw/article/5002959",
+				"https://www.cw.com.tw/article/5002958",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		repo := newTestRepository()
+		f := &TianxiaFetcher{}
+		f.SetFetcherOptions(&FetcherOptions{Repository: repo})
+
+		test.options.DeparturePoint = server.URL + "/cw-app/article/5002959"
+		if err := f.Fetch(test.options); err != nil {
+			t.Fatalf("%s: TianxiaFetcher.Fetch() = %v; want nil", test.name, err)
+		}
+
+		if got := repo.savedUris(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: TianxiaFetcher.Fetch() saved\n%v\nwant\n%v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestTianxiaFetchSitemap(t *testing.T) {
+	server := newTianxiaServer(t)
+	defer server.Close()
+
+	repo := newTestRepository()
+	f := &TianxiaFetcher{}
+	f.SetFetcherOptions(&FetcherOptions{Repository: repo})
+
+	// Only the listed articles are visited, not their related articles
+	err := f.Fetch(FetchOptions{
+		DeparturePoint: server.URL + "/cw-app/article/5002959",
+		Sitemap:        server.URL + "/sitemap.xml",
+		AfterTime:      time.Date(2019, 6, 10, 0, 0, 0, 0, txLocation),
+	})
+	if err != nil {
+		t.Fatalf("TianxiaFetcher.Fetch() = %v; want nil", err)
+	}
+
+	want := []string{"https://www.cw.com.tw/article/5002960"}
+	if got := repo.savedUris(); !reflect.DeepEqual(got, want) {
+		t.Errorf("TianxiaFetcher.Fetch() saved %v; want %v", got, want)
+	}
+}
+
+func TestTianxiaFetchResume(t *testing.T) {
+	var mu sync.Mutex
+	requests := map[string]int{}
+	handler := tianxiaHandler(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	repo := newTestRepository()
+	f := &TianxiaFetcher{}
+	f.SetFetcherOptions(&FetcherOptions{Repository: repo})
+
+	departurePoint := server.URL + "/cw-app/article/5002959"
+
+	// Interrupt the crawl after the first article
+	err := f.Fetch(FetchOptions{DeparturePoint: departurePoint, MaxDepth: 2, ArticleLimit: 1})
+	if err != nil {
+		t.Fatalf("TianxiaFetcher.Fetch() = %v; want nil", err)
+	}
+
+	state, err := LoadCrawlState(repo, txCanonName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantPending := []PendingRequest{
+		{Url: server.URL + "/cw-app/article/5002958", Depth: 2},
+		{Url: server.URL + "/cw-app/article/5002960", Depth: 2},
+	}
+	if state == nil || !reflect.DeepEqual(state.Pending, wantPending) {
+		t.Fatalf("LoadCrawlState() = %+v; want pending %v", state, wantPending)
+	}
+	if want := (IdRanges{{First: 5002959, Last: 5002959}}); !reflect.DeepEqual(state.Visited, want) {
+		t.Errorf("LoadCrawlState(): Visited = %v; want %v", state.Visited, want)
+	}
+
+	wantDate := time.Date(2019, 6, 19, 10, 30, 0, 0, txLocation)
+	if !state.LastArticleDate.Equal(wantDate) {
+		t.Errorf("LoadCrawlState(): LastArticleDate = %v; want %v", state.LastArticleDate, wantDate)
+	}
+
+	// The departure point is ignored when resuming, and the articles visited
+	// are not visited again, though 5002960 relates to 5002959
+	err = f.Fetch(FetchOptions{DeparturePoint: departurePoint, MaxDepth: 3, State: state})
+	if err != nil {
+		t.Fatalf("TianxiaFetcher.Fetch() = %v; want nil", err)
+	}
+
+	want := []string{
+		"https://www.cw.com.tw/article/5002959",
+		"https://www.cw.com.tw/article/5002958",
+		"https://www.cw.com.tw/article/5002960",
+	}
+	if got := repo.savedUris(); !reflect.DeepEqual(got, want) {
+		t.Errorf("TianxiaFetcher.Fetch() saved\n%v\nwant\n%v", got, want)
+	}
+	if n := requests["/cw-app/article/5002959"]; n != 1 {
+		t.Errorf("TianxiaFetcher.Fetch() requested 5002959 %d times; want once", n)
+	}
+
+	state, err = LoadCrawlState(repo, txCanonName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.Finished() {
+		t.Errorf("LoadCrawlState(): Pending = %v; want none", state.Pending)
+	}
+}
+
+func TestTianxiaFetchFailures(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	repo := newTestRepository()
+	f := &TianxiaFetcher{}
+	f.SetFetcherOptions(&FetcherOptions{Repository: repo})
+
+	// Each failed request leads on to the preceding id until the limit
+	if err := f.Fetch(FetchOptions{DeparturePoint: server.URL + "/cw-app/article/5002959"}); err == nil {
+		t.Errorf("TianxiaFetcher.Fetch() with the api down = nil; want error")
+	}
+	if requests != txMaxFailures {
+		t.Errorf("TianxiaFetcher.Fetch() with the api down made %d requests; want %d", requests, txMaxFailures)
+	}
+
+	// The stopped crawl can be resumed
+	state, err := LoadCrawlState(repo, txCanonName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PendingRequest{{Url: server.URL + "/cw-app/article/" + strconv.Itoa(5002959-txMaxFailures), Depth: txMaxFailures + 1}}
+	if state == nil || !reflect.DeepEqual(state.Pending, want) {
+		t.Errorf("LoadCrawlState() = %+v; want pending %v", state, want)
+	}
+}
+
+func TestTianxiaFetchUpdate(t *testing.T) {
+	var mu sync.Mutex
+	requests := []string{}
+	handler := tianxiaHandler(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, path.Base(r.URL.Path))
+		mu.Unlock()
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	repo := newTestRepository()
+	f := &TianxiaFetcher{}
+	f.SetFetcherOptions(&FetcherOptions{Repository: repo})
+
+	// An interrupted crawl, whose frontier the updates leave to resume
+	interrupted := []PendingRequest{{Url: server.URL + "/cw-app/article/5002950", Depth: 3}}
+	tracker := NewCrawlTracker(repo, txCanonName, FetchOptions{State: &CrawlState{Pending: interrupted}}, nil)
+	if err := tracker.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without articles visited before, the update walks up from the
+	// departure point, past the articles too old
+	options := FetchOptions{
+		DeparturePoint: server.URL + "/cw-app/article/5002958",
+		AfterTime:      time.Date(2019, 6, 10, 0, 0, 0, 0, txLocation),
+		Update:         true,
+	}
+	if err := f.Fetch(options); err != nil {
+		t.Fatalf("TianxiaFetcher.Fetch() update = %v; want nil", err)
+	}
+	want := []string{
+		"https://www.cw.com.tw/article/5002959",
+		"https://www.cw.com.tw/article/5002960",
+	}
+	if got := repo.savedUris(); !reflect.DeepEqual(got, want) {
+		t.Errorf("TianxiaFetcher.Fetch() update saved %v; want %v", got, want)
+	}
+	if len(requests) != 3+txUpdateMisses || requests[3] != "5002961" {
+		t.Errorf("TianxiaFetcher.Fetch() update requested %v; want 5002958 to 5002960, then %d misses", requests, txUpdateMisses)
+	}
+
+	state, err := LoadCrawlState(repo, txCanonName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantVisited := IdRanges{{First: 5002958, Last: 5002960}}
+	if !reflect.DeepEqual(state.Pending, interrupted) || !reflect.DeepEqual(state.Visited, wantVisited) {
+		t.Errorf("LoadCrawlState() after update = %+v; want pending %v, visited %v", state, interrupted, wantVisited)
+	}
+
+	// The next update starts after the newest article visited
+	requests = nil
+	if err := f.Fetch(options); err != nil {
+		t.Fatalf("TianxiaFetcher.Fetch() second update = %v; want nil", err)
+	}
+	if len(requests) != txUpdateMisses || requests[0] != "5002961" {
+		t.Errorf("TianxiaFetcher.Fetch() second update requested %v; want %d misses from 5002961", requests, txUpdateMisses)
+	}
+}
+
+func TestTianxiaFetchUri(t *testing.T) {
+	server := newTianxiaServer(t)
+	defer server.Close()
+
+	repo := newTestRepository()
+	f := &TianxiaFetcher{}
+	f.SetFetcherOptions(&FetcherOptions{Repository: repo})
+
+	// Options other than Uri are ignored
+	err := f.Fetch(FetchOptions{
+		Uri:          server.URL + "/cw-app/article/5002960",
+		MaxDepth:     5,
+		ArticleLimit: 5,
+	})
+	if err != nil {
+		t.Fatalf("TianxiaFetcher.Fetch() = %v; want nil", err)
+	}
+
+	want := []string{"https://www.cw.com.tw/article/5002960"}
+	if got := repo.savedUris(); !reflect.DeepEqual(got, want) {
+		t.Errorf("TianxiaFetcher.Fetch() saved %v; want %v", got, want)
+	}
+
+	uri := server.URL + "/cw-app/article/5002957"
+	err = f.Fetch(FetchOptions{Uri: uri})
+	if notArticle, ok := err.(*NotArticleError); !ok || notArticle.Uri != uri {
+		t.Errorf("TianxiaFetcher.Fetch() = %v; want &NotArticleError{%q}", err, uri)
+	}
+
+	err = f.Fetch(FetchOptions{Uri: server.URL + "/about"})
+	if _, ok := err.(*NotArticleError); !ok {
+		t.Errorf("TianxiaFetcher.Fetch() = %v; want *NotArticleError", err)
+	}
+
+	// Article urls of other sites are not looked up at the api
+	for _, uri := range []string{
+		"https://news.ltn.com.tw/news/world/breakingnews/5002960",
+		"https://example.com/article/5002960",
+		"https://notcw.com.tw/article/5002960",
+	} {
+		err = f.Fetch(FetchOptions{Uri: uri})
+		if notArticle, ok := err.(*NotArticleError); !ok || notArticle.Uri != uri {
+			t.Errorf("TianxiaFetcher.Fetch(%q) = %v; want &NotArticleError{%q}", uri, err, uri)
+		}
+	}
+	if got := repo.savedUris(); !reflect.DeepEqual(got, want) {
+		t.Errorf("TianxiaFetcher.Fetch() of other sites saved %v; want %v", got, want)
+	}
+}
+
+func TestTianxiaArticleApiUrl(t *testing.T) {
+	tests := []struct {
+		uri  string
+		want string
+	}{
+		{"https://www.cw.com.tw/article/5002959", txApiBase + "5002959"},
+		{"https://cw.com.tw/article/5002959", txApiBase + "5002959"},
+		{"http://localhost:8080/cw-app/article/5002959", "http://localhost:8080/cw-app/article/5002959"},
+		{"https://news.ltn.com.tw/news/123", ""},
+		{"https://www.cw.com.tw/about/5002959", ""},
+		{"ftp://www.cw.com.tw/article/5002959", ""},
+	}
+	for _, test := range tests {
+		got, ok := txArticleApiUrl(test.uri)
+		if got != test.want || ok != (test.want != "") {
+			t.Errorf("txArticleApiUrl(%q) = %q, %v; want %q", test.uri, got, ok, test.want)
+		}
+	}
+}
+
+func TestTianxiaProcessArticle(t *testing.T) {
+	server := newTianxiaServer(t)
+	defer server.Close()
+
+	articleUrl := server.URL + "/cw-app/article/5002959"
+	article, err := txGetArticle(server.Client(), articleUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fc, err := txProcessArticle(articleUrl, article)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fc.Title != "日本本州西部近海發生地震 專家：餘震恐持續一週" {
+		t.Errorf("txProcessArticle(): Title = %q", fc.Title)
+	}
+	if fc.Author != "林佳琪" {
+		t.Errorf("txProcessArticle(): Author = %q; want %q", fc.Author, "林佳琪")
+	}
+	if fc.Date != "2019-06-19 10:30:00" {
+		t.Errorf("txProcessArticle(): Date = %q; want %q", fc.Date, "2019-06-19 10:30:00")
+	}
+	if fc.Abstract != "本次地震發生位置約位於日本本州西部近海，氣象廳提醒民眾注意餘震。" {
+		t.Errorf("txProcessArticle(): Abstract = %q", fc.Abstract)
+	}
+
+	wantBody := "本次地震發生位置約位於日本本州西部近海。\n\n" +
+		"氣象廳表示，未來一週內仍可能發生規模五以上的餘震。\n\n" +
+		"交通受影響\n\n" +
+		"新幹線部分路段一度停駛。"
+	if fc.Body != wantBody {
+		t.Errorf("txProcessArticle(): Body =\n%s\nwant\n%s", fc.Body, wantBody)
+	}
+
+	wantTags := []string{"地震", "日本", "氣象廳", "天下雜誌", "雜誌"}
+	if !reflect.DeepEqual(fc.Tags, wantTags) {
+		t.Errorf("txProcessArticle(): Tags = %v; want %v", fc.Tags, wantTags)
+	}
+
+	if fc.CanonName != txCanonName || fc.Language != txLanguage {
+		t.Errorf("txProcessArticle(): CanonName, Language = %q, %q; want %q, %q", fc.CanonName, fc.Language, txCanonName, txLanguage)
+	}
+
+	// Articles without a preface or author fall back to defaults
+	article, err = txGetArticle(server.Client(), server.URL+"/cw-app/article/5002960")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc, err = txProcessArticle(articleUrl, article)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fc.Author != txCanonName || fc.Abstract != fc.Title {
+		t.Errorf("txProcessArticle(): Author, Abstract = %q, %q; want %q, %q", fc.Author, fc.Abstract, txCanonName, fc.Title)
+	}
+
+	// Unknown ids produce no article
+	if _, err := txGetArticle(server.Client(), server.URL+"/cw-app/article/5002957"); err == nil {
+		t.Errorf("txGetArticle(5002957) = _, nil; want error")
+	}
+}
+
+func TestTianxiaFetchStats(t *testing.T) {
+	server := newTianxiaServer(t)
+	defer server.Close()
+
+	repo := newTestRepository()
+	f := &TianxiaFetcher{}
+	f.SetFetcherOptions(&FetcherOptions{Repository: repo})
+
+	// The second fetch finds the same articles already saved
+	for i := 0; i < 2; i++ {
+		err := f.Fetch(FetchOptions{
+			DeparturePoint: server.URL + "/cw-app/article/5002959",
+			MaxDepth:       2,
+		})
+		if err != nil {
+			t.Fatalf("TianxiaFetcher.Fetch() = %v; want nil", err)
+		}
+	}
+
+	runs, err := repo.GetFetchRuns(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 {
+		t.Fatalf("TianxiaFetcher.Fetch() recorded %d runs; want 2", len(runs))
+	}
+
+	tests := []struct {
+		saved      int
+		duplicates int
+	}{
+		{3, 0},
+		{0, 3},
+	}
+
+	for i, test := range tests {
+		run := runs[i]
+		if run.Fetcher != txCanonName || run.PagesVisited != 3 || run.Articles != 3 || run.FailureCount() != 0 ||
+			run.Saved != test.saved || run.Duplicates != test.duplicates {
+			t.Errorf("run %d: recorded %+v; want 3 pages, 3 articles, %d saved, %d duplicates", i, run, test.saved, test.duplicates)
+		}
+	}
+}
@@ -3,9 +3,8 @@ package fetcher
 import (
 	"bytes"
 	"errors"
-	"fmt"
 	"golang.org/x/text/language"
-	"net/url"
+	"regexp"
 	"strings"
 	"time"
 
@@ -15,10 +14,6 @@ import (
 	"github.com/qwwqe/tcsuite/content"
 )
 
-type WhoGovernsTwFetcher struct {
-	FetcherOptions *FetcherOptions
-}
-
 var wgtDomain = "whogovernstw.org"
 
 var wgtUniversalTags = []string{
@@ -28,137 +23,33 @@ var wgtUniversalTags = []string{
 	"評論",
 }
 
-var wgtDefaultDeparturePoint = "https://whogovernstw.org/"
+// Articles are published at /yyyy/mm/dd/slug/
+var wgtArticleUrl = regexp.MustCompile(`^https?://whogovernstw\.org/\d{4}/\d{2}/\d{2}/[^/]+/?$`)
+
 var wgtCanonName = "菜市場政治學"
-var wgtCacheDir = "./cache/whogovernstw_cache"
-var wgtSuccessful = 0
 var wgtLanguage = language.MustParse("zh-tw").String()
-
-func wgtFetchLogf(format string, a ...interface{}) (n int, err error) {
-	return fmt.Printf("[WHOGOVERNSTW FETCHER] "+format, a...)
-}
-
-func (f *WhoGovernsTwFetcher) SetFetcherOptions(fetcherOptions *FetcherOptions) {
-	f.FetcherOptions = fetcherOptions
+var wgtFeeds = []string{
+	"https://whogovernstw.org/feed/",
 }
 
-func (f *WhoGovernsTwFetcher) GetFetcherOptions() *FetcherOptions {
-	return f.FetcherOptions
-}
-
-func (f *WhoGovernsTwFetcher) Fetch(fetchOptions FetchOptions) error {
-	wgtSuccessful = 0
-
-	repo := f.GetFetcherOptions().Repository
-
-	c := colly.NewCollector(
-		colly.AllowedDomains(wgtDomain),
-		colly.CacheDir(wgtCacheDir),
-		colly.IgnoreRobotsTxt(),
-		colly.MaxDepth(fetchOptions.MaxDepth),
-		colly.Async(fetchOptions.Async),
-	)
-
-	if fetchOptions.Parallelism > 1 {
-		c.Limit(&colly.LimitRule{DomainGlob: "*", Parallelism: fetchOptions.Parallelism})
-	}
-
-	if err := c.SetStorage(repo); err != nil {
-		wgtFetchLogf("Error setting storage: %v\n", err)
-		return err
-	}
-
-	c.OnRequest(func(r *colly.Request) {
-		wgtFetchLogf("VISITING: %s\n", r.URL.String())
-	})
-
-	c.OnResponse(func(r *colly.Response) {
-		url := r.Request.URL.String()
-		wgtFetchLogf("RESPONSE: %s\n", url)
-
-		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(r.Body))
-		if err != nil {
-			wgtFetchLogf("ERROR PROCESSING RESPONSE BODY: %v\n", err)
-			return
-		}
-
-		// Filter response by url
-		if !wgtIsArticle(doc) {
-			return
-		}
-
-		// Filter response by date
-		articleDate := wgtGetArticleDate(doc)
-
-		if !fetchOptions.BeforeTime.IsZero() && !articleDate.Before(fetchOptions.BeforeTime) {
-			return
-		}
-
-		if !fetchOptions.AfterTime.IsZero() && !articleDate.After(fetchOptions.AfterTime) {
-			return
-		}
-
-		fc, err := wgtProcessArticle(r, doc)
-		if err == nil {
-			repo.SaveContent(fc)
-		}
-	})
-
-	c.OnHTML("html", func(e *colly.HTMLElement) {
-		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(e.Response.Body))
-		if err != nil {
-			wgtFetchLogf("ERROR PROCESSING RESPONSE BODY: %v\n", err)
-			return
-		}
-
-		// colly's Visit() method does not support unspecified ('//' - http or https) notation,
-		// so add the schema before calling Visit()
-		hrefSelection := doc.Find(`a[href]`)
-		hrefSelection.Each(func(_ int, s *goquery.Selection) {
-			link, _ := s.Attr("href")
-			origUrl, err := url.Parse(link)
-			if err != nil {
-				wgtFetchLogf("ERROR: %v (%s)\n", err, link)
-				return
-			}
-			origUrl.Scheme = "https"
-			e.Request.Visit(origUrl.String())
-		})
-	})
-
-	c.OnError(func(r *colly.Response, err error) {
-		wgtFetchLogf("ERROR: %v\n", err)
-	})
-
-	if fetchOptions.DeparturePoint != "" {
-		c.Visit(fetchOptions.DeparturePoint)
-	} else {
-		c.Visit(wgtDefaultDeparturePoint)
+// NewWhoGovernsTwFeedFetcher returns a fetcher of the articles in the WhoGovernsTw feed.
+func NewWhoGovernsTwFeedFetcher() *FeedFetcher {
+	return &FeedFetcher{
+		Name:           wgtCanonName,
+		Feeds:          wgtFeeds,
+		AllowedDomains: []string{wgtDomain},
+		ArticleUrl:     wgtArticleUrl,
+		Process:        wgtProcessArticle,
 	}
-
-	if fetchOptions.Async {
-		c.Wait()
-	}
-
-	wgtFetchLogf("TOTAL SUCCESSFUL: %d\n", wgtSuccessful)
-
-	return nil
-}
-
-func wgtIsArticle(d *goquery.Document) bool {
-	articleSelection := d.Find(`article.post`)
-	return articleSelection.Length() > 0
 }
 
 func wgtProcessArticle(r *colly.Response, doc *goquery.Document) (*content.FetchedContent, error) {
-	wgtFetchLogf("PROCESS: %s\n", r.Request.URL.String())
 	fc := &content.FetchedContent{}
 
 	var err error
 	if doc == nil {
 		doc, err = goquery.NewDocumentFromReader(bytes.NewReader(r.Body))
 		if err != nil {
-			wgtFetchLogf("ERROR PROCESSING RESPONSE BODY: %v\n", err)
 			return nil, errors.New("DOCPARSE")
 		}
 	}
@@ -168,7 +59,6 @@ func wgtProcessArticle(r *colly.Response, doc *goquery.Document) (*content.Fetch
 	// TITLE
 	title := wgtGetArticleTitle(doc)
 	if title == "" {
-		wgtFetchLogf("FAILED (TITLE): %s\n", fc.Uri)
 		return nil, errors.New("TITLE")
 	} else {
 		fc.Title = title
@@ -224,7 +114,6 @@ func wgtProcessArticle(r *colly.Response, doc *goquery.Document) (*content.Fetch
 	bodyText := wgtGetArticleBody(doc)
 
 	if bodyText == "" {
-		wgtFetchLogf("FAILED (BODY): %s\n", fc.Uri)
 		return nil, errors.New("BODY")
 	} else {
 		fc.Body = bodyText
@@ -233,8 +122,6 @@ func wgtProcessArticle(r *colly.Response, doc *goquery.Document) (*content.Fetch
 	// LANGUAGE
 	fc.Language = wgtLanguage
 
-	wgtFetchLogf("SUCCESS: %s\n", fc.Uri)
-	wgtSuccessful++
 	return fc, nil
 }
 
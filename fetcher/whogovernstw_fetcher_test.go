@@ -0,0 +1,11 @@
+package fetcher
+
+import (
+	"testing"
+
+	"github.com/qwwqe/tcsuite/fetcher/fixture"
+)
+
+func TestWhoGovernsTwProcessArticle(t *testing.T) {
+	fixture.Run(t, "testdata/whogovernstw", wgtProcessArticle)
+}
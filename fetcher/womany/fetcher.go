@@ -3,8 +3,6 @@ package womany
 import (
 	"bytes"
 	"errors"
-	"fmt"
-	"net/url"
 	"regexp"
 	"strings"
 	"time"
@@ -17,179 +15,33 @@ import (
 	"github.com/qwwqe/tcsuite/fetcher"
 )
 
-type Fetcher struct {
-	FetcherOptions *fetcher.FetcherOptions
-}
-
 var allowedDomains = []string{
 	"womany.net",
 	"www.womany.net",
 }
 
-var disallowedUrls = []*regexp.Regexp{
-	regexp.MustCompile("/images/"),
-	regexp.MustCompile("womany.net/shop[^/]*$"),
-	regexp.MustCompile("womany.net/shop/"),
-	regexp.MustCompile("/print$"),
-}
-
-var disallowedCacheUrls = []*regexp.Regexp{
-	regexp.MustCompile("womany.net/$"),
-	regexp.MustCompile("/interests/"),
-	regexp.MustCompile("top_writers"),
-}
-
 var universalTags = []string{
 	"女人迷",
 	"Womany",
 }
 
-//var defaultDeparturePoint = "https:/womany.net/"
-var defaultDeparturePoint = "https://womany.net/"
 var canonName = "女人迷"
-var cacheDir = fetcher.CacheDir + "womany_cache"
 var language = languages.ZH_TW
 var articleRegex = regexp.MustCompile("/read/article/")
-
-var successful = 0
-
-func fetchLogf(format string, a ...interface{}) (n int, err error) {
-	return fmt.Printf("[WOMANY FETCHER] "+format, a...)
-}
-
-func (f *Fetcher) SetFetcherOptions(fetcherOptions *fetcher.FetcherOptions) {
-	f.FetcherOptions = fetcherOptions
-}
-
-func (f *Fetcher) GetFetcherOptions() *fetcher.FetcherOptions {
-	return f.FetcherOptions
-}
-
-func (f *Fetcher) Fetch(fetchOptions fetcher.FetchOptions) error {
-	successful = 0
-
-	repo := f.GetFetcherOptions().Repository
-
-	c := colly.NewCollector(
-		colly.AllowedDomains(allowedDomains...),
-		colly.DisallowedURLFilters(disallowedUrls...),
-		colly.CacheDir(cacheDir),
-		colly.DisallowedCacheURLFilters(disallowedCacheUrls...),
-		colly.IgnoreRobotsTxt(),
-		colly.MaxDepth(fetchOptions.MaxDepth),
-		colly.Async(fetchOptions.Async),
-	)
-
-	if fetchOptions.Parallelism > 1 {
-		c.Limit(&colly.LimitRule{DomainGlob: "*", Parallelism: fetchOptions.Parallelism})
-	}
-
-	if err := c.SetStorage(repo); err != nil {
-		fetchLogf("Error setting storage: %v\n", err)
-		return err
-	}
-
-	c.OnRequest(func(r *colly.Request) {
-		fetchLogf("VISITING: %s\n", r.URL.String())
-	})
-
-	c.OnResponse(func(r *colly.Response) {
-		url := r.Request.URL.String()
-		fetchLogf("RESPONSE: %s\n", url)
-
-		// Filter response by url
-		isArticle := articleRegex.MatchString(url)
-		if !isArticle {
-			return
-		}
-
-		// Filter response by date
-		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(r.Body))
-		if err != nil {
-			fetchLogf("ERROR PROCESSING RESPONSE BODY: %v\n", err)
-			return
-		}
-		articleDate := getArticleDate(doc)
-
-		if !fetchOptions.BeforeTime.IsZero() && !articleDate.Before(fetchOptions.BeforeTime) {
-			return
-		}
-
-		if !fetchOptions.AfterTime.IsZero() && !articleDate.After(fetchOptions.AfterTime) {
-			return
-		}
-
-		fc, err := processArticle(r, doc)
-		if err == nil {
-			repo.SaveContent(fc)
-		}
-	})
-
-	c.OnHTML("html", func(e *colly.HTMLElement) {
-		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(e.Response.Body))
-		if err != nil {
-			fetchLogf("ERROR PROCESSING RESPONSE BODY: %v\n", err)
-			return
-		}
-
-		//isArticle, _ := regexp.MatchString(`/\d+$`, e.Request.URL.String())
-		isArticle := articleRegex.MatchString(e.Request.URL.String())
-		if isArticle {
-			articleDate := getArticleDate(doc)
-			if !articleDate.IsZero() {
-				if !fetchOptions.BeforeTime.IsZero() && !articleDate.Before(fetchOptions.BeforeTime) {
-					fetchLogf("FAILED (TOO NEW)\n")
-					return
-				}
-
-				if !fetchOptions.AfterTime.IsZero() && !articleDate.After(fetchOptions.AfterTime) {
-					fetchLogf("FAILED (TOO OLD)\n")
-					return
-				}
-			}
-		}
-
-		// colly's Visit() method does not support unspecified ('//' - http or https) notation,
-		// so add the schema before calling Visit()
-		hrefSelection := doc.Find(`a[href]`)
-		hrefSelection.Each(func(_ int, s *goquery.Selection) {
-			link, _ := s.Attr("href")
-			origUrl, err := url.Parse(link)
-			if err != nil {
-				fetchLogf("ERROR: %v (%s)\n", err, link)
-				return
-			}
-			origUrl.Scheme = "https"
-			if isArticle {
-				origUrl.RawQuery = ""
-				/*
-					q := origUrl.Query()
-					q.Del("ref")
-					origUrl.RawQuery = q.Encode()
-				*/
-			}
-			//c.Visit(e.Request.AbsoluteURL(origUrl.String()))
-			e.Request.Visit(origUrl.String())
-		})
-	})
-
-	c.OnError(func(r *colly.Response, err error) {
-		fetchLogf("ERROR: %v\n", err)
-	})
-
-	if fetchOptions.DeparturePoint != "" {
-		c.Visit(fetchOptions.DeparturePoint)
-	} else {
-		c.Visit(defaultDeparturePoint)
+var feeds = []string{
+	"https://womany.net/feeds/latest",
+}
+
+// NewFeedFetcher returns a fetcher of the articles in the Womany feeds.
+func NewFeedFetcher() *fetcher.FeedFetcher {
+	return &fetcher.FeedFetcher{
+		Name:           canonName,
+		Feeds:          feeds,
+		AllowedDomains: allowedDomains,
+		ArticleUrl:     articleRegex,
+		StripQueries:   true,
+		Process:        processArticle,
 	}
-
-	if fetchOptions.Async {
-		c.Wait()
-	}
-
-	fetchLogf("TOTAL SUCCESSFUL: %d\n", successful)
-
-	return nil
 }
 
 // Return Time representation of article's publication date.
@@ -301,14 +153,12 @@ func getArticleBody(doc *goquery.Document) string {
 }
 
 func processArticle(r *colly.Response, doc *goquery.Document) (*content.FetchedContent, error) {
-	fetchLogf("PROCESS: %s\n", r.Request.URL.String())
 	fc := &content.FetchedContent{}
 
 	var err error
 	if doc == nil {
 		doc, err = goquery.NewDocumentFromReader(bytes.NewReader(r.Body))
 		if err != nil {
-			fetchLogf("ERROR PROCESSING RESPONSE BODY: %v\n", err)
 			return nil, errors.New("DOCPARSE")
 		}
 	}
@@ -318,7 +168,6 @@ func processArticle(r *colly.Response, doc *goquery.Document) (*content.FetchedC
 	// TITLE
 	title := getArticleTitle(doc)
 	if title == "" {
-		fetchLogf("FAILED (TITLE): %s\n", fc.Uri)
 		return nil, errors.New("TITLE")
 	} else {
 		fc.Title = title
@@ -374,7 +223,6 @@ func processArticle(r *colly.Response, doc *goquery.Document) (*content.FetchedC
 	bodyText := getArticleBody(doc)
 
 	if bodyText == "" {
-		fetchLogf("FAILED (BODY): %s\n", fc.Uri)
 		return nil, errors.New("BODY")
 	} else {
 		fc.Body = bodyText
@@ -383,7 +231,5 @@ func processArticle(r *colly.Response, doc *goquery.Document) (*content.FetchedC
 	// LANGUAGE
 	fc.Language = language
 
-	fetchLogf("SUCCESS: %s\n", fc.Uri)
-	successful++
 	return fc, nil
 }
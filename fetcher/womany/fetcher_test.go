@@ -0,0 +1,11 @@
+package womany
+
+import (
+	"testing"
+
+	"github.com/qwwqe/tcsuite/fetcher/fixture"
+)
+
+func TestProcessArticle(t *testing.T) {
+	fixture.Run(t, "../testdata/womany", processArticle)
+}
@@ -0,0 +1,46 @@
+package lexicon
+
+import (
+	"testing"
+
+	"github.com/qwwqe/tcsuite/repository"
+	"github.com/qwwqe/tcsuite/repository/memory"
+)
+
+func TestZhTwLexiconRepository(t *testing.T) {
+	repo := memory.New(repository.RepositoryOptions{})
+
+	if err := repo.AddLexemes("test", "zh-TW", testLexemes, testFrequencies); err != nil {
+		t.Fatal(err)
+	}
+
+	lexicon := NewZhTwLexicon("test", "zh-TW")
+	if err := lexicon.LoadRepository(repo); err != nil {
+		t.Fatal(err)
+	}
+	if n := lexicon.NumEntries(); n != len(testLexemes) {
+		t.Errorf("NumEntries() = %d; want %d", n, len(testLexemes))
+	}
+	if err := lexicon.AddLexeme("教室", 7); err != nil {
+		t.Fatal(err)
+	}
+
+	// Lexemes added to a lexicon are saved to its repository
+	loaded := NewZhTwLexicon("test", "zh-TW")
+	if err := loaded.LoadRepository(repo); err != nil {
+		t.Fatal(err)
+	}
+	if n := loaded.NumEntries(); n != len(testLexemes)+1 {
+		t.Errorf("NumEntries() = %d; want %d", n, len(testLexemes)+1)
+	}
+	if freq, _, exists := loaded.GetLexemeFrequency("教室"); freq != 7 || !exists {
+		t.Errorf("GetLexemeFrequency(\"教室\") = %d, _, %v; want 7, _, true", freq, exists)
+	}
+
+	// Lexica are told apart by language
+	other := NewZhTwLexicon("test", "zh-CN")
+	other.LoadRepository(repo)
+	if n := other.NumEntries(); n != 0 {
+		t.Errorf("NumEntries() of zh-CN lexicon = %d; want 0", n)
+	}
+}
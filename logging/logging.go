@@ -0,0 +1,171 @@
+// Package logging builds the structured logger shared by the fetchers and
+// the repository.
+//
+// Every record carries the component that emitted it ("fetcher",
+// "repository", ...) and, where they apply, the site being fetched, the
+// url being processed and the id of the content concerned, so that the
+// logs of a crawl over several sites can be filtered and analysed. The
+// verbosity can be set for each component separately.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Keys of the fields common to the records of all components
+const (
+	ComponentKey = "component"
+	SiteKey      = "site"
+	UrlKey       = "url"
+	ContentIdKey = "content_id"
+	ErrorKey     = "error"
+)
+
+// Environment variables read by FromEnv
+const (
+	FormatEnv = "TCSUITE_LOG_FORMAT"
+	LevelEnv  = "TCSUITE_LOG_LEVEL"
+)
+
+type Options struct {
+	Format string                // "text" (the default) or "json"
+	Level  slog.Level            // minimum level of components without a level of their own
+	Levels map[string]slog.Level // minimum level by component
+	Output io.Writer             // defaults to os.Stdout
+}
+
+// New returns a logger writing records in the given format.
+func New(options Options) (*slog.Logger, error) {
+	output := options.Output
+	if output == nil {
+		output = os.Stdout
+	}
+
+	// Records are filtered by component before they reach the handler,
+	// which must therefore accept all of them
+	minLevel := options.Level
+	for _, level := range options.Levels {
+		if level < minLevel {
+			minLevel = level
+		}
+	}
+	handlerOptions := &slog.HandlerOptions{Level: minLevel}
+
+	var h slog.Handler
+	switch options.Format {
+	case "", "text":
+		h = slog.NewTextHandler(output, handlerOptions)
+	case "json":
+		h = slog.NewJSONHandler(output, handlerOptions)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", options.Format)
+	}
+
+	return slog.New(&componentHandler{
+		Handler: h,
+		levels:  options.Levels,
+		level:   options.Level,
+	}), nil
+}
+
+// FromEnv returns a logger configured by the TCSUITE_LOG_FORMAT and
+// TCSUITE_LOG_LEVEL environment variables, writing to standard output.
+// See ParseLevels for the format of the latter.
+func FromEnv() (*slog.Logger, error) {
+	level, levels, err := ParseLevels(os.Getenv(LevelEnv))
+	if err != nil {
+		return nil, err
+	}
+
+	return New(Options{
+		Format: os.Getenv(FormatEnv),
+		Level:  level,
+		Levels: levels,
+	})
+}
+
+// ParseLevels parses a comma-separated list of levels, each either a bare
+// level applying to all components or a component=level pair, as in
+// "warn,fetcher=debug". Levels are named as by slog ("debug", "info",
+// "warn", "error"). An empty spec sets all components to info.
+func ParseLevels(spec string) (slog.Level, map[string]slog.Level, error) {
+	level := slog.LevelInfo
+	levels := map[string]slog.Level{}
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		component, name, isComponentLevel := strings.Cut(item, "=")
+		if !isComponentLevel {
+			name = component
+		}
+
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(name)); err != nil {
+			return level, levels, fmt.Errorf("log level %q: %v", item, err)
+		}
+
+		if isComponentLevel {
+			levels[component] = l
+		} else {
+			level = l
+		}
+	}
+
+	return level, levels, nil
+}
+
+// Component returns a logger for the named component. A nil logger stands
+// for slog's default logger.
+func Component(logger *slog.Logger, component string) *slog.Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return logger.With(ComponentKey, component)
+}
+
+// componentHandler filters records by the level set for the component of
+// the logger, as given by the last component attribute added to it.
+type componentHandler struct {
+	slog.Handler
+	levels map[string]slog.Level
+	level  slog.Level
+}
+
+func (h *componentHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level && h.Handler.Enabled(ctx, level)
+}
+
+func (h *componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	level := h.level
+	for _, attr := range attrs {
+		if attr.Key != ComponentKey {
+			continue
+		}
+		if l, ok := h.levels[attr.Value.String()]; ok {
+			level = l
+		}
+	}
+
+	return &componentHandler{
+		Handler: h.Handler.WithAttrs(attrs),
+		levels:  h.levels,
+		level:   level,
+	}
+}
+
+func (h *componentHandler) WithGroup(name string) slog.Handler {
+	return &componentHandler{
+		Handler: h.Handler.WithGroup(name),
+		levels:  h.levels,
+		level:   h.level,
+	}
+}
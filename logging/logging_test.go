@@ -0,0 +1,67 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestParseLevels(t *testing.T) {
+	level, levels, err := ParseLevels("warn, fetcher=debug,repository=ERROR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level != slog.LevelWarn || levels["fetcher"] != slog.LevelDebug || levels["repository"] != slog.LevelError {
+		t.Errorf("ParseLevels() = %v, %v", level, levels)
+	}
+
+	if level, _, _ := ParseLevels(""); level != slog.LevelInfo {
+		t.Errorf("ParseLevels(\"\") = %v; want info", level)
+	}
+
+	if _, _, err := ParseLevels("fetcher=loud"); err == nil {
+		t.Errorf("ParseLevels(fetcher=loud) = _, _, nil; want error")
+	}
+}
+
+func TestComponentLevels(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger, err := New(Options{
+		Format: "json",
+		Level:  slog.LevelWarn,
+		Levels: map[string]slog.Level{"fetcher": slog.LevelDebug},
+		Output: buf,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetcher := Component(logger, "fetcher").With(SiteKey, "自由時報")
+	fetcher.Debug("visiting", UrlKey, "https://news.ltn.com.tw/news/politics/3001234")
+	repo := Component(logger, "repository")
+	repo.Info("adding language")
+	repo.Warn("slow query", ContentIdKey, 12)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("logged %d records; want 2:\n%s", len(lines), buf)
+	}
+
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record[ComponentKey] != "fetcher" || record[SiteKey] != "自由時報" || record["level"] != "DEBUG" || record[UrlKey] == nil {
+		t.Errorf("logged %v", record)
+	}
+
+	if !strings.Contains(lines[1], `"msg":"slow query"`) {
+		t.Errorf("logged %s; want the repository warning", lines[1])
+	}
+
+	if _, err := New(Options{Format: "xml"}); err == nil {
+		t.Errorf("New(xml) = _, nil; want error")
+	}
+}
@@ -2,35 +2,54 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
+	"net/http"
 	"runtime"
 	"runtime/pprof"
+	"sort"
 	//"golang.org/x/text/language"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
-	//"github.com/qwwqe/tcsuite/content"
+	"github.com/qwwqe/tcsuite/content"
 	"github.com/qwwqe/tcsuite/entities/languages"
 	f "github.com/qwwqe/tcsuite/fetcher"
+	"github.com/qwwqe/tcsuite/fetcher/generic"
 	"github.com/qwwqe/tcsuite/fetcher/womany"
 	l "github.com/qwwqe/tcsuite/lexicon"
+	"github.com/qwwqe/tcsuite/logging"
+	"github.com/qwwqe/tcsuite/pipeline"
 	r "github.com/qwwqe/tcsuite/repository"
+	"github.com/qwwqe/tcsuite/server"
+	"github.com/qwwqe/tcsuite/stats"
 	t "github.com/qwwqe/tcsuite/tokenizer"
 	"github.com/qwwqe/tcsuite/tokenizer/zhtw"
+	"golang.org/x/text/width"
 )
 
 type FetchOptionSet struct {
 	Fetcher    f.Fetcher
 	InitialSet f.FetchOptions
 	UpdateSet  f.FetchOptions
+
+	UpdateFetcher f.Fetcher // used in place of Fetcher for updates, if set
 }
 
 var fetchOptionSets = []FetchOptionSet{
 	FetchOptionSet{
-		Fetcher: &f.LibertyFetcher{},
+		Fetcher: builtinFetcher("liberty"),
 		InitialSet: f.FetchOptions{
 			MaxDepth:    5,
 			Async:       true,
@@ -38,26 +57,26 @@ var fetchOptionSets = []FetchOptionSet{
 		},
 		UpdateSet: f.FetchOptions{
 			AfterTime:   time.Now().Add(-1 * 24 * time.Hour),
-			MaxDepth:    3,
 			Async:       true,
 			Parallelism: 4,
 		},
+		UpdateFetcher: f.NewLibertyFeedFetcher(),
 	},
 
 	FetchOptionSet{
-		Fetcher: &f.WhoGovernsTwFetcher{},
+		Fetcher: builtinFetcher("whogovernstw"),
 		InitialSet: f.FetchOptions{
 			Async:       true,
 			Parallelism: 4,
 		},
 		UpdateSet: f.FetchOptions{
-			MaxDepth:    3,
 			Async:       true,
 			Parallelism: 4,
 		},
+		UpdateFetcher: f.NewWhoGovernsTwFeedFetcher(),
 	},
 	FetchOptionSet{
-		Fetcher: &womany.Fetcher{},
+		Fetcher: builtinFetcher("womany"),
 		InitialSet: f.FetchOptions{
 			MaxDepth:    100,
 			Async:       true,
@@ -65,14 +84,78 @@ var fetchOptionSets = []FetchOptionSet{
 		},
 		UpdateSet: f.FetchOptions{
 			AfterTime:   time.Now().Add(-1 * 36 * time.Hour),
-			MaxDepth:    3,
 			Async:       true,
 			Parallelism: 4,
 		},
+		UpdateFetcher: womany.NewFeedFetcher(),
+	},
+	FetchOptionSet{
+		Fetcher: &f.TianxiaFetcher{},
+		InitialSet: f.FetchOptions{
+			MaxDepth: 50,
+		},
+		UpdateSet: f.FetchOptions{
+			AfterTime: time.Now().Add(-1 * 24 * time.Hour),
+		},
 	},
 }
 
-var usage = "Usage: tcsuite <fetch | poplex | tokenize> < | lexicon file | content_id>\n"
+// builtinFetcher returns the fetcher of the built-in site definition with
+// the given id. The definitions are embedded in the binary, so failing to
+// load one is a programming error.
+func builtinFetcher(id string) f.Fetcher {
+	site, err := generic.BuiltinSite(id)
+	if err != nil {
+		panic(err)
+	}
+	return generic.New(site)
+}
+
+const usage = "Usage: tcsuite <fetch | poplex | tokenize> <initial | update | resume | lexicon file | content_id>\n" +
+	"       tcsuite fetch_site <site definition> [initial | update | resume] [-sitemap url]\n" +
+	"       tcsuite fetch-report [days]\n" +
+	"       tcsuite tokenize_all [filters] [-workers n] [-batch n]\n" +
+	"       tcsuite db <migrate | status | rollback [steps]>\n" +
+	"       tcsuite search [filters] [-limit n] <query>\n" +
+	"       tcsuite kwic [filters] [-window n] [-sort position | left | right] [-limit n] <word>\n" +
+	"       tcsuite stats freq [filters] [-min n] [-limit n] [-format tsv | json]\n" +
+	"       tcsuite collocations [filters] [-left n] [-right n | -ngram n] [-min n] [-sort ll | pmi | t | frequency] [-limit n] [-format tsv | json] <word>\n" +
+	"       tcsuite serve [-addr host:port] [-max-depth n]\n" +
+	"Filters: -source name, -tag tag, -language lang, -after YYYY-MM-DD, -before YYYY-MM-DD\n"
+
+// Number of results listed by the search command by default
+var searchLimit = 20
+
+// Number of words of context on either side in concordance lines by default
+var kwicWindow = 5
+
+// Window of words on either side in which collocations are counted by default
+var collocationWindow = 4
+
+// Number of articles tokenized at once, as they are fetched or by
+// tokenize_all; 0 is one per CPU
+var tokenizeWorkers = 0
+
+// Address on which the API is served by default
+var serveAddr = ":8080"
+
+// Number of days of fetches covered by the fetch report by default
+var fetchReportDays = 30
+
+// Fetch options for outlets fetched from a site definition
+var siteFetchOptionSet = FetchOptionSet{
+	InitialSet: f.FetchOptions{
+		MaxDepth:    5,
+		Async:       true,
+		Parallelism: 4,
+	},
+	UpdateSet: f.FetchOptions{
+		AfterTime:   time.Now().Add(-1 * 24 * time.Hour),
+		MaxDepth:    3,
+		Async:       true,
+		Parallelism: 4,
+	},
+}
 
 var cpuProfile = "cpuprofile"
 var memProfile = "memprofile"
@@ -93,42 +176,238 @@ func main() {
 	}
 	defer pprof.StopCPUProfile()
 
-	repo := r.GetRepository(r.RepositoryOptions{
-		RestoreRequestHistory: false,
+	// Log format and levels are set by TCSUITE_LOG_FORMAT and TCSUITE_LOG_LEVEL
+	logger, err := logging.FromEnv()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
+	// Migrations are run against a database whose schema may be out of date
+	if os.Args[1] == "db" {
+		if err := migrate(os.Args[2:], logger); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// The database is given by the configuration file or PG* environment variables
+	repo, err := r.NewRepository(r.RepositoryOptions{
+		Logger: logger,
 	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer repo.Close()
 
 	switch os.Args[1] {
 	case "fetch":
+		fetchMode := "update"
+		if len(os.Args) > 2 {
+			fetchMode = os.Args[2]
+		}
+
+		// Articles are tokenized as they are fetched
+		pool, err := tokenizePool(repo, logger)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
 		for _, fOpts := range fetchOptionSets {
 			fOpts.Fetcher.SetFetcherOptions(&f.FetcherOptions{
-				Repository: repo,
+				Repository:     repo,
+				Logger:         logger,
+				OnContentSaved: onContentSaved(pool),
 			})
+			if fOpts.UpdateFetcher != nil {
+				fOpts.UpdateFetcher.SetFetcherOptions(&f.FetcherOptions{
+					Repository:     repo,
+					Logger:         logger,
+					OnContentSaved: onContentSaved(pool),
+				})
+			}
 		}
 
-		mode := "update"
+		// Resuming a crawl relies on the request history of the interrupted
+		// crawl, which a new crawl starts afresh
+		if fetchMode == "initial" {
+			if err := repo.ClearRequestHistory(); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
 
-		for i, fOpts := range fetchOptionSets {
+		for _, fOpts := range fetchOptionSets {
 			var fetchOpts f.FetchOptions
+			fetcher := fOpts.Fetcher
 
-			// TESTING
-			mode = "update"
-			if i == 2 {
-				mode = "initial"
-			} else {
-				continue
-			}
-			// END TESTING
-
-			switch mode {
+			switch fetchMode {
 			case "update":
+				// Articles older than the newest of the last crawl are
+				// skipped
+				state, err := f.LoadCrawlState(repo, fOpts.Fetcher.CanonName())
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
 				fetchOpts = fOpts.UpdateSet
+				fetchOpts.AfterTime = state.UpdateAfterTime(fetchOpts.AfterTime)
+				fetchOpts.Update = true
+				if fOpts.UpdateFetcher != nil {
+					fetcher = fOpts.UpdateFetcher
+				}
 			case "initial":
 				fetchOpts = fOpts.InitialSet
+			case "resume":
+				// Interrupted crawls are resumed with their initial options;
+				// fetchers whose last crawl finished are skipped
+				state, err := f.LoadCrawlState(repo, fOpts.Fetcher.CanonName())
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				if state == nil || state.Finished() {
+					continue
+				}
+				fetchOpts = fOpts.InitialSet
+				fetchOpts.State = state
 			default:
-				continue
+				fmt.Printf(usage)
+				os.Exit(1)
+			}
+			// One fetcher failing does not stop the others
+			if err := fetcher.Fetch(fetchOpts); err != nil {
+				logger.Error("error fetching", logging.SiteKey, fetcher.CanonName(), logging.ErrorKey, err)
+			}
+		}
+		closeTokenizePool(pool)
+	case "fetch_site":
+		if len(os.Args) < 3 {
+			fmt.Printf(usage)
+			os.Exit(1)
+		}
+
+		fetchMode := "update"
+		args := os.Args[3:]
+		if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+			fetchMode = args[0]
+			args = args[1:]
+		}
+
+		flags := flag.NewFlagSet("fetch_site", flag.ContinueOnError)
+		sitemap := flags.String("sitemap", "", "url of a sitemap listing the articles to fetch, in place of following links")
+		if err := flags.Parse(args); err != nil {
+			fmt.Printf(usage)
+			os.Exit(1)
+		}
+
+		site, err := generic.LoadSite(os.Args[2])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		fetcher := generic.New(site)
+
+		var fetchOpts f.FetchOptions
+		switch fetchMode {
+		case "update":
+			state, err := f.LoadCrawlState(repo, fetcher.CanonName())
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			fetchOpts = siteFetchOptionSet.UpdateSet
+			fetchOpts.AfterTime = state.UpdateAfterTime(fetchOpts.AfterTime)
+			fetchOpts.Update = true
+		case "initial":
+			if err := repo.ClearRequestHistory(); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			fetchOpts = siteFetchOptionSet.InitialSet
+		case "resume":
+			// As with fetch, there is nothing to resume if the last crawl
+			// finished
+			state, err := f.LoadCrawlState(repo, fetcher.CanonName())
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			if state == nil || state.Finished() {
+				fmt.Printf("No interrupted crawl of %s to resume.\n", fetcher.CanonName())
+				return
 			}
-			fOpts.Fetcher.Fetch(fetchOpts)
+			fetchOpts = siteFetchOptionSet.InitialSet
+			fetchOpts.State = state
+		default:
+			fmt.Printf(usage)
+			os.Exit(1)
+		}
+		// A resumed crawl carries on from its saved frontier
+		if fetchOpts.State == nil {
+			fetchOpts.Sitemap = *sitemap
+		}
+
+		// Articles are tokenized as they are fetched
+		pool, err := tokenizePool(repo, logger)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fetcher.SetFetcherOptions(&f.FetcherOptions{
+			Repository:     repo,
+			Logger:         logger,
+			OnContentSaved: onContentSaved(pool),
+		})
+
+		err = fetcher.Fetch(fetchOpts)
+		closeTokenizePool(pool)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	case "fetch-report":
+		days := fetchReportDays
+		if len(os.Args) > 2 {
+			days, err = strconv.Atoi(os.Args[2])
+			if err != nil || days <= 0 {
+				fmt.Printf(usage)
+				os.Exit(1)
+			}
+		}
+
+		runs, err := repo.GetFetchRuns(time.Now().AddDate(0, 0, -days))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "FETCHER\tLAST RUN\tPAGES\tARTICLES\tFAILURES\tSAVED\tDUPLICATES\tSAVE ERRORS\tSUCCESS\tUSUAL\tSTATUS")
+		for _, report := range f.Report(runs) {
+			run := report.Latest
+
+			failures := []string{}
+			for field, n := range run.Failures {
+				failures = append(failures, fmt.Sprintf("%s:%d", field, n))
+			}
+			sort.Strings(failures)
+
+			status := "ok"
+			if report.Broken {
+				status = "BROKEN (" + report.Reason + ")"
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%d\t%d\t%d\t%.0f%%\t%.0f%%\t%s\n",
+				report.Fetcher, run.Started.Format("2006-01-02 15:04"), run.PagesVisited, run.Articles,
+				strings.Join(failures, " "), run.Saved, run.Duplicates, run.SaveFailures, report.SuccessRate*100, report.BaselineRate*100, status)
 		}
+		w.Flush()
 	case "poplex":
 		if len(os.Args) < 3 {
 			fmt.Printf(usage)
@@ -184,7 +463,7 @@ func main() {
 		fmt.Printf("Lexicon \"%s\" has %d entries.\n", lexiconName, lexicon.NumEntries())
 	case "tokenize":
 		if len(os.Args) < 3 {
-			fmt.Println(usage)
+			fmt.Print(usage)
 			os.Exit(1)
 		}
 
@@ -231,6 +510,17 @@ func main() {
 		}
 
 	case "tokenize_all":
+		if err := tokenizeAll(repo, logger, os.Args[2:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+	case "tokenize_by_tag":
+		if len(os.Args) < 3 {
+			fmt.Print(usage)
+			os.Exit(1)
+		}
+
 		lexiconName := "Traditional Chinese Comprehensive"
 		lexiconLang := languages.ZH_TW //language.MustParse("zh-tw").String()
 		lexicon := l.NewZhTwLexicon(lexiconName, lexiconLang)
@@ -241,72 +531,54 @@ func main() {
 			os.Exit(1)
 		}
 
-		fetchedContents, err := repo.GetUntokenizedContent()
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
-
-		fmt.Printf("Retrieved %d untokenized articles.\n", len(fetchedContents))
+		tag := os.Args[2]
 
 		tokenizer := zhtw.NewTokenizer(&t.Options{
 			MaxDepth: 3,
 		})
 
-		for i, fetchedContent := range fetchedContents {
+		filter := r.ContentFilter{Tag: tag, Tokenized: r.NotTokenized}
+		err = repo.IterateContent(context.Background(), filter, func(fetchedContent *content.FetchedContent) error {
 			tokens, err := tokenizer.Tokenize(fetchedContent.Body, lexicon)
 			if err != nil {
-				fmt.Println(err)
-				os.Exit(1)
+				return err
 			}
 
-			err = repo.RegisterTokens(fetchedContent.Id, tokens)
-			if err != nil {
-				fmt.Println(err)
-				os.Exit(1)
-			}
-			fmt.Printf("%d/%d\n", i+1, len(fetchedContents))
+			return repo.RegisterTokens(fetchedContent.Id, tokens)
+		})
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
 
-	case "tokenize_by_tag":
-		if len(os.Args) < 3 {
-			fmt.Println(usage)
+	case "search":
+		if err := search(repo, os.Args[2:]); err != nil {
+			fmt.Println(err)
 			os.Exit(1)
 		}
 
-		lexiconName := "Traditional Chinese Comprehensive"
-		lexiconLang := languages.ZH_TW //language.MustParse("zh-tw").String()
-		lexicon := l.NewZhTwLexicon(lexiconName, lexiconLang)
-
-		err := lexicon.LoadRepository(repo)
-		if err != nil {
+	case "kwic":
+		if err := kwic(repo, os.Args[2:]); err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
 
-		tag := os.Args[2]
-		fetchedContents, err := repo.GetFetchedContentByTag(tag)
-		if err != nil {
+	case "stats":
+		if err := wordStats(repo, os.Args[2:]); err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
 
-		tokenizer := zhtw.NewTokenizer(&t.Options{
-			MaxDepth: 3,
-		})
-
-		for _, fetchedContent := range fetchedContents {
-			tokens, err := tokenizer.Tokenize(fetchedContent.Body, lexicon)
-			if err != nil {
-				fmt.Println(err)
-				os.Exit(1)
-			}
+	case "collocations":
+		if err := collocations(repo, os.Args[2:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 
-			err = repo.RegisterTokens(fetchedContent.Id, tokens)
-			if err != nil {
-				fmt.Println(err)
-				os.Exit(1)
-			}
+	case "serve":
+		if err := serve(repo, logger, os.Args[2:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
 
 	default:
@@ -325,3 +597,467 @@ func main() {
 	}
 
 }
+
+// tokenizePool returns a pool tokenizing articles as they are fetched, or
+// nil if the lexicon has not been populated, in which case they are left
+// for tokenize_all.
+func tokenizePool(repo r.Repository, logger *slog.Logger) (*t.Pool, error) {
+	lexiconName := "Traditional Chinese Comprehensive"
+	lexiconLang := languages.ZH_TW
+	lexicon := l.NewZhTwLexicon(lexiconName, lexiconLang)
+	if err := lexicon.LoadRepository(repo); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if lexicon.NumEntries() == 0 {
+		return nil, nil
+	}
+
+	return t.NewPool(t.PoolOptions{
+		Tokenizer: zhtw.NewTokenizer(&t.Options{
+			MaxDepth: 3,
+		}),
+		Lexicon:    lexicon,
+		Repository: repo,
+		Workers:    tokenizeWorkers,
+		Logger:     logger,
+	}), nil
+}
+
+// onContentSaved returns the fetcher hook queueing articles in pool, if
+// any.
+func onContentSaved(pool *t.Pool) func(int, *content.FetchedContent) {
+	if pool == nil {
+		return nil
+	}
+	return pool.OnContentSaved
+}
+
+// closeTokenizePool waits for the articles queued in pool, if any, to be
+// tokenized.
+func closeTokenizePool(pool *t.Pool) {
+	if pool == nil {
+		return
+	}
+	stats := pool.Close()
+	fmt.Printf("Tokenized %d fetched articles (%d failed).\n", stats.Tokenized, stats.Failed)
+}
+
+// tokenizeAll runs the tokenize_all command, which tokenizes the
+// untokenized articles on a pipeline of workers, printing its progress,
+// until none is left or it is interrupted. Articles that fail to tokenize
+// are recorded, and retried by the next run.
+func tokenizeAll(repo r.Repository, logger *slog.Logger, args []string) error {
+	flags := flag.NewFlagSet("tokenize_all", flag.ContinueOnError)
+	contentFilter := filterFlags(flags)
+	workers := flags.Int("workers", tokenizeWorkers, "articles tokenized at once, or 0 for one per CPU")
+	batchSize := flags.Int("batch", pipeline.DefaultBatchSize, "articles whose tokens are registered at once")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return errors.New(usage)
+	}
+	filter, err := contentFilter()
+	if err != nil {
+		return err
+	}
+
+	lexiconName := "Traditional Chinese Comprehensive"
+	lexiconLang := languages.ZH_TW
+	lexicon := l.NewZhTwLexicon(lexiconName, lexiconLang)
+	if err := lexicon.LoadRepository(repo); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	started := false
+	progress, err := pipeline.Run(ctx, pipeline.Options{
+		Tokenizer: zhtw.NewTokenizer(&t.Options{
+			MaxDepth: 3,
+		}),
+		Lexicon:    lexicon,
+		Repository: repo,
+		Filter:     filter,
+		Workers:    *workers,
+		BatchSize:  *batchSize,
+		Progress: func(p pipeline.Progress) {
+			if !started {
+				fmt.Printf("Found %d untokenized articles.\n", p.Total)
+				started = true
+			}
+			fmt.Printf("%d/%d (%d failed), %.1f articles/s, ETA %s\n",
+				p.Done(), p.Total, p.Failed, p.Rate(), p.ETA().Round(time.Second))
+		},
+		Logger: logger,
+	})
+	fmt.Printf("Tokenized %d articles (%d failed) in %s.\n", progress.Tokenized, progress.Failed, progress.Elapsed.Round(time.Second))
+	if errors.Is(err, context.Canceled) {
+		return errors.New("interrupted; the remaining articles are left untokenized")
+	}
+	return err
+}
+
+// search runs the search command, which lists the tokenized content
+// matching a query, best first, with the matches highlighted.
+func search(repo r.Repository, args []string) error {
+	flags := flag.NewFlagSet("search", flag.ContinueOnError)
+	contentFilter := filterFlags(flags)
+	limit := flags.Int("limit", searchLimit, "maximum number of results")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New(usage)
+	}
+	filter, err := contentFilter()
+	if err != nil {
+		return err
+	}
+
+	lexiconName := "Traditional Chinese Comprehensive"
+	lexiconLang := languages.ZH_TW
+	lexicon := l.NewZhTwLexicon(lexiconName, lexiconLang)
+	if err := lexicon.LoadRepository(repo); err != nil {
+		return err
+	}
+
+	// Query terms are split into words as the content was
+	tokenizer := zhtw.NewTokenizer(&t.Options{
+		MaxDepth: 3,
+	})
+	segment := func(term string) []string {
+		tokens, err := tokenizer.Tokenize(term, lexicon)
+		if err != nil {
+			return []string{term}
+		}
+		words := []string{}
+		for _, token := range tokens {
+			if strings.TrimSpace(token.Word) != "" {
+				words = append(words, token.Word)
+			}
+		}
+		return words
+	}
+
+	query, err := r.ParseSearchQuery(strings.Join(flags.Args(), " "), segment)
+	if err != nil {
+		return err
+	}
+
+	results, err := repo.Search(context.Background(), query, filter, *limit)
+	if err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		fmt.Printf("%d\t%.2f\t%s\t%s\t%s\n", result.Content.Id, result.Score, result.Content.Date, result.Content.CanonName, result.Content.Title)
+		fmt.Printf("\t%s\n", result.Snippet.Highlight("【", "】"))
+	}
+	fmt.Printf("%d results for %s\n", len(results), query)
+	return nil
+}
+
+// kwic runs the kwic command, which lists the occurrences of a word in
+// tokenized content with their context, the key word aligned.
+func kwic(repo r.Repository, args []string) error {
+	flags := flag.NewFlagSet("kwic", flag.ContinueOnError)
+	contentFilter := filterFlags(flags)
+	window := flags.Int("window", kwicWindow, "words of context on either side")
+	order := flags.String("sort", string(r.ByPosition), "order of the lines: position, left or right")
+	limit := flags.Int("limit", 0, "maximum number of lines, or 0 for all")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New(usage)
+	}
+	filter, err := contentFilter()
+	if err != nil {
+		return err
+	}
+
+	lines, err := repo.Concordance(context.Background(), flags.Arg(0), *window, filter)
+	if err != nil {
+		return err
+	}
+	if err := r.SortConcordance(lines, r.ConcordanceOrder(*order)); err != nil {
+		return err
+	}
+	if *limit > 0 && *limit < len(lines) {
+		lines = lines[:*limit]
+	}
+
+	// Left contexts are right-aligned, by the width of their characters
+	lefts := make([]string, len(lines))
+	leftWidth := 0
+	for i, line := range lines {
+		lefts[i] = joinTokens(line.Left)
+		if w := displayWidth(lefts[i]); w > leftWidth {
+			leftWidth = w
+		}
+	}
+	for i, line := range lines {
+		padding := strings.Repeat(" ", leftWidth-displayWidth(lefts[i]))
+		fmt.Printf("%6d  %s%s 【%s】 %s\n", line.ContentId, padding, lefts[i], line.Word, joinTokens(line.Right))
+	}
+	fmt.Printf("%d lines\n", len(lines))
+	return nil
+}
+
+// wordStats runs the stats command, which writes the frequencies of the
+// words of tokenized content, most frequent first.
+func wordStats(repo r.Repository, args []string) error {
+	if len(args) == 0 || args[0] != "freq" {
+		return errors.New(usage)
+	}
+
+	flags := flag.NewFlagSet("stats freq", flag.ContinueOnError)
+	contentFilter := filterFlags(flags)
+	minFrequency := flags.Int("min", 1, "minimum frequency of the words listed")
+	limit := flags.Int("limit", 0, "maximum number of words, or 0 for all")
+	format := flags.String("format", "tsv", "output format: tsv or json")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 || (*format != "tsv" && *format != "json") {
+		return errors.New(usage)
+	}
+	filter, err := contentFilter()
+	if err != nil {
+		return err
+	}
+
+	frequencies, err := stats.WordFrequencies(context.Background(), repo, filter)
+	if err != nil {
+		return err
+	}
+	frequencies.Limit(*minFrequency, *limit)
+
+	if *format == "json" {
+		return frequencies.WriteJSON(os.Stdout)
+	}
+	return frequencies.WriteTSV(os.Stdout)
+}
+
+// collocations runs the collocations command, which writes the words found
+// near a word or, with -ngram, the n-grams containing it, most strongly
+// associated first.
+func collocations(repo r.Repository, args []string) error {
+	flags := flag.NewFlagSet("collocations", flag.ContinueOnError)
+	contentFilter := filterFlags(flags)
+	left := flags.Int("left", collocationWindow, "words to the left counted as collocates")
+	right := flags.Int("right", collocationWindow, "words to the right counted as collocates")
+	ngram := flags.Int("ngram", 0, "list n-grams of this many words containing the word instead")
+	minFrequency := flags.Int("min", 3, "minimum frequency of the collocations listed")
+	measure := flags.String("sort", string(stats.ByLogLikelihood), "association measure: ll, pmi, t or frequency")
+	limit := flags.Int("limit", 50, "maximum number of collocations, or 0 for all")
+	format := flags.String("format", "tsv", "output format: tsv or json")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 || (*format != "tsv" && *format != "json") {
+		return errors.New(usage)
+	}
+	filter, err := contentFilter()
+	if err != nil {
+		return err
+	}
+	word := flags.Arg(0)
+
+	var result interface{}
+	var labels []string // the collocates or n-grams, for TSV
+	var associations []stats.Association
+	if *ngram > 0 {
+		ngrams, err := stats.NGrams(context.Background(), repo, stats.NGramOptions{
+			Filter:       filter,
+			N:            *ngram,
+			Word:         word,
+			MinFrequency: *minFrequency,
+			Measure:      stats.Measure(*measure),
+			Limit:        *limit,
+		})
+		if err != nil {
+			return err
+		}
+		result = ngrams
+		for _, n := range ngrams {
+			labels = append(labels, strings.Join(n.Words, " "))
+			associations = append(associations, n.Association)
+		}
+	} else {
+		collocations, err := stats.Collocations(context.Background(), repo, word, stats.CollocationOptions{
+			Filter:       filter,
+			Left:         *left,
+			Right:        *right,
+			MinFrequency: *minFrequency,
+			Measure:      stats.Measure(*measure),
+			Limit:        *limit,
+		})
+		if err != nil {
+			return err
+		}
+		result = collocations
+		for _, c := range collocations {
+			labels = append(labels, c.Collocate)
+			associations = append(associations, c.Association)
+		}
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	}
+
+	fmt.Println("collocation\tfrequency\texpected\tpmi\tlog_likelihood\tt_score")
+	for i, label := range labels {
+		a := associations[i]
+		fmt.Printf("%s\t%d\t%.2f\t%.3f\t%.3f\t%.3f\n", label, a.Frequency, a.Expected, a.PMI, a.LogLikelihood, a.TScore)
+	}
+	return nil
+}
+
+// serve runs the serve command, which serves the API of the server
+// package until interrupted. The lexicon is loaded once, and shared by the
+// requests to tokenize text.
+func serve(repo r.Repository, logger *slog.Logger, args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := flags.String("addr", serveAddr, "address to listen on")
+	maxDepth := flags.Int("max-depth", 3, "depth of the tokenizer, unless given by the request")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	lexiconName := "Traditional Chinese Comprehensive"
+	lexiconLang := languages.ZH_TW
+	lexicon := l.NewZhTwLexicon(lexiconName, lexiconLang)
+	if err := lexicon.LoadRepository(repo); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	options := server.Options{Logger: logger, MaxDepth: *maxDepth}
+	if lexicon.NumEntries() > 0 {
+		options.Lexicon = lexicon
+	} else {
+		logging.Component(logger, "server").Warn("lexicon empty; not serving tokenization", "lexicon", lexiconName)
+	}
+
+	httpServer := &http.Server{
+		Addr:              *addr,
+		Handler:           server.New(repo, options),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	logging.Component(logger, "server").Info("serving API", "addr", *addr)
+	if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// filterFlags defines the flags selecting content on flags. The returned
+// function gives the filter once the flags are parsed.
+func filterFlags(flags *flag.FlagSet) func() (r.ContentFilter, error) {
+	var filter r.ContentFilter
+	flags.StringVar(&filter.Source, "source", "", "canonical name of the outlet")
+	flags.StringVar(&filter.Tag, "tag", "", "tag of the content")
+	flags.StringVar(&filter.Language, "language", "", "language of the content")
+	after := flags.String("after", "", "only content published after this date (YYYY-MM-DD)")
+	before := flags.String("before", "", "only content published before this date (YYYY-MM-DD)")
+
+	return func() (r.ContentFilter, error) {
+		var err error
+		if *after != "" {
+			if filter.After, err = time.Parse("2006-01-02", *after); err != nil {
+				return filter, err
+			}
+		}
+		if *before != "" {
+			if filter.Before, err = time.Parse("2006-01-02", *before); err != nil {
+				return filter, err
+			}
+		}
+		return filter, nil
+	}
+}
+
+// joinTokens joins tokens into text on a single line.
+func joinTokens(tokens []string) string {
+	return strings.Join(strings.Fields(strings.Join(tokens, "")), " ")
+}
+
+// displayWidth returns the number of columns s takes up in a terminal, in
+// which East Asian wide characters take up two.
+func displayWidth(s string) int {
+	w := 0
+	for _, c := range s {
+		switch width.LookupRune(c).Kind() {
+		case width.EastAsianWide, width.EastAsianFullwidth:
+			w += 2
+		default:
+			w++
+		}
+	}
+	return w
+}
+
+// migrate runs the db command, which applies, reports on or reverts the
+// migrations of the database schema.
+func migrate(args []string, logger *slog.Logger) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	m, err := r.NewMigrator(r.RepositoryOptions{Logger: logger})
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	switch args[0] {
+	case "migrate":
+		n, err := m.Migrate()
+		fmt.Printf("%d migrations applied\n", n)
+		return err
+	case "status":
+		statuses, err := m.Status()
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, status := range statuses {
+			applied := "pending"
+			if !status.Applied.IsZero() {
+				applied = status.Applied.Format("2006-01-02 15:04")
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", status.Version, status.Name, applied)
+		}
+		return w.Flush()
+	case "rollback":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps <= 0 {
+				return errors.New(usage)
+			}
+		}
+
+		n, err := m.Rollback(steps)
+		fmt.Printf("%d migrations reverted\n", n)
+		return err
+	default:
+		return errors.New(usage)
+	}
+}
@@ -0,0 +1,248 @@
+// Package pipeline tokenizes the untokenized content of a repository in
+// bulk. Content is read as a stream, tokenized on a number of workers
+// sharing the lexicon, which is only read, and its tokens are registered in
+// batches. Content that cannot be tokenized is recorded as a failure
+// without stopping the run.
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"time"
+
+	"github.com/qwwqe/tcsuite/content"
+	"github.com/qwwqe/tcsuite/entities/corpus"
+	l "github.com/qwwqe/tcsuite/lexicon"
+	"github.com/qwwqe/tcsuite/logging"
+	"github.com/qwwqe/tcsuite/repository"
+	"github.com/qwwqe/tcsuite/tokenizer"
+)
+
+// Number of contents whose tokens are registered at once by default
+var DefaultBatchSize = 100
+
+// Options configures a run of the pipeline.
+type Options struct {
+	Tokenizer  tokenizer.Interface
+	Lexicon    l.Lexicon // shared by the workers, and only read
+	Repository repository.Repository
+
+	// Filter selects the content tokenized, of which only the untokenized
+	// is read whatever its Tokenized field.
+	Filter repository.ContentFilter
+
+	Workers   int // contents tokenized at once; runtime.NumCPU() if 0
+	BatchSize int // contents registered at once; DefaultBatchSize if 0
+
+	// Progress, if set, is called from the goroutine of Run after each
+	// batch is registered and each failure recorded.
+	Progress func(Progress)
+
+	Logger *slog.Logger // if nil, slog's default logger is used
+}
+
+// Progress reports how far a run of the pipeline has gone.
+type Progress struct {
+	Total     int // untokenized contents when the run started
+	Tokenized int
+	Failed    int
+	Elapsed   time.Duration
+}
+
+// Done returns the number of contents handled, tokenized or not.
+func (p Progress) Done() int {
+	return p.Tokenized + p.Failed
+}
+
+// Rate returns the number of contents handled per second.
+func (p Progress) Rate() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Done()) / p.Elapsed.Seconds()
+}
+
+// ETA estimates the time left to handle the remaining contents at the
+// current rate, or returns 0 if nothing has been handled yet.
+func (p Progress) ETA() time.Duration {
+	rate := p.Rate()
+	remaining := p.Total - p.Done()
+	if rate == 0 || remaining <= 0 {
+		return 0
+	}
+	return time.Duration(float64(remaining) / rate * float64(time.Second))
+}
+
+// result is the outcome of tokenizing a content.
+type result struct {
+	contentId int
+	tokens    []*corpus.Word
+	err       error
+}
+
+// Run tokenizes the untokenized content matched by options.Filter until
+// none is left or ctx is done, returning how far it went. When ctx is done,
+// the contents being tokenized are abandoned, those tokenized already are
+// registered, and ctx.Err() is returned. Run stops at the first error of
+// the repository other than a failure to register the tokens of a content.
+func Run(ctx context.Context, options Options) (Progress, error) {
+	if options.Workers <= 0 {
+		options.Workers = runtime.NumCPU()
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = DefaultBatchSize
+	}
+	filter := options.Filter
+	filter.Tokenized = repository.NotTokenized
+
+	total, err := options.Repository.CountContent(ctx, filter)
+	if err != nil {
+		return Progress{}, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Content is read ahead of the workers by as many contents as there are
+	// workers, so that they need not wait for the next page
+	contents := make(chan *content.FetchedContent, options.Workers)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(contents)
+		readErr <- options.Repository.IterateContent(ctx, filter, func(c *content.FetchedContent) error {
+			select {
+			case contents <- c:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	results := make(chan result, options.Workers)
+	workers := make(chan struct{})
+	for i := 0; i < options.Workers; i++ {
+		go func() {
+			defer func() { workers <- struct{}{} }()
+			for {
+				select {
+				case c, ok := <-contents:
+					if !ok {
+						return
+					}
+					tokens, err := tokenizer.Tokenize(options.Tokenizer, c.Body, options.Lexicon)
+					results <- result{contentId: c.Id, tokens: tokens, err: err}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		for i := 0; i < options.Workers; i++ {
+			<-workers
+		}
+		close(results)
+	}()
+
+	w := &writer{
+		options:  options,
+		logger:   logging.Component(options.Logger, "pipeline"),
+		progress: Progress{Total: total},
+		started:  time.Now(),
+	}
+	for r := range results {
+		if w.err != nil {
+			continue // drain the workers
+		}
+		if w.add(r); w.err != nil {
+			cancel()
+		}
+	}
+	if w.err == nil {
+		w.flush()
+	}
+	w.progress.Elapsed = time.Since(w.started)
+	if w.err != nil {
+		return w.progress, w.err
+	}
+
+	if err := <-readErr; err != nil {
+		return w.progress, err
+	}
+	return w.progress, nil
+}
+
+// writer registers the tokens of the results in batches and records the
+// failures, keeping count of both.
+type writer struct {
+	options  Options
+	logger   *slog.Logger
+	batch    []*repository.TokenizedContent
+	progress Progress
+	started  time.Time
+	err      error
+}
+
+func (w *writer) add(r result) {
+	if r.err != nil {
+		if w.fail(r.contentId, r.err); w.err == nil {
+			w.report()
+		}
+		return
+	}
+
+	w.batch = append(w.batch, &repository.TokenizedContent{ContentId: r.contentId, Tokens: r.tokens})
+	if len(w.batch) >= w.options.BatchSize {
+		w.flush()
+	}
+}
+
+// flush registers the tokens of the batch. Should the batch fail, its
+// contents are registered one by one, so that only those at fault fail.
+func (w *writer) flush() {
+	if len(w.batch) == 0 {
+		return
+	}
+	batch := w.batch
+	w.batch = nil
+
+	repo := w.options.Repository
+	if err := repo.RegisterTokenBatch(batch); err == nil {
+		w.progress.Tokenized += len(batch)
+	} else {
+		w.logger.Warn("error registering batch; registering its contents one by one", "contents", len(batch), logging.ErrorKey, err)
+		for _, c := range batch {
+			if err := repo.RegisterTokens(c.ContentId, c.Tokens); err != nil {
+				w.fail(c.ContentId, err)
+				if w.err != nil {
+					return
+				}
+				continue
+			}
+			w.progress.Tokenized++
+		}
+	}
+	w.report()
+}
+
+// fail records that the content with the given id could not be tokenized.
+// Failing to record it stops the run, as the repository is then unlikely
+// to register any tokens either.
+func (w *writer) fail(contentId int, err error) {
+	w.logger.Error("error tokenizing content", logging.ContentIdKey, contentId, logging.ErrorKey, err)
+	if recordErr := w.options.Repository.RecordTokenizeFailure(contentId, err.Error()); recordErr != nil {
+		w.err = fmt.Errorf("recording failure of content %d: %w", contentId, recordErr)
+		return
+	}
+	w.progress.Failed++
+}
+
+func (w *writer) report() {
+	w.progress.Elapsed = time.Since(w.started)
+	if w.options.Progress != nil {
+		w.options.Progress(w.progress)
+	}
+}
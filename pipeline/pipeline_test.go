@@ -0,0 +1,202 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qwwqe/tcsuite/content"
+	"github.com/qwwqe/tcsuite/entities/corpus"
+	l "github.com/qwwqe/tcsuite/lexicon"
+	"github.com/qwwqe/tcsuite/repository"
+	"github.com/qwwqe/tcsuite/repository/memory"
+)
+
+// runeTokenizer tokenizes text into its characters, failing on empty text
+// and panicking on "panic".
+type runeTokenizer struct{}
+
+func (runeTokenizer) Tokenize(text string, lexicon l.Lexicon) ([]*corpus.Word, error) {
+	if text == "" {
+		return nil, errors.New("empty text")
+	}
+	if text == "panic" {
+		panic("bad document")
+	}
+	words := []*corpus.Word{}
+	for _, r := range text {
+		words = append(words, &corpus.Word{Word: string(r), Lexical: r != '。'})
+	}
+	return words, nil
+}
+
+// batchRepository counts the batches registered, refusing those holding
+// the content with id refused.
+type batchRepository struct {
+	repository.Repository
+	refused int
+
+	mu      sync.Mutex
+	batches []int // sizes
+}
+
+func (r *batchRepository) RegisterTokenBatch(batch []*repository.TokenizedContent) error {
+	r.mu.Lock()
+	r.batches = append(r.batches, len(batch))
+	r.mu.Unlock()
+
+	for _, c := range batch {
+		if c.ContentId == r.refused {
+			return errors.New("refused")
+		}
+	}
+	return r.Repository.RegisterTokenBatch(batch)
+}
+
+func (r *batchRepository) RegisterTokens(contentId int, tokens []*corpus.Word) error {
+	if contentId == r.refused {
+		return errors.New("refused")
+	}
+	return r.Repository.RegisterTokens(contentId, tokens)
+}
+
+// newRepository returns a repository holding n contents, with bodies given
+// by body.
+func newRepository(t *testing.T, n int, body func(i int) string) *batchRepository {
+	repo := &batchRepository{Repository: memory.New(repository.RepositoryOptions{})}
+	for i := 0; i < n; i++ {
+		_, err := repo.SaveContent(&content.FetchedContent{
+			Title:    "title",
+			Body:     body(i),
+			Uri:      fmt.Sprintf("https://example.com/%d", i),
+			Language: "zh-TW",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repo
+}
+
+func options(repo repository.Repository) Options {
+	return Options{
+		Tokenizer:  runeTokenizer{},
+		Repository: repo,
+		Workers:    4,
+		BatchSize:  10,
+		Logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	bodies := map[int]string{6: "", 17: "panic"}
+	repo := newRepository(t, 50, func(i int) string {
+		if body, ok := bodies[i]; ok {
+			return body
+		}
+		return fmt.Sprintf("地震%d。", i)
+	})
+	repo.refused = 31 // the body of index 30
+
+	var reports []Progress
+	opts := options(repo)
+	opts.Progress = func(p Progress) {
+		reports = append(reports, p)
+	}
+	progress, err := Run(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.Total != 50 || progress.Tokenized != 47 || progress.Failed != 3 {
+		t.Errorf("Run() = %+v; want 47 of 50 tokenized, 3 failed", progress)
+	}
+	if len(reports) == 0 || reports[len(reports)-1].Done() != 50 {
+		t.Errorf("Run() reported progress %+v; want the last to be done", reports)
+	}
+	for i := 1; i < len(reports); i++ {
+		if reports[i].Done() < reports[i-1].Done() {
+			t.Errorf("Run() reported progress %+v; want it to grow", reports)
+			break
+		}
+	}
+	for _, size := range repo.batches {
+		if size > 10 {
+			t.Errorf("Run() registered batches of %v; want at most 10 contents each", repo.batches)
+			break
+		}
+	}
+
+	failures, err := repo.GetTokenizeFailures(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[int]string{}
+	for _, f := range failures {
+		got[f.ContentId] = f.Error
+	}
+	want := map[int]string{7: "empty text", 18: "tokenizer panicked: bad document", 31: "refused"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetTokenizeFailures() = %v; want %v", got, want)
+	}
+	if tokens, err := repo.GetTokens(ctx, 30); err != nil || len(tokens) != len([]rune("地震29。")) {
+		t.Errorf("GetTokens(30) = %v, %v; want the tokens of 地震29。", tokens, err)
+	}
+
+	// Failed content is retried
+	repo.refused = 0
+	if progress, err = Run(ctx, options(repo)); err != nil {
+		t.Fatal(err)
+	}
+	if progress.Total != 3 || progress.Tokenized != 1 || progress.Failed != 2 {
+		t.Errorf("Run() again = %+v; want 1 of 3 tokenized, 2 failed", progress)
+	}
+	if failures, _ = repo.GetTokenizeFailures(ctx); len(failures) != 2 || failures[0].Attempts != 2 {
+		t.Errorf("GetTokenizeFailures() after retry = %v; want 2 failures of 2 attempts", failures)
+	}
+}
+
+func TestRunCanceled(t *testing.T) {
+	repo := newRepository(t, 200, func(i int) string { return fmt.Sprintf("地震%d。", i) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := options(repo)
+	opts.Progress = func(p Progress) {
+		if p.Tokenized >= 20 {
+			cancel()
+		}
+	}
+	progress, err := Run(ctx, opts)
+	if err != context.Canceled {
+		t.Fatalf("Run() canceled = %+v, %v; want %v", progress, err, context.Canceled)
+	}
+	if progress.Tokenized < 20 || progress.Tokenized == 200 {
+		t.Errorf("Run() canceled tokenized %d of 200", progress.Tokenized)
+	}
+
+	// Whatever was counted was registered, and the rest left untokenized
+	left, err := repo.CountContent(context.Background(), repository.ContentFilter{Tokenized: repository.NotTokenized})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left != 200-progress.Tokenized {
+		t.Errorf("CountContent(untokenized) after cancel = %d; want %d", left, 200-progress.Tokenized)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	p := Progress{Total: 100, Tokenized: 20, Failed: 5, Elapsed: 5 * time.Second}
+	if p.Done() != 25 || p.Rate() != 5 || p.ETA() != 15*time.Second {
+		t.Errorf("Progress %+v: Done() = %d, Rate() = %v, ETA() = %v; want 25, 5, 15s", p, p.Done(), p.Rate(), p.ETA())
+	}
+	if p := (Progress{Total: 100}); p.Rate() != 0 || p.ETA() != 0 {
+		t.Errorf("Progress %+v: Rate() = %v, ETA() = %v; want 0, 0", p, p.Rate(), p.ETA())
+	}
+}
@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ConcordanceLine is an occurrence of a word in tokenized content, along
+// with its context: a key word in context (KWIC) line.
+type ConcordanceLine struct {
+	ContentId int
+	Position  int // of the word in the tokens of the content
+
+	// Tokens before and after the word, in order, up to the window of
+	// words on either side. White space is kept but not counted.
+	Left  []string
+	Word  string
+	Right []string
+}
+
+// ConcordanceOrder is the order in which concordance lines are sorted.
+type ConcordanceOrder string
+
+const (
+	ByPosition     ConcordanceOrder = "position" // by content id and position
+	ByLeftContext  ConcordanceOrder = "left"     // by the words before, nearest first
+	ByRightContext ConcordanceOrder = "right"    // by the words after, nearest first
+)
+
+// ConcordanceLines returns the lines of the occurrences of word in the
+// tokens of the content with the given id, with window words on either
+// side, for implementations of Repository that do not read the tokens in
+// SQL.
+func ConcordanceLines(contentId int, tokens []string, word string, window int) []*ConcordanceLine {
+	lines := []*ConcordanceLine{}
+	for i, token := range tokens {
+		if token != word {
+			continue
+		}
+
+		start := i
+		for n := 0; start > 0 && n < window; {
+			start--
+			if !isSpace(tokens[start]) {
+				n++
+			}
+		}
+		end := i + 1
+		for n := 0; end < len(tokens) && n < window; end++ {
+			if !isSpace(tokens[end]) {
+				n++
+			}
+		}
+
+		lines = append(lines, &ConcordanceLine{
+			ContentId: contentId,
+			Position:  i,
+			Left:      append([]string{}, tokens[start:i]...),
+			Word:      token,
+			Right:     append([]string{}, tokens[i+1:end]...),
+		})
+	}
+	return lines
+}
+
+// SortConcordance sorts lines in the given order. Lines with the same
+// context remain in order of position.
+func SortConcordance(lines []*ConcordanceLine, order ConcordanceOrder) error {
+	var compare func(a, b *ConcordanceLine) int
+	switch order {
+	case "", ByPosition:
+		compare = func(a, b *ConcordanceLine) int { return 0 }
+	case ByLeftContext:
+		compare = func(a, b *ConcordanceLine) int {
+			if c := compareWords(reversed(words(a.Left)), reversed(words(b.Left))); c != 0 {
+				return c
+			}
+			return compareWords(words(a.Right), words(b.Right))
+		}
+	case ByRightContext:
+		compare = func(a, b *ConcordanceLine) int {
+			if c := compareWords(words(a.Right), words(b.Right)); c != 0 {
+				return c
+			}
+			return compareWords(reversed(words(a.Left)), reversed(words(b.Left)))
+		}
+	default:
+		return fmt.Errorf("unknown concordance order %q", order)
+	}
+
+	sort.SliceStable(lines, func(i, j int) bool {
+		if c := compare(lines[i], lines[j]); c != 0 {
+			return c < 0
+		}
+		if lines[i].ContentId != lines[j].ContentId {
+			return lines[i].ContentId < lines[j].ContentId
+		}
+		return lines[i].Position < lines[j].Position
+	})
+	return nil
+}
+
+// words returns tokens without white space.
+func words(tokens []string) []string {
+	words := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		if !isSpace(token) {
+			words = append(words, token)
+		}
+	}
+	return words
+}
+
+func reversed(words []string) []string {
+	for i, j := 0, len(words)-1; i < j; i, j = i+1, j-1 {
+		words[i], words[j] = words[j], words[i]
+	}
+	return words
+}
+
+func compareWords(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := strings.Compare(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
+
+func isSpace(token string) bool {
+	return strings.TrimSpace(token) == ""
+}
@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConcordanceLines(t *testing.T) {
+	tokens := []string{"a", "b", " ", "c", "b", " ", "d", " ", "b"}
+	got := ConcordanceLines(1, tokens, "b", 2)
+	want := []*ConcordanceLine{
+		{ContentId: 1, Position: 1, Left: []string{"a"}, Word: "b", Right: []string{" ", "c", "b"}},
+		{ContentId: 1, Position: 4, Left: []string{"b", " ", "c"}, Word: "b", Right: []string{" ", "d", " ", "b"}},
+		{ContentId: 1, Position: 8, Left: []string{"b", " ", "d", " "}, Word: "b", Right: []string{}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ConcordanceLines() = %v; want %v", got, want)
+	}
+
+	if got := ConcordanceLines(1, tokens, "b", 0); len(got) != 3 || len(got[1].Left) != 0 || len(got[1].Right) != 0 {
+		t.Errorf("ConcordanceLines(window 0) = %v; want 3 lines without context", got)
+	}
+}
+
+func TestSortConcordance(t *testing.T) {
+	line := func(id int, position int, left string, right string) *ConcordanceLine {
+		return &ConcordanceLine{ContentId: id, Position: position, Left: strings.Fields(left), Word: "w", Right: strings.Fields(right)}
+	}
+	lines := []*ConcordanceLine{
+		line(1, 5, "x b", "c"),
+		line(1, 2, "a c", "a b"),
+		line(2, 0, "", "a"),
+		line(3, 4, "y b", "c"),
+	}
+
+	tests := []struct {
+		order ConcordanceOrder
+		want  [][2]int // content id and position
+	}{
+		{ByPosition, [][2]int{{1, 2}, {1, 5}, {2, 0}, {3, 4}}},
+		{ByLeftContext, [][2]int{{2, 0}, {1, 5}, {3, 4}, {1, 2}}},
+		{ByRightContext, [][2]int{{2, 0}, {1, 2}, {1, 5}, {3, 4}}},
+	}
+	for _, test := range tests {
+		sorted := append([]*ConcordanceLine{}, lines...)
+		if err := SortConcordance(sorted, test.order); err != nil {
+			t.Fatal(err)
+		}
+		got := [][2]int{}
+		for _, l := range sorted {
+			got = append(got, [2]int{l.ContentId, l.Position})
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("SortConcordance(%s) = %v; want %v", test.order, got, test.want)
+		}
+	}
+
+	if err := SortConcordance(lines, "title"); err == nil {
+		t.Errorf("SortConcordance(title) = nil; want error")
+	}
+}
@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Environment variable naming the configuration file
+const ConfigFileEnv = "TCSUITE_CONFIG"
+
+// Config is the configuration file read by NewRepository, a JSON object
+// such as {"dsn": "host=db.example.com user=tcsuite dbname=tcsuite"} or
+// {"driver": "sqlite", "dsn": "/home/rosie/corpus.db"}.
+type Config struct {
+	Driver string `json:"driver"`
+	Dsn    string `json:"dsn"`
+}
+
+// DefaultConfigFile returns the path of the configuration file read when
+// neither RepositoryOptions.ConfigFile nor TCSUITE_CONFIG is set.
+func DefaultConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "tcsuite", "config.json")
+}
+
+// LoadConfig reads the configuration file at path.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return config, nil
+}
+
+// connectionString resolves the driver and connection string of the
+// database, in order of precedence from options and the configuration
+// file. For PostgreSQL, parameters left unspecified are taken by the
+// driver from the standard PG* environment variables (PGHOST, PGUSER,
+// PGDATABASE, ...). Failing those, the database defaults to "tcsuite" and
+// SSL is disabled, as befits a database on the local host. For SQLite,
+// the connection string is the path of the database file.
+func connectionString(options RepositoryOptions) (string, string, error) {
+	driver, dsn := options.Driver, options.Dsn
+
+	if dsn == "" {
+		// An explicitly named configuration file must exist
+		path, required := options.ConfigFile, true
+		if path == "" {
+			path = os.Getenv(ConfigFileEnv)
+		}
+		if path == "" {
+			path, required = DefaultConfigFile(), false
+		}
+
+		if path != "" {
+			config, err := LoadConfig(path)
+			if err == nil {
+				dsn = config.Dsn
+				if driver == "" {
+					driver = config.Driver
+				}
+			} else if required || !os.IsNotExist(err) {
+				return "", "", err
+			}
+		}
+	}
+
+	if driver == "" {
+		driver = Postgres
+	}
+
+	switch driver {
+	case Postgres:
+		dsn = withDefault(dsn, "dbname", "PGDATABASE", "tcsuite")
+		dsn = withDefault(dsn, "sslmode", "PGSSLMODE", "disable")
+	case SQLite:
+		if dsn == "" {
+			return "", "", errors.New("no SQLite database file given")
+		}
+		dsn = sqliteDataSource(dsn)
+	default:
+		return "", "", fmt.Errorf("unsupported database driver %q", driver)
+	}
+
+	return driver, dsn, nil
+}
+
+// withDefault sets parameter key of a connection string to value unless
+// the connection string or the environment variable env already sets it.
+// Database names of URLs are left alone, as they are part of the path.
+func withDefault(dsn string, key string, env string, value string) string {
+	if os.Getenv(env) != "" {
+		return dsn
+	}
+
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		if key == "dbname" || strings.Contains(dsn, key+"=") {
+			return dsn
+		}
+		if strings.Contains(dsn, "?") {
+			return dsn + "&" + key + "=" + value
+		}
+		return dsn + "?" + key + "=" + value
+	}
+
+	for _, param := range strings.Fields(dsn) {
+		if strings.HasPrefix(param, key+"=") {
+			return dsn
+		}
+	}
+
+	return strings.TrimSpace(dsn + " " + key + "=" + value)
+}
@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConnectionString(t *testing.T) {
+	for _, env := range []string{"PGDATABASE", "PGSSLMODE"} {
+		t.Setenv(env, "")
+	}
+
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(configFile, []byte(`{"dsn": "host=db.example.com user=corpus sslmode=require"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(ConfigFileEnv, configFile)
+
+	tests := []struct {
+		name    string
+		options RepositoryOptions
+		want    string
+	}{
+		{
+			name:    "dsn",
+			options: RepositoryOptions{Dsn: "user=corpus dbname=news"},
+			want:    "user=corpus dbname=news sslmode=disable",
+		},
+		{
+			name:    "url",
+			options: RepositoryOptions{Dsn: "postgres://corpus@db.example.com/news?connect_timeout=5"},
+			want:    "postgres://corpus@db.example.com/news?connect_timeout=5&sslmode=disable",
+		},
+		{
+			name:    "sqlite",
+			options: RepositoryOptions{Driver: SQLite, Dsn: "corpus.db"},
+			want:    "corpus.db?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=case_sensitive_like(1)&_time_format=sqlite",
+		},
+		{
+			name:    "config file",
+			options: RepositoryOptions{},
+			want:    "host=db.example.com user=corpus sslmode=require dbname=tcsuite",
+		},
+	}
+
+	for _, test := range tests {
+		_, got, err := connectionString(test.options)
+		if err != nil {
+			t.Fatalf("%s: connectionString() = _, %v", test.name, err)
+		}
+		if got != test.want {
+			t.Errorf("%s: connectionString() = %q; want %q", test.name, got, test.want)
+		}
+	}
+
+	if _, _, err := connectionString(RepositoryOptions{Driver: "mysql", Dsn: "corpus"}); err == nil {
+		t.Errorf("connectionString() with an unsupported driver = _, _, nil; want error")
+	}
+
+	// Parameters set in the environment are left to the driver
+	t.Setenv("PGDATABASE", "news")
+	t.Setenv("PGSSLMODE", "verify-full")
+	t.Setenv(ConfigFileEnv, filepath.Join(dir, "missing.json"))
+	if _, _, err := connectionString(RepositoryOptions{}); err == nil {
+		t.Errorf("connectionString() with a missing config file = _, _, nil; want error")
+	}
+	if _, got, _ := connectionString(RepositoryOptions{ConfigFile: configFile}); got != "host=db.example.com user=corpus sslmode=require" {
+		t.Errorf("connectionString() with PG* variables = %q", got)
+	}
+}
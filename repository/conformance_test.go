@@ -0,0 +1,74 @@
+package repository_test
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/qwwqe/tcsuite/repository"
+	"github.com/qwwqe/tcsuite/repository/memory"
+	"github.com/qwwqe/tcsuite/repository/repositorytest"
+)
+
+// Environment variable holding the connection string of a PostgreSQL
+// database to run the conformance tests against, in key=value form. Each
+// test works in a schema of its own, dropped afterwards.
+const postgresTestEnv = "TCSUITE_TEST_POSTGRES"
+
+func TestMemoryConformance(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.Repository {
+		return memory.New(repository.RepositoryOptions{EnableCookies: true})
+	})
+}
+
+func TestSQLiteConformance(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.Repository {
+		return newRepository(t, repository.RepositoryOptions{
+			Driver: repository.SQLite,
+			Dsn:    filepath.Join(t.TempDir(), "corpus.db"),
+		})
+	})
+}
+
+func TestPostgresConformance(t *testing.T) {
+	dsn := os.Getenv(postgresTestEnv)
+	if dsn == "" {
+		t.Skipf("%s not set", postgresTestEnv)
+	}
+
+	db, err := sql.Open(repository.Postgres, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	repositorytest.Run(t, func(t *testing.T) repository.Repository {
+		schema := fmt.Sprintf("tcsuite_test_%d", time.Now().UnixNano())
+		if _, err := db.Exec("CREATE SCHEMA " + schema); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			db.Exec("DROP SCHEMA " + schema + " CASCADE")
+		})
+
+		return newRepository(t, repository.RepositoryOptions{
+			Driver: repository.Postgres,
+			Dsn:    dsn + " search_path=" + schema,
+		})
+	})
+}
+
+func newRepository(t *testing.T, options repository.RepositoryOptions) repository.Repository {
+	options.AutoMigrate = true
+	options.EnableCookies = true
+
+	repo, err := repository.NewRepository(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	return repo
+}
@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	pq "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+// Database drivers supported by NewRepository
+const (
+	Postgres = "postgres"
+	SQLite   = "sqlite"
+)
+
+// dialect holds what differs between the supported databases. Queries are
+// otherwise written in the SQL common to both: $n placeholders,
+// ON CONFLICT clauses naming their columns and RETURNING.
+type dialect struct {
+	// Directory of the dialect's migrations under migrations/
+	migrations string
+
+	// Statement creating the table recording the applied migrations
+	schemaMigrations string
+
+	// copyIn inserts rows of values for columns into table within tx
+	copyIn func(tx *sql.Tx, table string, columns []string, rows [][]interface{}) error
+
+	// LIMIT clause selecting all rows, needed by SQLite before OFFSET
+	noLimit string
+
+	// configure sets up the connection pool of a newly opened database
+	configure func(db *sql.DB)
+}
+
+var dialects = map[string]*dialect{
+	Postgres: {
+		migrations:       "migrations/postgres",
+		schemaMigrations: "CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY, name VARCHAR NOT NULL, applied TIMESTAMPTZ NOT NULL)",
+		copyIn:           postgresCopyIn,
+		noLimit:          "ALL",
+		configure: func(db *sql.DB) {
+			db.SetMaxOpenConns(50)
+			db.SetMaxIdleConns(0)
+		},
+	},
+	SQLite: {
+		migrations:       "migrations/sqlite",
+		schemaMigrations: "CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY, name VARCHAR NOT NULL, applied TIMESTAMP NOT NULL)",
+		copyIn:           insertEach,
+		noLimit:          "-1",
+		configure: func(db *sql.DB) {
+			// SQLite serialises writes anyway, and an in-memory database
+			// lives only as long as its one connection
+			db.SetMaxOpenConns(1)
+			db.SetMaxIdleConns(1)
+		},
+	},
+}
+
+// postgresCopyIn loads rows with COPY, which is much faster than INSERT.
+func postgresCopyIn(tx *sql.Tx, table string, columns []string, rows [][]interface{}) error {
+	stmt, err := tx.Prepare(pq.CopyIn(table, columns...))
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		if _, err = stmt.Exec(row...); err != nil {
+			stmt.Close()
+			return err
+		}
+	}
+
+	// Flush the buffered rows
+	if _, err = stmt.Exec(); err != nil {
+		stmt.Close()
+		return err
+	}
+
+	return stmt.Close()
+}
+
+// insertEach inserts rows one at a time with a prepared statement.
+func insertEach(tx *sql.Tx, table string, columns []string, rows [][]interface{}) error {
+	placeholders := make([]string, len(columns))
+	for i := range columns {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+
+	stmt, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		table, strings.Join(columns, ", "), strings.Join(placeholders, ", ")))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, row := range rows {
+		if _, err = stmt.Exec(row...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sqliteDataSource turns the path of an SQLite database into a data source
+// name enforcing foreign keys, waiting on locks rather than failing,
+// matching LIKE patterns case-sensitively, as PostgreSQL does, which also
+// lets them use indices, and storing times in a format that sorts
+// chronologically.
+func sqliteDataSource(path string) string {
+	params := "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=case_sensitive_like(1)&_time_format=sqlite"
+	if strings.Contains(path, "?") {
+		return path + "&" + params
+	}
+	return path + "?" + params
+}
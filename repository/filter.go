@@ -0,0 +1,161 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/qwwqe/tcsuite/content"
+)
+
+// Format of the dates of content, as saved by the fetchers
+const DateFormat = "2006-01-02 15:04:05"
+
+// ContentFilter selects content by its metadata. Fields left at their zero
+// value select all content.
+type ContentFilter struct {
+	Source   string // canonical name of the outlet
+	Tag      string
+	AnyTags  []string // content with at least one of these tags
+	AllTags  []string // content with every one of these tags
+	Author   string
+	Language string
+
+	// Publication dates are compared as saved, in the time zone of the
+	// outlet, so After and Before are taken in their own locations
+	After  time.Time // exclusive
+	Before time.Time // exclusive
+
+	// Bounds on the length of the body in characters, inclusive; 0 is
+	// unbounded
+	MinBodyLength int
+	MaxBodyLength int
+
+	Tokenized TokenizedState
+}
+
+// TokenizedState selects content by whether it has been tokenized.
+type TokenizedState int
+
+const (
+	AnyTokenized TokenizedState = iota
+	IsTokenized
+	NotTokenized
+)
+
+// Matches reports whether f selects c, for implementations of Repository
+// that do not filter in SQL.
+func (f ContentFilter) Matches(c *content.FetchedContent, tokenized bool) bool {
+	if f.Source != "" && c.CanonName != f.Source {
+		return false
+	}
+	if f.Tag != "" && !contains(c.Tags, f.Tag) {
+		return false
+	}
+	if len(f.AnyTags) > 0 && !containsAny(c.Tags, f.AnyTags) {
+		return false
+	}
+	for _, tag := range f.AllTags {
+		if !contains(c.Tags, tag) {
+			return false
+		}
+	}
+	if f.Author != "" && c.Author != f.Author {
+		return false
+	}
+	if f.Language != "" && c.Language != f.Language {
+		return false
+	}
+	if !f.After.IsZero() && c.Date <= f.After.Format(DateFormat) {
+		return false
+	}
+	if !f.Before.IsZero() && c.Date >= f.Before.Format(DateFormat) {
+		return false
+	}
+	length := utf8.RuneCountInString(c.Body)
+	if f.MinBodyLength > 0 && length < f.MinBodyLength {
+		return false
+	}
+	if f.MaxBodyLength > 0 && length > f.MaxBodyLength {
+		return false
+	}
+	if f.Tokenized != AnyTokenized && tokenized != (f.Tokenized == IsTokenized) {
+		return false
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(values []string, wanted []string) bool {
+	for _, w := range wanted {
+		if contains(values, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// where returns the conditions on original_content selecting the content
+// matched by f, joined by AND, appending their arguments to args.
+func (f ContentFilter) where(args []interface{}) (string, []interface{}) {
+	conditions := []string{}
+	placeholder := func(arg interface{}) string {
+		args = append(args, arg)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	add := func(condition string, arg interface{}) {
+		conditions = append(conditions, fmt.Sprintf(condition, placeholder(arg)))
+	}
+
+	if f.Source != "" {
+		add("id IN (SELECT contentId FROM content_to_sources WHERE source = %s)", f.Source)
+	}
+	if f.Tag != "" {
+		add("id IN (SELECT contentId FROM content_to_tags WHERE tag = %s)", f.Tag)
+	}
+	if len(f.AnyTags) > 0 {
+		placeholders := make([]string, len(f.AnyTags))
+		for i, tag := range f.AnyTags {
+			placeholders[i] = placeholder(tag)
+		}
+		conditions = append(conditions, fmt.Sprintf("id IN (SELECT contentId FROM content_to_tags WHERE tag IN (%s))", strings.Join(placeholders, ", ")))
+	}
+	for _, tag := range f.AllTags {
+		add("id IN (SELECT contentId FROM content_to_tags WHERE tag = %s)", tag)
+	}
+	if f.Author != "" {
+		add("author = %s", f.Author)
+	}
+	if f.Language != "" {
+		add("language = (SELECT id FROM languages WHERE name = %s)", f.Language)
+	}
+	if !f.After.IsZero() {
+		add("date > %s", f.After.Format(DateFormat))
+	}
+	if !f.Before.IsZero() {
+		add("date < %s", f.Before.Format(DateFormat))
+	}
+	if f.MinBodyLength > 0 {
+		add("length(body) >= %s", f.MinBodyLength)
+	}
+	if f.MaxBodyLength > 0 {
+		add("length(body) <= %s", f.MaxBodyLength)
+	}
+	if f.Tokenized != AnyTokenized {
+		add("tokenized = %s", f.Tokenized == IsTokenized)
+	}
+
+	if len(conditions) == 0 {
+		return "TRUE", args
+	}
+	return strings.Join(conditions, " AND "), args
+}
@@ -0,0 +1,704 @@
+// Package memory implements repository.Repository in memory, for tests
+// and short-lived tools that need no database. It behaves as the SQL
+// repository does: duplicate uris are refused, content is tokenized only
+// once, and lexica are identified by name and language.
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qwwqe/tcsuite/content"
+	"github.com/qwwqe/tcsuite/entities/corpus"
+	"github.com/qwwqe/tcsuite/repository"
+)
+
+// Repository is an in-memory repository.Repository. It is safe for
+// concurrent use.
+type Repository struct {
+	mu      sync.RWMutex
+	options repository.RepositoryOptions
+
+	contents []*storedContent // by id - 1
+	uris     map[string]int   // content ids by uri
+
+	words     map[wordKey]int // word ids
+	wordsById []corpus.Word   // by id - 1
+	tokens    map[int][]int   // word ids of each tokenized content, in order
+
+	lexica map[lexiconKey]*lexicon
+
+	requests map[uint64]bool
+	cookies  map[string]string // by host
+
+	states map[string][]byte
+	runs   []*repository.FetchRun
+
+	failures map[int]*repository.TokenizeFailure // by content id
+}
+
+type storedContent struct {
+	content.FetchedContent
+	tokenized bool
+}
+
+type wordKey struct {
+	word     string
+	language string
+}
+
+type lexiconKey struct {
+	name     string
+	language string
+}
+
+type lexicon struct {
+	lexemes     []string
+	frequencies []int
+	indices     map[string]int // of the lexemes
+}
+
+// New returns an empty repository. Of the options, only EnableCookies is
+// of use; there is no request history to restore.
+func New(options repository.RepositoryOptions) *Repository {
+	return &Repository{
+		options:  options,
+		uris:     map[string]int{},
+		words:    map[wordKey]int{},
+		tokens:   map[int][]int{},
+		lexica:   map[lexiconKey]*lexicon{},
+		requests: map[uint64]bool{},
+		cookies:  map[string]string{},
+		states:   map[string][]byte{},
+		failures: map[int]*repository.TokenizeFailure{},
+	}
+}
+
+// CONTENT
+
+// GetFetchedContent returns the content with the given id.
+func (r *Repository) GetFetchedContent(id int) (*content.FetchedContent, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if id < 1 || id > len(r.contents) {
+		return nil, sql.ErrNoRows
+	}
+	return r.contents[id-1].copy(), nil
+}
+
+// Deprecated: use IterateContent.
+func (r *Repository) GetFetchedContentByTag(tag string) ([]*content.FetchedContent, error) {
+	return r.matching(repository.ContentFilter{Tag: tag}), nil
+}
+
+// Deprecated: use IterateContent.
+func (r *Repository) GetUntokenizedContent() ([]*content.FetchedContent, error) {
+	return r.matching(repository.ContentFilter{Tokenized: repository.NotTokenized}), nil
+}
+
+// IterateContent calls fn with each content matched by filter, in order of
+// id. The content is selected beforehand, so fn may use the repository.
+func (r *Repository) IterateContent(ctx context.Context, filter repository.ContentFilter, fn func(*content.FetchedContent) error) error {
+	for _, c := range r.matching(filter) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CountContent returns the number of contents matched by filter.
+func (r *Repository) CountContent(ctx context.Context, filter repository.ContentFilter) (int, error) {
+	return len(r.matching(filter)), nil
+}
+
+// QueryContent returns the content matched by query, in the order and
+// within the limits it gives.
+func (r *Repository) QueryContent(ctx context.Context, query repository.ContentQuery) ([]*content.FetchedContent, error) {
+	return query.Apply(r.matching(query.ContentFilter))
+}
+
+// Search returns the tokenized content matched by filter and query, best
+// first, at most limit results if limit is positive.
+func (r *Repository) Search(ctx context.Context, query *repository.SearchQuery, filter repository.ContentFilter, limit int) ([]*repository.SearchResult, error) {
+	filter.Tokenized = repository.IsTokenized
+	matched := r.matching(filter)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ranker := repository.NewSearchRanker(query, len(matched))
+	for _, c := range matched {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		ranker.Add(c.Id, r.tokenStrings(c.Id))
+	}
+
+	results := ranker.Results(limit)
+	for _, result := range results {
+		result.Content = r.contents[result.Content.Id-1].copy()
+	}
+	return results, nil
+}
+
+// Concordance returns the occurrences of word in the content matched by
+// filter with window words of context on either side, in order of
+// position.
+func (r *Repository) Concordance(ctx context.Context, word string, window int, filter repository.ContentFilter) ([]*repository.ConcordanceLine, error) {
+	if window < 0 {
+		return nil, fmt.Errorf("negative concordance window %d", window)
+	}
+
+	filter.Tokenized = repository.IsTokenized
+	matched := r.matching(filter)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	lines := []*repository.ConcordanceLine{}
+	for _, c := range matched {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		lines = append(lines, repository.ConcordanceLines(c.Id, r.tokenStrings(c.Id), word, window)...)
+	}
+	return lines, nil
+}
+
+// IterateTokens calls fn with the tokens of each tokenized content matched
+// by filter, in order of id. The tokens are copied beforehand, so fn may
+// use the repository.
+func (r *Repository) IterateTokens(ctx context.Context, filter repository.ContentFilter, fn func(contentId int, tokens []*corpus.Word) error) error {
+	filter.Tokenized = repository.IsTokenized
+	matched := r.matching(filter)
+
+	r.mu.RLock()
+	tokens := make([][]*corpus.Word, len(matched))
+	for i, c := range matched {
+		for _, id := range r.tokens[c.Id] {
+			w := r.wordsById[id-1]
+			tokens[i] = append(tokens[i], &w)
+		}
+	}
+	r.mu.RUnlock()
+
+	for i, c := range matched {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(c.Id, tokens[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetTokens returns the tokens of the content with the given id, in order,
+// or repository.ErrNotTokenized if it has not been tokenized.
+func (r *Repository) GetTokens(ctx context.Context, contentId int) ([]*corpus.Word, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if contentId < 1 || contentId > len(r.contents) {
+		return nil, sql.ErrNoRows
+	}
+	if !r.contents[contentId-1].tokenized {
+		return nil, repository.ErrNotTokenized
+	}
+
+	tokens := make([]*corpus.Word, len(r.tokens[contentId]))
+	for i, id := range r.tokens[contentId] {
+		w := r.wordsById[id-1]
+		tokens[i] = &w
+	}
+	return tokens, nil
+}
+
+// GetTags returns the tags of the content with the number of contents of
+// each, by name.
+func (r *Repository) GetTags(ctx context.Context) ([]*repository.NameCount, error) {
+	return r.nameCounts(func(c *storedContent) []string { return c.Tags }), nil
+}
+
+// GetSources returns the sources of the content with the number of
+// contents of each, by name.
+func (r *Repository) GetSources(ctx context.Context) ([]*repository.NameCount, error) {
+	return r.nameCounts(func(c *storedContent) []string { return []string{c.CanonName} }), nil
+}
+
+func (r *Repository) nameCounts(names func(c *storedContent) []string) []*repository.NameCount {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	counts := map[string]int{}
+	for _, c := range r.contents {
+		for _, name := range names(c) {
+			counts[name]++
+		}
+	}
+
+	result := []*repository.NameCount{}
+	for name, count := range counts {
+		result = append(result, &repository.NameCount{Name: name, Count: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// tokenStrings returns the tokens of the content with the given id, in
+// order. r.mu must be held.
+func (r *Repository) tokenStrings(contentId int) []string {
+	tokens := make([]string, len(r.tokens[contentId]))
+	for i, id := range r.tokens[contentId] {
+		tokens[i] = r.wordsById[id-1].Word
+	}
+	return tokens
+}
+
+func (r *Repository) matching(filter repository.ContentFilter) []*content.FetchedContent {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	contents := []*content.FetchedContent{}
+	for _, c := range r.contents {
+		if filter.Matches(&c.FetchedContent, c.tokenized) {
+			contents = append(contents, c.copy())
+		}
+	}
+	return contents
+}
+
+// SaveContent saves c, returning the id of the new content. If content
+// with the same uri has been saved already, a
+// *repository.DuplicateContentError is returned.
+func (r *Repository) SaveContent(c *content.FetchedContent) (int, error) {
+	if c.Language == "" {
+		return 0, &repository.SaveContentError{Uri: c.Uri, Err: errors.New("no language present on FetchedContent")}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.uris[c.Uri]; ok {
+		return 0, &repository.DuplicateContentError{Uri: c.Uri}
+	}
+
+	stored := &storedContent{FetchedContent: *c}
+	stored.Id = len(r.contents) + 1
+
+	// Tags are saved once each and, as by the SQL repository, returned in
+	// lexical order
+	stored.Tags = []string{}
+	seen := map[string]bool{}
+	for _, tag := range c.Tags {
+		if !seen[tag] {
+			seen[tag] = true
+			stored.Tags = append(stored.Tags, tag)
+		}
+	}
+	sort.Strings(stored.Tags)
+
+	r.contents = append(r.contents, stored)
+	r.uris[c.Uri] = stored.Id
+
+	return stored.Id, nil
+}
+
+// ContentExists reports whether content with the given uri has been saved.
+func (r *Repository) ContentExists(uri string) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, ok := r.uris[uri]
+	return ok, nil
+}
+
+func (c *storedContent) copy() *content.FetchedContent {
+	fc := c.FetchedContent
+	fc.Tags = append([]string{}, c.Tags...)
+	return &fc
+}
+
+// TOKENS
+
+// RegisterTokens records the tokens of the content with the given id,
+// unless it has been tokenized already.
+func (r *Repository) RegisterTokens(contentId int, tokens []*corpus.Word) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if contentId < 1 || contentId > len(r.contents) {
+		return sql.ErrNoRows
+	}
+	r.registerTokens(contentId, tokens)
+	return nil
+}
+
+// RegisterTokenBatch records the tokens of several contents, skipping
+// those tokenized already, and clears their failures. Nothing is recorded
+// if any of the contents is missing.
+func (r *Repository) RegisterTokenBatch(batch []*repository.TokenizedContent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, c := range batch {
+		if c.ContentId < 1 || c.ContentId > len(r.contents) {
+			return fmt.Errorf("content %d: %w", c.ContentId, sql.ErrNoRows)
+		}
+	}
+	for _, c := range batch {
+		r.registerTokens(c.ContentId, c.Tokens)
+	}
+	return nil
+}
+
+// registerTokens records the tokens of an existing content. r.mu must be
+// held.
+func (r *Repository) registerTokens(contentId int, tokens []*corpus.Word) {
+	c := r.contents[contentId-1]
+	if c.tokenized {
+		return
+	}
+
+	wordIds := make([]int, 0, len(tokens))
+	for _, token := range tokens {
+		key := wordKey{word: token.Word, language: c.Language}
+		id, ok := r.words[key]
+		if !ok {
+			id = len(r.words) + 1
+			r.words[key] = id
+			r.wordsById = append(r.wordsById, corpus.Word{Word: token.Word, Lexical: token.Lexical})
+		}
+		wordIds = append(wordIds, id)
+	}
+
+	r.tokens[contentId] = wordIds
+	c.tokenized = true
+	delete(r.failures, contentId)
+}
+
+// RecordTokenizeFailure records that the content with the given id could
+// not be tokenized, counting the attempts.
+func (r *Repository) RecordTokenizeFailure(contentId int, reason string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if contentId < 1 || contentId > len(r.contents) {
+		return sql.ErrNoRows
+	}
+
+	f, ok := r.failures[contentId]
+	if !ok {
+		f = &repository.TokenizeFailure{ContentId: contentId}
+		r.failures[contentId] = f
+	}
+	f.Error = reason
+	f.Attempts++
+	f.Failed = time.Now().UTC()
+	return nil
+}
+
+// GetTokenizeFailures returns the contents that could not be tokenized, in
+// order of id.
+func (r *Repository) GetTokenizeFailures(ctx context.Context) ([]*repository.TokenizeFailure, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	failures := []*repository.TokenizeFailure{}
+	for _, f := range r.failures {
+		c := *f
+		failures = append(failures, &c)
+	}
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].ContentId < failures[j].ContentId
+	})
+	return failures, nil
+}
+
+// LEXICON
+
+// AddLexeme adds an individual lexeme to the named lexicon. Duplicate
+// lexemes are ignored.
+func (r *Repository) AddLexeme(name string, language string, lexeme string, frequency int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	l := r.addOrRetrieveLexicon(name, language)
+	if _, ok := l.indices[lexeme]; !ok {
+		l.add(lexeme, frequency)
+	}
+
+	return nil
+}
+
+// AddLexemes adds lexemes by bulk to the named lexicon. As with the SQL
+// repository, this fails on duplicate entries, adding none of them.
+func (r *Repository) AddLexemes(name string, language string, lexemes []string, frequencies []int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	l := r.addOrRetrieveLexicon(name, language)
+
+	batch := map[string]bool{}
+	for i, lexeme := range lexemes {
+		if i >= len(frequencies) {
+			break
+		}
+		if _, ok := l.indices[lexeme]; ok || batch[lexeme] {
+			return fmt.Errorf("duplicate lexeme %q in lexicon %s", lexeme, name)
+		}
+		batch[lexeme] = true
+	}
+
+	for i, lexeme := range lexemes {
+		if i >= len(frequencies) {
+			break
+		}
+		l.add(lexeme, frequencies[i])
+	}
+
+	return nil
+}
+
+// GetLexemes returns the lexemes of the named lexicon along with their
+// frequencies, in the order they were added.
+func (r *Repository) GetLexemes(name string, language string) ([]string, []int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	l, ok := r.lexica[lexiconKey{name: name, language: language}]
+	if !ok {
+		return []string{}, []int{}, sql.ErrNoRows
+	}
+
+	lexemes := append([]string{}, l.lexemes...)
+	frequencies := append([]int{}, l.frequencies...)
+	return lexemes, frequencies, nil
+}
+
+// GetLexeme returns the frequency of lexeme in the named lexicon, or
+// sql.ErrNoRows if either is missing.
+func (r *Repository) GetLexeme(ctx context.Context, name string, language string, lexeme string) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	l, ok := r.lexica[lexiconKey{name: name, language: language}]
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	i, ok := l.indices[lexeme]
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	return l.frequencies[i], nil
+}
+
+// FindLexemes returns up to limit lexemes of the named lexicon beginning
+// with prefix, if limit is positive, skipping the first offset: the lexeme
+// equal to prefix first, then by frequency and in the order they were
+// added.
+func (r *Repository) FindLexemes(ctx context.Context, name string, language string, prefix string, limit int, offset int) ([]string, []int, error) {
+	if limit < 0 || offset < 0 {
+		return []string{}, []int{}, errors.New("negative lexeme limit or offset")
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	l, ok := r.lexica[lexiconKey{name: name, language: language}]
+	if !ok {
+		return []string{}, []int{}, sql.ErrNoRows
+	}
+
+	found := []int{} // indices of the lexemes
+	for i, lexeme := range l.lexemes {
+		if strings.HasPrefix(lexeme, prefix) {
+			found = append(found, i)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		a, b := found[i], found[j]
+		if (l.lexemes[a] == prefix) != (l.lexemes[b] == prefix) {
+			return l.lexemes[a] == prefix
+		}
+		return l.frequencies[a] > l.frequencies[b]
+	})
+	if offset >= len(found) {
+		return []string{}, []int{}, nil
+	}
+	found = found[offset:]
+	if limit > 0 && limit < len(found) {
+		found = found[:limit]
+	}
+
+	lexemes := make([]string, len(found))
+	frequencies := make([]int, len(found))
+	for i, index := range found {
+		lexemes[i] = l.lexemes[index]
+		frequencies[i] = l.frequencies[index]
+	}
+	return lexemes, frequencies, nil
+}
+
+func (r *Repository) addOrRetrieveLexicon(name string, language string) *lexicon {
+	key := lexiconKey{name: name, language: language}
+	l, ok := r.lexica[key]
+	if !ok {
+		l = &lexicon{indices: map[string]int{}}
+		r.lexica[key] = l
+	}
+	return l
+}
+
+func (l *lexicon) add(lexeme string, frequency int) {
+	l.lexemes = append(l.lexemes, lexeme)
+	l.frequencies = append(l.frequencies, frequency)
+	l.indices[lexeme] = len(l.lexemes) - 1
+}
+
+// FETCH STATE
+
+// SaveFetchState saves the crawl state of the named fetcher,
+// replacing any state saved previously.
+func (r *Repository) SaveFetchState(fetcher string, state []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.states[fetcher] = append([]byte{}, state...)
+	return nil
+}
+
+// GetFetchState retrieves the crawl state last saved by the named fetcher.
+// If there is none, nil is returned.
+func (r *Repository) GetFetchState(fetcher string) ([]byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	state, ok := r.states[fetcher]
+	if !ok {
+		return nil, nil
+	}
+	return append([]byte{}, state...), nil
+}
+
+// FETCH STATISTICS
+
+// SaveFetchRun records the statistics of a fetch, setting run.Id.
+func (r *Repository) SaveFetchRun(run *repository.FetchRun) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	run.Id = len(r.runs) + 1
+	r.runs = append(r.runs, copyRun(run))
+	return nil
+}
+
+// GetFetchRuns retrieves the statistics of the fetches started since
+// the given time, oldest first.
+func (r *Repository) GetFetchRuns(since time.Time) ([]*repository.FetchRun, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	runs := []*repository.FetchRun{}
+	for _, run := range r.runs {
+		if !run.Started.Before(since) {
+			runs = append(runs, copyRun(run))
+		}
+	}
+
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].Started.Before(runs[j].Started)
+	})
+
+	return runs, nil
+}
+
+func copyRun(run *repository.FetchRun) *repository.FetchRun {
+	c := *run
+	c.Failures = map[string]int{}
+	for field, n := range run.Failures {
+		c.Failures[field] = n
+	}
+	return &c
+}
+
+// COLLY STORAGE
+
+func (r *Repository) Init() error {
+	return nil
+}
+
+func (r *Repository) Visited(requestId uint64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.requests[requestId] = true
+	return nil
+}
+
+func (r *Repository) IsVisited(requestId uint64) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.requests[requestId], nil
+}
+
+// ClearRequestHistory forgets the requests and cookies recorded by colly.
+func (r *Repository) ClearRequestHistory() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.requests = map[uint64]bool{}
+	r.cookies = map[string]string{}
+	return nil
+}
+
+// Cookies returns the cookies saved for the host of u, if cookies are
+// enabled.
+func (r *Repository) Cookies(u *url.URL) string {
+	if !r.options.EnableCookies {
+		return ""
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cookies[u.Hostname()]
+}
+
+// SetCookies saves the cookies of the host of u, if cookies are enabled.
+// As with the SQL repository, the cookies first saved for a host are kept.
+func (r *Repository) SetCookies(u *url.URL, cookies string) {
+	if !r.options.EnableCookies {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.cookies[u.Hostname()]; !ok {
+		r.cookies[u.Hostname()] = cookies
+	}
+}
+
+// Close does nothing; the contents of the repository are kept.
+func (r *Repository) Close() error {
+	return nil
+}
+
+var _ repository.Repository = (*Repository)(nil)
@@ -0,0 +1,271 @@
+package repository
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/qwwqe/tcsuite/logging"
+)
+
+// The schema of the database is defined by the migrations in the
+// directory of each dialect. Each migration is a pair of files,
+// <version>_<name>.up.sql and <version>_<name>.down.sql, applied in order
+// of version. A change to the schema needs a migration for every dialect.
+//
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a versioned change to the schema of the database.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string // SQL applying the migration
+	Down    string // SQL reverting it
+}
+
+// MigrationStatus tells whether a migration has been applied.
+type MigrationStatus struct {
+	*Migration
+	Applied time.Time // zero if the migration is pending
+}
+
+// Migrator applies and reverts the migrations of the database. Each
+// migration runs in a transaction of its own, together with its record in
+// the schema_migrations table, so that a failed migration leaves the
+// database as it was before.
+type Migrator struct {
+	db         *sql.DB
+	dialect    *dialect
+	migrations []*Migration
+	logger     *slog.Logger
+}
+
+// NewMigrator connects to the database given by options, as NewRepository
+// does, without requiring its schema to be up to date.
+func NewMigrator(options RepositoryOptions) (*Migrator, error) {
+	db, d, err := openDatabase(options)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := newMigrator(db, d, options.Logger)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func newMigrator(db *sql.DB, d *dialect, logger *slog.Logger) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationFiles, d.migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		db:         db,
+		dialect:    d,
+		migrations: migrations,
+		logger:     logging.Component(logger, "repository"),
+	}, nil
+}
+
+// Close closes the connections to the database.
+func (m *Migrator) Close() error {
+	return m.db.Close()
+}
+
+// Status returns every known migration in order of version, along with
+// the time it was applied.
+func (m *Migrator) Status() ([]*MigrationStatus, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []*MigrationStatus{}
+	for _, migration := range m.migrations {
+		statuses = append(statuses, &MigrationStatus{
+			Migration: migration,
+			Applied:   applied[migration.Version],
+		})
+	}
+
+	return statuses, nil
+}
+
+// Pending returns the migrations yet to be applied, in order of version.
+func (m *Migrator) Pending() ([]*Migration, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	pending := []*Migration{}
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+
+	return pending, nil
+}
+
+// Migrate applies the pending migrations in order, stopping at the first
+// that fails. It returns the number of migrations applied.
+func (m *Migrator) Migrate() (int, error) {
+	pending, err := m.Pending()
+	if err != nil {
+		return 0, err
+	}
+
+	for i, migration := range pending {
+		err := m.run(migration, migration.Up,
+			"INSERT INTO schema_migrations (version, name, applied) VALUES ($1, $2, $3)", migration.Version, migration.Name, time.Now().UTC())
+		if err != nil {
+			return i, err
+		}
+		m.logger.Info("migration applied", "version", migration.Version, "name", migration.Name)
+	}
+
+	return len(pending), nil
+}
+
+// Rollback reverts the latest steps applied migrations, latest first. It
+// returns the number of migrations reverted.
+func (m *Migrator) Rollback(steps int) (int, error) {
+	statuses, err := m.Status()
+	if err != nil {
+		return 0, err
+	}
+
+	applied := []*Migration{}
+	for _, status := range statuses {
+		if !status.Applied.IsZero() {
+			applied = append(applied, status.Migration)
+		}
+	}
+
+	reverted := 0
+	for i := len(applied) - 1; i >= 0 && reverted < steps; i-- {
+		migration := applied[i]
+		err := m.run(migration, migration.Down,
+			"DELETE FROM schema_migrations WHERE version = $1", migration.Version)
+		if err != nil {
+			return reverted, err
+		}
+		m.logger.Info("migration reverted", "version", migration.Version, "name", migration.Name)
+		reverted++
+	}
+
+	return reverted, nil
+}
+
+// run executes the SQL of a migration and the statement recording it in a
+// single transaction.
+func (m *Migrator) run(migration *Migration, script string, record string, args ...interface{}) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(script); err == nil {
+		_, err = tx.Exec(record, args...)
+	}
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migration %04d_%s: %v", migration.Version, migration.Name, err)
+	}
+
+	return tx.Commit()
+}
+
+// applied returns the time at which each applied migration was applied,
+// by version.
+func (m *Migrator) applied() (map[int]time.Time, error) {
+	_, err := m.db.Exec(m.dialect.schemaMigrations)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := m.db.Query("SELECT version, applied FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+
+	return applied, rows.Err()
+}
+
+// loadMigrations reads the migrations in directory dir of fsys, checking
+// that each has both an up and a down file and that no two share a
+// version.
+func loadMigrations(fsys fs.FS, dir string) ([]*Migration, error) {
+	files, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, file := range files {
+		match := migrationFileName.FindStringSubmatch(file.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migrations: malformed file name %s", file.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migrations: version %d used by both %s and %s", version, migration.Name, match[2])
+		}
+
+		data, err := fs.ReadFile(fsys, path.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(data)
+		} else {
+			migration.Down = string(data)
+		}
+	}
+
+	migrations := []*Migration{}
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migrations: %04d_%s lacks an up or down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	// Every dialect has the same migrations
+	var names []string
+	for driver, d := range dialects {
+		migrations, err := loadMigrations(migrationFiles, d.migrations)
+		if err != nil {
+			t.Fatalf("%s: %v", driver, err)
+		}
+		if len(migrations) == 0 {
+			t.Fatalf("%s: loadMigrations(embedded) = [], nil", driver)
+		}
+
+		driverNames := []string{}
+		for i, migration := range migrations {
+			if migration.Version != i+1 {
+				t.Errorf("%s: embedded migration %d has version %d; want %d", driver, i, migration.Version, i+1)
+			}
+			driverNames = append(driverNames, migration.Name)
+		}
+		if names == nil {
+			names = driverNames
+		} else if !reflect.DeepEqual(driverNames, names) {
+			t.Errorf("%s: migrations %v differ from %v", driver, driverNames, names)
+		}
+	}
+
+	files := fstest.MapFS{
+		"migrations/0002_second.up.sql":   {Data: []byte("CREATE TABLE b (id INTEGER);")},
+		"migrations/0002_second.down.sql": {Data: []byte("DROP TABLE b;")},
+		"migrations/0001_first.up.sql":    {Data: []byte("CREATE TABLE a (id INTEGER);")},
+		"migrations/0001_first.down.sql":  {Data: []byte("DROP TABLE a;")},
+	}
+	migrations, err := loadMigrations(files, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 || migrations[0].Name != "first" || migrations[1].Name != "second" ||
+		migrations[1].Down != "DROP TABLE b;" {
+		t.Errorf("loadMigrations() = %+v", migrations)
+	}
+
+	tests := []struct {
+		name  string
+		files fstest.MapFS
+	}{
+		{"no down", fstest.MapFS{
+			"migrations/0001_first.up.sql": {Data: []byte("CREATE TABLE a (id INTEGER);")},
+		}},
+		{"shared version", fstest.MapFS{
+			"migrations/0001_first.up.sql":   {Data: []byte("CREATE TABLE a (id INTEGER);")},
+			"migrations/0001_other.down.sql": {Data: []byte("DROP TABLE a;")},
+		}},
+		{"malformed name", fstest.MapFS{
+			"migrations/first.sql": {Data: []byte("CREATE TABLE a (id INTEGER);")},
+		}},
+	}
+
+	for _, test := range tests {
+		if _, err := loadMigrations(test.files, "migrations"); err == nil {
+			t.Errorf("%s: loadMigrations() = _, nil; want error", test.name)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+DROP TABLE cookie_history;
+DROP TABLE request_history;
+
+DROP TABLE lexicon_words;
+DROP TABLE lexica;
+
+DROP MATERIALIZED VIEW token_strings;
+DROP TABLE tokenized_content;
+DROP TABLE words;
+
+DROP TABLE content_to_tags;
+DROP TABLE content_to_sources;
+DROP TABLE content_tags;
+DROP TABLE sources;
+DROP TABLE original_content;
+DROP TABLE languages;
@@ -0,0 +1,3 @@
+-- Crawl state is kept regardless of RestoreRequestHistory, but is only
+-- of use for resuming a crawl if the request history is kept as well
+CREATE TABLE IF NOT EXISTS fetch_state (fetcher VARCHAR PRIMARY KEY, state TEXT NOT NULL, updated TIMESTAMP NOT NULL DEFAULT now());
@@ -0,0 +1,4 @@
+-- FETCH STATISTICS
+CREATE TABLE IF NOT EXISTS fetch_runs (id SERIAL PRIMARY KEY, fetcher VARCHAR NOT NULL, started TIMESTAMPTZ NOT NULL, finished TIMESTAMPTZ NOT NULL, pages_visited INTEGER NOT NULL, articles INTEGER NOT NULL, failures TEXT NOT NULL, saved INTEGER NOT NULL, duplicates INTEGER NOT NULL);
+CREATE INDEX IF NOT EXISTS fetch_runs_started_idx ON fetch_runs(started);
+ALTER TABLE fetch_runs ADD COLUMN IF NOT EXISTS save_failures INTEGER NOT NULL DEFAULT 0;
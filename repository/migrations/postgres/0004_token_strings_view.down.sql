@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS token_word_idx;
+DROP VIEW IF EXISTS token_strings;
+CREATE MATERIALIZED VIEW IF NOT EXISTS token_strings AS
+	SELECT tokenized_content.content, tokenized_content.id AS token_id, tokenized_content.position, words.id AS word_id, words.word, words.lexical
+	FROM tokenized_content LEFT JOIN words ON tokenized_content.word = words.id;
+CREATE INDEX IF NOT EXISTS token_strings_content_idx ON token_strings (content);
+CREATE INDEX IF NOT EXISTS token_strings_position_idx ON token_strings (position);
+CREATE INDEX IF NOT EXISTS token_strings_word_id_idx ON token_strings (word_id);
@@ -0,0 +1,7 @@
+-- token_strings was materialized but never refreshed, so it went stale as
+-- content was tokenized
+DROP MATERIALIZED VIEW IF EXISTS token_strings;
+CREATE OR REPLACE VIEW token_strings AS
+	SELECT tokenized_content.content, tokenized_content.id AS token_id, tokenized_content.position, words.id AS word_id, words.word, words.lexical
+	FROM tokenized_content LEFT JOIN words ON tokenized_content.word = words.id;
+CREATE INDEX IF NOT EXISTS token_word_idx ON tokenized_content(word);
@@ -0,0 +1,2 @@
+-- LEXICON WORD PREFIXES
+CREATE INDEX IF NOT EXISTS lexicon_words_prefix ON lexicon_words (lexicon, word varchar_pattern_ops);
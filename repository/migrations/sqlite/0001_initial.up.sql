@@ -0,0 +1,27 @@
+-- CONTENT
+CREATE TABLE IF NOT EXISTS languages (id INTEGER PRIMARY KEY, name VARCHAR UNIQUE NOT NULL);
+CREATE TABLE IF NOT EXISTS original_content (id INTEGER PRIMARY KEY, title VARCHAR NOT NULL, date TIMESTAMP, author VARCHAR, abstract VARCHAR, body TEXT NOT NULL, uri VARCHAR UNIQUE NOT NULL, language INTEGER REFERENCES languages(id), tokenized BOOLEAN DEFAULT FALSE);
+CREATE TABLE IF NOT EXISTS sources (name VARCHAR UNIQUE NOT NULL, uri VARCHAR);
+CREATE TABLE IF NOT EXISTS content_tags (name VARCHAR UNIQUE NOT NULL);
+CREATE TABLE IF NOT EXISTS content_to_sources (contentId INTEGER REFERENCES original_content(id), source VARCHAR REFERENCES sources(name), unique(contentId, source));
+CREATE TABLE IF NOT EXISTS content_to_tags (contentId INTEGER REFERENCES original_content(id), tag VARCHAR REFERENCES content_tags(name), unique(contentId, tag));
+
+-- WORDS
+CREATE TABLE IF NOT EXISTS words (id INTEGER PRIMARY KEY, word VARCHAR NOT NULL, lexical BOOLEAN DEFAULT TRUE, language INTEGER REFERENCES languages(id), constraint unique_word_lang_pair unique (word, language));
+CREATE TABLE IF NOT EXISTS tokenized_content (id INTEGER PRIMARY KEY, position INTEGER NOT NULL, word INTEGER REFERENCES words(id), content INTEGER REFERENCES original_content(id));
+CREATE INDEX IF NOT EXISTS token_content_idx ON tokenized_content(content);
+
+-- SQLite has no materialized views
+CREATE VIEW IF NOT EXISTS token_strings AS
+	SELECT tokenized_content.content, tokenized_content.id AS token_id, tokenized_content.position, words.id AS word_id, words.word, words.lexical
+	FROM tokenized_content LEFT JOIN words ON tokenized_content.word = words.id;
+
+-- LEXICA
+CREATE TABLE IF NOT EXISTS lexica (id INTEGER PRIMARY KEY, name VARCHAR UNIQUE NOT NULL, language INTEGER REFERENCES languages(id));
+CREATE TABLE IF NOT EXISTS lexicon_words (id INTEGER PRIMARY KEY, word VARCHAR NOT NULL, frequency INTEGER NOT NULL DEFAULT 0, lexicon INTEGER REFERENCES lexica(id), unique(word, lexicon));
+
+-- COLLY BOOKKEEPING
+CREATE TABLE IF NOT EXISTS request_history (requestId VARCHAR);
+CREATE UNIQUE INDEX IF NOT EXISTS requestId_idx ON request_history(requestId);
+CREATE TABLE IF NOT EXISTS cookie_history (host VARCHAR, cookies VARCHAR);
+CREATE UNIQUE INDEX IF NOT EXISTS host_idx ON cookie_history(host);
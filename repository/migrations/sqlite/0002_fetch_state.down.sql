@@ -0,0 +1 @@
+DROP TABLE fetch_state;
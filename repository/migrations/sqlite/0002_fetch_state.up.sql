@@ -0,0 +1 @@
+CREATE TABLE IF NOT EXISTS fetch_state (fetcher VARCHAR PRIMARY KEY, state TEXT NOT NULL, updated TIMESTAMP NOT NULL);
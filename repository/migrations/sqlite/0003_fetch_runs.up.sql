@@ -0,0 +1,3 @@
+-- FETCH STATISTICS
+CREATE TABLE IF NOT EXISTS fetch_runs (id INTEGER PRIMARY KEY, fetcher VARCHAR NOT NULL, started TIMESTAMP NOT NULL, finished TIMESTAMP NOT NULL, pages_visited INTEGER NOT NULL, articles INTEGER NOT NULL, failures TEXT NOT NULL, saved INTEGER NOT NULL, duplicates INTEGER NOT NULL, save_failures INTEGER NOT NULL DEFAULT 0);
+CREATE INDEX IF NOT EXISTS fetch_runs_started_idx ON fetch_runs(started);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS token_word_idx;
@@ -0,0 +1,2 @@
+-- token_strings is a plain view already; only the index is missing
+CREATE INDEX IF NOT EXISTS token_word_idx ON tokenized_content(word);
@@ -0,0 +1 @@
+DROP TABLE tokenize_failures;
@@ -0,0 +1,2 @@
+-- TOKENIZATION FAILURES
+CREATE TABLE IF NOT EXISTS tokenize_failures (content INTEGER PRIMARY KEY REFERENCES original_content(id) ON DELETE CASCADE, error TEXT NOT NULL, attempts INTEGER NOT NULL, failed TIMESTAMP NOT NULL);
@@ -0,0 +1 @@
+DROP INDEX lexicon_words_prefix;
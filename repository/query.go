@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/qwwqe/tcsuite/content"
+)
+
+// ContentOrder is the order in which QueryContent returns content.
+type ContentOrder string
+
+const (
+	OrderById         ContentOrder = "id" // the default
+	OrderByDate       ContentOrder = "date"
+	OrderByBodyLength ContentOrder = "length"
+)
+
+// ContentQuery selects content by its metadata, as ContentFilter, and
+// orders and limits the result. Ties in the order are broken by id.
+type ContentQuery struct {
+	ContentFilter
+
+	OrderBy    ContentOrder
+	Descending bool
+
+	Limit  int // maximum number of contents returned; 0 is unlimited
+	Offset int // number of matched contents skipped
+}
+
+// orderBy returns the ORDER BY clause of q.
+func (q ContentQuery) orderBy() (string, error) {
+	var columns []string
+	switch q.OrderBy {
+	case "", OrderById:
+		columns = []string{"id"}
+	case OrderByDate:
+		columns = []string{"date", "id"}
+	case OrderByBodyLength:
+		columns = []string{"length(body)", "id"}
+	default:
+		return "", fmt.Errorf("unknown content order %q", q.OrderBy)
+	}
+
+	clause := ""
+	for i, column := range columns {
+		if i > 0 {
+			clause += ", "
+		}
+		clause += column
+		if q.Descending {
+			clause += " DESC"
+		}
+	}
+	return clause, nil
+}
+
+func (q ContentQuery) validate() error {
+	if q.Limit < 0 || q.Offset < 0 {
+		return errors.New("negative content query limit or offset")
+	}
+	_, err := q.orderBy()
+	return err
+}
+
+// Apply orders contents, already selected by q.ContentFilter, and applies
+// the limit and offset of q, for implementations of Repository that do
+// not query SQL.
+func (q ContentQuery) Apply(contents []*content.FetchedContent) ([]*content.FetchedContent, error) {
+	if err := q.validate(); err != nil {
+		return nil, err
+	}
+
+	less := func(a, b *content.FetchedContent) bool {
+		switch q.OrderBy {
+		case OrderByDate:
+			if a.Date != b.Date {
+				return a.Date < b.Date
+			}
+		case OrderByBodyLength:
+			la, lb := utf8.RuneCountInString(a.Body), utf8.RuneCountInString(b.Body)
+			if la != lb {
+				return la < lb
+			}
+		}
+		return a.Id < b.Id
+	}
+
+	sorted := append([]*content.FetchedContent{}, contents...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if q.Descending {
+			return less(sorted[j], sorted[i])
+		}
+		return less(sorted[i], sorted[j])
+	})
+
+	if q.Offset >= len(sorted) {
+		return []*content.FetchedContent{}, nil
+	}
+	sorted = sorted[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(sorted) {
+		sorted = sorted[:q.Limit]
+	}
+	return sorted, nil
+}
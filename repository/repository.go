@@ -1,297 +1,901 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
-	//"errors"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
-	pq "github.com/lib/pq"
 	"github.com/qwwqe/colly/storage"
 	"github.com/qwwqe/tcsuite/content"
 	"github.com/qwwqe/tcsuite/entities/corpus"
+	"github.com/qwwqe/tcsuite/logging"
 	//"github.com/qwwqe/tcsuite/lexicon"
 )
 
 type Repository interface {
 	GetFetchedContent(id int) (*content.FetchedContent, error)
+	// Deprecated: use IterateContent, which does not hold all content in memory.
 	GetFetchedContentByTag(tag string) ([]*content.FetchedContent, error)
+	// Deprecated: use IterateContent, which does not hold all content in memory.
 	GetUntokenizedContent() ([]*content.FetchedContent, error)
-	SaveContent(c *content.FetchedContent)
+	// IterateContent calls fn with each content matched by filter, in order
+	// of id, stopping at the first error returned by fn or when ctx is done.
+	// fn may use the repository.
+	IterateContent(ctx context.Context, filter ContentFilter, fn func(*content.FetchedContent) error) error
+	CountContent(ctx context.Context, filter ContentFilter) (int, error)
+	// QueryContent returns the content matched by query, in the order and
+	// within the limits it gives.
+	QueryContent(ctx context.Context, query ContentQuery) ([]*content.FetchedContent, error)
+	// Search returns the tokenized content matched by filter and query,
+	// best first, at most limit results if limit is positive.
+	Search(ctx context.Context, query *SearchQuery, filter ContentFilter, limit int) ([]*SearchResult, error)
+	// Concordance returns the occurrences of word in the content matched by
+	// filter with window words of context on either side, in order of
+	// position.
+	Concordance(ctx context.Context, word string, window int, filter ContentFilter) ([]*ConcordanceLine, error)
+	// IterateTokens calls fn with the tokens of each tokenized content
+	// matched by filter, in order of id, stopping at the first error
+	// returned by fn or when ctx is done.
+	IterateTokens(ctx context.Context, filter ContentFilter, fn func(contentId int, tokens []*corpus.Word) error) error
+	// GetTokens returns the tokens of the content with the given id, in
+	// order, or ErrNotTokenized if it has not been tokenized.
+	GetTokens(ctx context.Context, contentId int) ([]*corpus.Word, error)
+	// GetTags and GetSources return the tags and sources of the content
+	// with the number of contents of each, by name.
+	GetTags(ctx context.Context) ([]*NameCount, error)
+	GetSources(ctx context.Context) ([]*NameCount, error)
+	SaveContent(c *content.FetchedContent) (int, error)
+	ContentExists(uri string) (bool, error)
 
 	RegisterTokens(contentId int, tokens []*corpus.Word) error
+	// RegisterTokenBatch records the tokens of several contents at once,
+	// as RegisterTokens does each of them.
+	RegisterTokenBatch(batch []*TokenizedContent) error
+	// RecordTokenizeFailure records that the content with the given id
+	// could not be tokenized, until it is.
+	RecordTokenizeFailure(contentId int, reason string) error
+	GetTokenizeFailures(ctx context.Context) ([]*TokenizeFailure, error)
 
 	AddLexeme(name string, language string, lexeme string, frequency int) error
 	AddLexemes(name string, language string, lexemes []string, frequencies []int) error
 	GetLexemes(name string, language string) (lexemes []string, frequences []int, err error)
+	// GetLexeme returns the frequency of lexeme in the named lexicon, or
+	// sql.ErrNoRows if either is missing.
+	GetLexeme(ctx context.Context, name string, language string, lexeme string) (frequency int, err error)
+	// FindLexemes returns up to limit lexemes of the named lexicon
+	// beginning with prefix, if limit is positive, skipping the first
+	// offset: the lexeme equal to prefix first, then by frequency.
+	FindLexemes(ctx context.Context, name string, language string, prefix string, limit int, offset int) (lexemes []string, frequencies []int, err error)
+
+	SaveFetchState(fetcher string, state []byte) error
+	GetFetchState(fetcher string) ([]byte, error)
+
+	SaveFetchRun(run *FetchRun) error
+	GetFetchRuns(since time.Time) ([]*FetchRun, error)
 
 	CollyStorage
+	// ClearRequestHistory forgets the requests and cookies recorded by
+	// colly, so that a new crawl visits every page again.
+	ClearRequestHistory() error
+
+	Close() error
 }
 
 type CollyStorage storage.Storage
 
+// DuplicateContentError is returned by SaveContent when content with the
+// same uri has been saved already.
+type DuplicateContentError struct {
+	Uri string
+}
+
+func (e *DuplicateContentError) Error() string {
+	return fmt.Sprintf("content already saved: %s", e.Uri)
+}
+
+// SaveContentError is returned by SaveContent when content could not be
+// saved. Nothing of the content is saved.
+type SaveContentError struct {
+	Uri string
+	Err error
+}
+
+func (e *SaveContentError) Error() string {
+	return fmt.Sprintf("saving content %s: %v", e.Uri, e.Err)
+}
+
+func (e *SaveContentError) Unwrap() error {
+	return e.Err
+}
+
+// TokenizedContent holds the tokens of a content, to be registered.
+type TokenizedContent struct {
+	ContentId int
+	Tokens    []*corpus.Word
+}
+
+// TokenizeFailure records the latest failure to tokenize a content.
+type TokenizeFailure struct {
+	ContentId int
+	Error     string
+	Attempts  int
+	Failed    time.Time
+}
+
+// ErrNotTokenized is returned by GetTokens for content that has not been
+// tokenized.
+var ErrNotTokenized = errors.New("content not tokenized")
+
+// NameCount is a tag or source along with the number of its contents.
+type NameCount struct {
+	Name  string
+	Count int
+}
+
+// FetchRun holds the statistics of a single call to a fetcher's Fetch.
+type FetchRun struct {
+	Id       int
+	Fetcher  string
+	Started  time.Time
+	Finished time.Time
+
+	PagesVisited int
+	Articles     int            // article pages within the time bounds of the fetch
+	Failures     map[string]int // extraction failures by field
+	Saved        int
+	Duplicates   int // articles skipped as already saved
+	SaveFailures int // articles that could not be saved
+}
+
+// FailureCount returns the total number of extraction failures.
+func (r *FetchRun) FailureCount() int {
+	total := 0
+	for _, n := range r.Failures {
+		total += n
+	}
+	return total
+}
+
 type RepositoryOptions struct {
-	RestoreRequestHistory bool
-	EnableCookies         bool
+	Driver     string // Postgres (the default) or SQLite
+	Dsn        string // connection string of the database; see NewRepository
+	ConfigFile string // configuration file, read if Dsn is not set
+
+	EnableCookies bool
+	AutoMigrate   bool         // apply pending migrations instead of failing
+	Logger        *slog.Logger // if nil, slog's default logger is used
 }
 
 type repository struct {
 	db      *sql.DB
+	dialect *dialect
 	Options RepositoryOptions
-	wordIds map[string]int
+	logger  *slog.Logger
 	//Storage colly.Storage
-}
 
-var repo *repository
-var once sync.Once
-
-var dbuser = "rosie"
-var dbname = "tcsuite"
+	// Ids of the words added or retrieved so far, shared by concurrent
+	// calls to RegisterTokens
+	wordIdsMu sync.Mutex
+	wordIds   map[string]int
+}
 
-func GetRepository(options RepositoryOptions) Repository {
-	once.Do(func() {
-		repo = &repository{
-			wordIds: map[string]int{},
-		}
-		var err error
-		repo.db, err = sql.Open("postgres", "user=rosie dbname=tcsuite sslmode=disable")
-		if err != nil {
-			log.Fatal(err)
-		}
+// NewRepository opens a repository on the database given by options.Dsn,
+// a PostgreSQL connection string or, if options.Driver is SQLite, the
+// path of an SQLite database file. If no Dsn is given, the driver and Dsn
+// are read from the configuration file named by options.ConfigFile, by
+// the TCSUITE_CONFIG environment variable or, if present, the default
+// configuration file. PostgreSQL connection parameters that remain
+// unspecified are taken from the standard PG* environment variables.
+//
+// The schema of the database must be up to date, as by 'tcsuite db
+// migrate', unless options.AutoMigrate is set.
+//
+// Each call opens a new connection pool, which Close releases.
+func NewRepository(options RepositoryOptions) (Repository, error) {
+	db, d, err := openDatabase(options)
+	if err != nil {
+		return nil, err
+	}
 
-		if err = repo.db.Ping(); err != nil {
-			log.Fatal(err)
-		}
+	if err = checkSchema(db, d, options); err != nil {
+		db.Close()
+		return nil, err
+	}
 
-		repo.db.SetMaxOpenConns(50)
-		repo.db.SetMaxIdleConns(0)
+	return &repository{
+		db:      db,
+		dialect: d,
+		Options: options,
+		logger:  logging.Component(options.Logger, "repository"),
+		wordIds: map[string]int{},
+	}, nil
+}
 
-		initDatabase(repo.db, options.RestoreRequestHistory)
+// Close closes the connections to the database.
+func (r *repository) Close() error {
+	return r.db.Close()
+}
 
-		repo.Options.RestoreRequestHistory = options.RestoreRequestHistory
-	})
+func openDatabase(options RepositoryOptions) (*sql.DB, *dialect, error) {
+	driver, dsn, err := connectionString(options)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	return repo
-}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, nil, err
+	}
 
-func initDatabase(db *sql.DB, restoreRequestHistory bool) {
-	// CONTENT
-	db.Exec("CREATE TABLE IF NOT EXISTS original_content (id SERIAL PRIMARY KEY, title VARCHAR NOT NULL, date TIMESTAMP, author VARCHAR, abstract VARCHAR, body TEXT NOT NULL, uri VARCHAR UNIQUE NOT NULL, language INTEGER REFERENCES languages(id), tokenized BOOLEAN DEFAULT FALSE)")
-	//db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS language_idx ON original_content(language)")
-	db.Exec("CREATE TABLE IF NOT EXISTS sources (name VARCHAR UNIQUE NOT NULL, uri VARCHAR)")
-	db.Exec("CREATE TABLE IF NOT EXISTS content_tags (name VARCHAR UNIQUE NOT NULL)")
-	db.Exec("CREATE TABLE IF NOT EXISTS content_to_sources (contentId INTEGER REFERENCES original_content(id), source VARCHAR REFERENCES sources(name), unique(contentId, source))")
-	db.Exec("CREATE TABLE IF NOT EXISTS content_to_tags (contentId INTEGER REFERENCES original_content(id), tag VARCHAR REFERENCES content_tags(name), unique(contentId, tag))")
-	db.Exec("CREATE TABLE IF NOT EXISTS languages (id SERIAL PRIMARY KEY, name VARCHAR UNIQUE NOT NULL)")
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
 
-	// WORDS
-	db.Exec("CREATE TABLE IF NOT EXISTS words (id SERIAL PRIMARY KEY, word VARCHAR NOT NULL, lexical BOOLEAN DEFAULT TRUE, language INTEGER REFERENCES languages(id), constraint unique_word_lang_pair unique (word, language))")
-	db.Exec("CREATE TABLE IF NOT EXISTS tokenized_content (id SERIAL PRIMARY KEY, position INTEGER NOT NULL, word INTEGER REFERENCES words(id), content INTEGER REFERENCES original_content(id))")
-	db.Exec("CREATE INDEX IF NOT EXISTS token_content_idx ON tokenized_content(content)")
+	d := dialects[driver]
+	d.configure(db)
 
-	db.Exec("CREATE MATERIALZIED VIEW IF NOT EXISTS token_strings " +
-		"SELECT tokenized_content.content, tokenized_content.id AS token_id, tokenized_content.position, words.id AS word_id, words.word, words.lexical " +
-		"FROM tokenized_content LEFT JOIN words ON tokenized_content.word = words.id")
-	db.Exec("CREATE INDEX IF NOT EXISTS ON token_strings (content)")
-	db.Exec("CREATE INDEX IF NOT EXISTS ON token_strings (position)")
-	db.Exec("CREATE INDEX IF NOT EXISTS ON token_strings (word_id)")
+	return db, d, nil
+}
 
-	// LEXICA
-	db.Exec("CREATE TABLE IF NOT EXISTS lexica (id SERIAL PRIMARY KEY, name VARCHAR UNIQUE NOT NULL, language INTEGER REFERENCES languages(id))")
-	db.Exec("CREATE TABLE IF NOT EXISTS lexicon_words (id SERIAL PRIMARY KEY, word VARCHAR NOT NULL, frequency INTEGER NOT NULL DEFAULT 0, lexicon INTEGER REFERENCES lexica(id), unique(word, lexicon))")
+// checkSchema applies or, unless options.AutoMigrate is set, refuses to
+// work with pending migrations.
+func checkSchema(db *sql.DB, d *dialect, options RepositoryOptions) error {
+	m, err := newMigrator(db, d, options.Logger)
+	if err != nil {
+		return err
+	}
 
-	// COLLY BOOKKEEPING
-	if !restoreRequestHistory {
-		db.Exec("DROP TABLE IF EXISTS request_history")
+	if options.AutoMigrate {
+		_, err = m.Migrate()
+		return err
 	}
-	db.Exec("CREATE TABLE IF NOT EXISTS request_history (requestId VARCHAR)")
-	db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS requestId_idx ON request_history(requestId)")
 
-	if !restoreRequestHistory {
-		db.Exec("DROP TABLE IF EXISTS cookie_history")
+	pending, err := m.Pending()
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("database schema is out of date (%d pending migrations); run 'tcsuite db migrate'", len(pending))
 	}
-	db.Exec("CREATE TABLE IF NOT EXISTS cookie_history (host VARCHAR, cookies VARCHAR)")
-	db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS host_idx ON cookie_history(host)")
-}
 
-func (r *repository) SaveContent(c *content.FetchedContent) {
-	// TODO: deal with conflicts (...ON CONFLICT DO NOTHING)
-	// TODO: use a transaction
+	return nil
+}
 
+// SaveContent saves c along with its source and tags in a single
+// transaction, returning the id of the new content. If content with the
+// same uri has been saved already, a *DuplicateContentError is returned;
+// any other failure is returned as a *SaveContentError.
+func (r *repository) SaveContent(c *content.FetchedContent) (int, error) {
 	// Add language and retrieve id
 	if c.Language == "" {
-		log.Fatal("No language present on FetchedContent")
+		return 0, &SaveContentError{Uri: c.Uri, Err: errors.New("no language present on FetchedContent")}
 	}
 	languageId, err := r.addOrRetrieveLanguageId(c.Language)
 	if err != nil {
-		log.Fatal(err)
+		return 0, &SaveContentError{Uri: c.Uri, Err: err}
 	}
 
-	// Insert content
-	var lastContentId int
-	err = r.db.QueryRow("INSERT INTO original_content (title, date, author, abstract, body, uri, language) VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT DO NOTHING RETURNING id",
-		c.Title, c.Date, c.Author, c.Abstract, c.Body, c.Uri, languageId).Scan(&lastContentId)
+	tx, err := r.db.Begin()
 	if err != nil {
-		if err == sql.ErrNoRows { // content saved already
-			return
+		return 0, &SaveContentError{Uri: c.Uri, Err: err}
+	}
+
+	contentId, err := saveContent(tx, c, languageId)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return 0, &SaveContentError{Uri: c.Uri, Err: rollbackErr}
+		}
+		if _, ok := err.(*DuplicateContentError); ok {
+			return 0, err
 		}
-		log.Fatal(err)
+		return 0, &SaveContentError{Uri: c.Uri, Err: err}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, &SaveContentError{Uri: c.Uri, Err: err}
+	}
+
+	r.logger.Debug("content saved", logging.ContentIdKey, contentId, logging.UrlKey, c.Uri)
+	return contentId, nil
+}
+
+func saveContent(tx *sql.Tx, c *content.FetchedContent, languageId int) (int, error) {
+	// Insert content
+	var contentId int
+	err := tx.QueryRow("INSERT INTO original_content (title, date, author, abstract, body, uri, language) VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT DO NOTHING RETURNING id",
+		c.Title, c.Date, c.Author, c.Abstract, c.Body, c.Uri, languageId).Scan(&contentId)
+	if err == sql.ErrNoRows { // content saved already
+		return 0, &DuplicateContentError{Uri: c.Uri}
+	} else if err != nil {
+		return 0, err
 	}
 
-	_, err = r.db.Exec("INSERT INTO sources (name) VALUES ($1) ON CONFLICT DO NOTHING", c.CanonName)
+	_, err = tx.Exec("INSERT INTO sources (name) VALUES ($1) ON CONFLICT DO NOTHING", c.CanonName)
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 
 	for _, tag := range c.Tags {
-		_, err = r.db.Exec("INSERT INTO content_tags (name) VALUES ($1) ON CONFLICT DO NOTHING", tag)
+		_, err = tx.Exec("INSERT INTO content_tags (name) VALUES ($1) ON CONFLICT DO NOTHING", tag)
 		if err != nil {
-			log.Fatal(err)
+			return 0, err
 		}
 
-		_, err = r.db.Exec("INSERT INTO content_to_tags (contentId, tag) VALUES ($1, $2) ON CONFLICT DO NOTHING", lastContentId, tag)
+		_, err = tx.Exec("INSERT INTO content_to_tags (contentId, tag) VALUES ($1, $2) ON CONFLICT DO NOTHING", contentId, tag)
 		if err != nil {
-			log.Fatal(err)
+			return 0, err
 		}
 	}
 
-	_, err = r.db.Exec("INSERT INTO content_to_sources (contentId, source) VALUES ($1, $2) ON CONFLICT DO NOTHING", lastContentId, c.CanonName)
+	_, err = tx.Exec("INSERT INTO content_to_sources (contentId, source) VALUES ($1, $2) ON CONFLICT DO NOTHING", contentId, c.CanonName)
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 
+	return contentId, nil
 }
 
+// ContentExists reports whether content with the given uri has been saved.
+func (r *repository) ContentExists(uri string) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow("SELECT EXISTS (SELECT 1 FROM original_content WHERE uri = $1)", uri).Scan(&exists)
+	return exists, err
+}
+
+// GetFetchedContent returns the content with the given id, or
+// sql.ErrNoRows if there is none.
 func (r *repository) GetFetchedContent(id int) (*content.FetchedContent, error) {
-	var c content.FetchedContent
-	err := r.db.QueryRow("SELECT id, title, date, author, abstract, body FROM original_content WHERE id = $1", id).Scan(
-		&c.Id, &c.Title, &c.Date, &c.Author, &c.Abstract, &c.Body)
+	contents, err := r.queryContent(context.Background(), "SELECT "+contentColumns+" FROM original_content WHERE id = $1", id)
 	if err != nil {
 		return nil, err
 	}
+	if len(contents) == 0 {
+		return nil, sql.ErrNoRows
+	}
 
-	return &c, nil
+	return contents[0], nil
 }
 
 func (r *repository) GetFetchedContentByTag(tag string) ([]*content.FetchedContent, error) {
+	return collectContent(r, ContentFilter{Tag: tag})
+}
+
+func (r *repository) GetUntokenizedContent() ([]*content.FetchedContent, error) {
+	return collectContent(r, ContentFilter{Tokenized: NotTokenized})
+}
+
+func collectContent(r Repository, filter ContentFilter) ([]*content.FetchedContent, error) {
 	contents := []*content.FetchedContent{}
-	rows, err := r.db.Query("SELECT id, title, date, author, abstract, body FROM original_content WHERE id in (SELECT contentid FROM content_to_tags WHERE tag = $1)", tag)
+	err := r.IterateContent(context.Background(), filter, func(c *content.FetchedContent) error {
+		contents = append(contents, c)
+		return nil
+	})
 	if err != nil {
 		return []*content.FetchedContent{}, err
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var c content.FetchedContent
-		if err := rows.Scan(&c.Id, &c.Title, &c.Date, &c.Author, &c.Abstract, &c.Body); err != nil {
-			return []*content.FetchedContent{}, err
+	return contents, nil
+}
+
+// Number of rows read at a time by IterateContent
+var contentPageSize = 500
+
+// IterateContent reads the content matched by filter a page at a time,
+// keyed on id, so that neither the whole result nor an open cursor is held
+// while fn runs.
+func (r *repository) IterateContent(ctx context.Context, filter ContentFilter, fn func(*content.FetchedContent) error) error {
+	lastId := 0
+	for {
+		page, err := r.contentPage(ctx, filter, lastId)
+		if err != nil {
+			return err
 		}
-		contents = append(contents, &c)
+
+		for _, c := range page {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := fn(c); err != nil {
+				return err
+			}
+		}
+
+		if len(page) < contentPageSize {
+			return nil
+		}
+		lastId = page[len(page)-1].Id
 	}
+}
 
-	if err = rows.Err(); err != nil {
-		return []*content.FetchedContent{}, err
+func (r *repository) contentPage(ctx context.Context, filter ContentFilter, lastId int) ([]*content.FetchedContent, error) {
+	where, args := filter.where([]interface{}{lastId})
+	return r.queryContent(ctx, fmt.Sprintf("SELECT %s FROM original_content WHERE id > $1 AND %s ORDER BY id LIMIT %d",
+		contentColumns, where, contentPageSize), args...)
+}
+
+// QueryContent returns the content matched by query, in the order and
+// within the limits it gives.
+func (r *repository) QueryContent(ctx context.Context, query ContentQuery) ([]*content.FetchedContent, error) {
+	if err := query.validate(); err != nil {
+		return nil, err
+	}
+	orderBy, _ := query.orderBy()
+
+	where, args := query.where(nil)
+	statement := fmt.Sprintf("SELECT %s FROM original_content WHERE %s ORDER BY %s", contentColumns, where, orderBy)
+	if query.Limit > 0 {
+		statement += fmt.Sprintf(" LIMIT %d", query.Limit)
+	} else if query.Offset > 0 {
+		statement += " LIMIT " + r.dialect.noLimit
+	}
+	if query.Offset > 0 {
+		statement += fmt.Sprintf(" OFFSET %d", query.Offset)
 	}
 
-	return contents, nil
+	return r.queryContent(ctx, statement, args...)
 }
 
-func (r *repository) GetUntokenizedContent() ([]*content.FetchedContent, error) {
-	contents := make([]*content.FetchedContent, 0, 10000)
-	//contents := []*content.FetchedContent{}
-	rows, err := r.db.Query("SELECT id, title, date, author, abstract, body FROM original_content WHERE tokenized = false")
+// Columns of original_content read by queryContent
+const contentColumns = "id, title, date, author, abstract, body, uri, (SELECT name FROM languages WHERE languages.id = original_content.language)"
+
+// queryContent runs a query selecting contentColumns and returns the
+// content read, along with its tags and source. Dates are formatted as
+// DateFormat, as they are saved.
+func (r *repository) queryContent(ctx context.Context, query string, args ...interface{}) ([]*content.FetchedContent, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return []*content.FetchedContent{}, err
+		return nil, err
 	}
 	defer rows.Close()
 
+	contents := []*content.FetchedContent{}
 	for rows.Next() {
 		var c content.FetchedContent
-		if err := rows.Scan(&c.Id, &c.Title, &c.Date, &c.Author, &c.Abstract, &c.Body); err != nil {
-			return []*content.FetchedContent{}, err
+		var date sql.NullTime
+		var language sql.NullString
+		if err := rows.Scan(&c.Id, &c.Title, &date, &c.Author, &c.Abstract, &c.Body, &c.Uri, &language); err != nil {
+			return nil, err
+		}
+		if date.Valid {
+			c.Date = date.Time.Format(DateFormat)
 		}
+		c.Language = language.String
+		c.Tags = []string{}
 		contents = append(contents, &c)
 	}
-
-	if err = rows.Err(); err != nil {
-		return []*content.FetchedContent{}, err
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
+	// SQLite has a single connection, which the rows hold until closed
+	rows.Close()
 
+	if err := r.loadContentMetadata(ctx, contents); err != nil {
+		return nil, err
+	}
 	return contents, nil
 }
 
-func (r *repository) RegisterTokens(contentId int, tokens []*corpus.Word) error {
-	var languageId int
-	var tokenized bool
-	err := r.db.QueryRow("SELECT language, tokenized FROM original_content WHERE id = $1", contentId).Scan(&languageId, &tokenized)
+// loadContentMetadata sets the tags, in lexical order, and the source of
+// contents, contentPageSize contents at a time.
+func (r *repository) loadContentMetadata(ctx context.Context, contents []*content.FetchedContent) error {
+	for start := 0; start < len(contents); start += contentPageSize {
+		end := start + contentPageSize
+		if end > len(contents) {
+			end = len(contents)
+		}
+
+		byId := map[int]*content.FetchedContent{}
+		ids := []interface{}{}
+		placeholders := []string{}
+		for _, c := range contents[start:end] {
+			byId[c.Id] = c
+			ids = append(ids, c.Id)
+			placeholders = append(placeholders, fmt.Sprintf("$%d", len(ids)))
+		}
+		in := strings.Join(placeholders, ", ")
+
+		err := r.eachPair(ctx, "SELECT contentId, tag FROM content_to_tags WHERE contentId IN ("+in+")", ids, func(id int, tag string) {
+			byId[id].Tags = append(byId[id].Tags, tag)
+		})
+		if err != nil {
+			return err
+		}
+
+		err = r.eachPair(ctx, "SELECT contentId, source FROM content_to_sources WHERE contentId IN ("+in+")", ids, func(id int, source string) {
+			byId[id].CanonName = source
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, c := range contents {
+		sort.Strings(c.Tags)
+	}
+	return nil
+}
+
+// eachPair calls fn with each row of a query selecting a content id and a
+// string.
+func (r *repository) eachPair(ctx context.Context, query string, args []interface{}, fn func(id int, value string)) error {
+	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return err
 	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var value string
+		if err := rows.Scan(&id, &value); err != nil {
+			return err
+		}
+		fn(id, value)
+	}
 
-	// Halt if content is already tokenized
-	if tokenized {
+	return rows.Err()
+}
+
+// CountContent returns the number of contents matched by filter.
+func (r *repository) CountContent(ctx context.Context, filter ContentFilter) (int, error) {
+	where, args := filter.where(nil)
+	var count int
+	err := r.db.QueryRowContext(ctx, "SELECT count(*) FROM original_content WHERE "+where, args...).Scan(&count)
+	return count, err
+}
+
+// Search matches query against the tokens of the content containing any
+// of its candidate words or, failing those, of all tokenized content
+// matched by filter, reading the tokens a page of content at a time.
+func (r *repository) Search(ctx context.Context, query *SearchQuery, filter ContentFilter, limit int) ([]*SearchResult, error) {
+	filter.Tokenized = IsTokenized
+	total, err := r.CountContent(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := r.searchCandidates(ctx, query, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	ranker := NewSearchRanker(query, total)
+	err = r.eachContentWords(ctx, ids, func(id int, words []*corpus.Word) error {
+		ranker.Add(id, tokenStrings(words))
 		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// Retrieve/add word ids corresponding to the tokens
-	// TODO: address this bottleneck
-	/*
-		wordIds := []int{}
-		for _, token := range tokens {
-			wordId, err := r.addOrRetrieveWordId(token.Word, token.Lexical, languageId)
-			if err != nil {
+	results := ranker.Results(limit)
+	if len(results) == 0 {
+		return results, nil
+	}
+
+	args := []interface{}{}
+	placeholders := []string{}
+	for _, result := range results {
+		args = append(args, result.Content.Id)
+		placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+	}
+	contents, err := r.queryContent(ctx, fmt.Sprintf("SELECT %s FROM original_content WHERE id IN (%s)",
+		contentColumns, strings.Join(placeholders, ", ")), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	byId := map[int]*content.FetchedContent{}
+	for _, c := range contents {
+		byId[c.Id] = c
+	}
+	for _, result := range results {
+		result.Content = byId[result.Content.Id]
+	}
+
+	return results, nil
+}
+
+// searchCandidates returns the ids of the content matched by filter that
+// may match query, in order.
+func (r *repository) searchCandidates(ctx context.Context, query *SearchQuery, filter ContentFilter) ([]int, error) {
+	if words := query.candidateWords(); words != nil {
+		return r.contentContaining(ctx, words, filter)
+	}
+
+	where, args := filter.where(nil)
+	return r.queryIds(ctx, "SELECT id FROM original_content WHERE "+where+" ORDER BY id", args...)
+}
+
+// contentContaining returns the ids of the content matched by filter in
+// which any of words occurs, in order.
+func (r *repository) contentContaining(ctx context.Context, words []string, filter ContentFilter) ([]int, error) {
+	args := []interface{}{}
+	placeholders := make([]string, len(words))
+	for i, word := range words {
+		args = append(args, word)
+		placeholders[i] = fmt.Sprintf("$%d", len(args))
+	}
+	where, args := filter.where(args)
+
+	return r.queryIds(ctx, fmt.Sprintf("SELECT DISTINCT content FROM token_strings WHERE word IN (%s) AND content IN (SELECT id FROM original_content WHERE %s) ORDER BY content",
+		strings.Join(placeholders, ", "), where), args...)
+}
+
+func (r *repository) queryIds(ctx context.Context, query string, args ...interface{}) ([]int, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// IterateTokens reads the tokens of the content matched by filter a page
+// of content at a time.
+func (r *repository) IterateTokens(ctx context.Context, filter ContentFilter, fn func(contentId int, tokens []*corpus.Word) error) error {
+	filter.Tokenized = IsTokenized
+	where, args := filter.where(nil)
+	ids, err := r.queryIds(ctx, "SELECT id FROM original_content WHERE "+where+" ORDER BY id", args...)
+	if err != nil {
+		return err
+	}
+
+	return r.eachContentWords(ctx, ids, fn)
+}
+
+// eachContentWords calls fn with the tokens of each content with the
+// given ids, in order, reading the tokens of contentPageSize contents at a
+// time. It stops at the first error returned by fn or when ctx is done.
+func (r *repository) eachContentWords(ctx context.Context, ids []int, fn func(id int, words []*corpus.Word) error) error {
+	for start := 0; start < len(ids); start += contentPageSize {
+		end := start + contentPageSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		args := make([]interface{}, end-start)
+		placeholders := make([]string, end-start)
+		for i, id := range ids[start:end] {
+			args[i] = id
+			placeholders[i] = fmt.Sprintf("$%d", i+1)
+		}
+
+		words, err := r.contentWords(ctx, fmt.Sprintf("SELECT content, word, lexical FROM token_strings WHERE content IN (%s) ORDER BY content, position",
+			strings.Join(placeholders, ", ")), args)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids[start:end] {
+			if err := ctx.Err(); err != nil {
 				return err
 			}
+			if err := fn(id, words[id]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
 
-			wordIds = append(wordIds, wordId)
+// contentWords runs a query selecting content ids, words and whether they
+// are lexical, returning the words by content id.
+func (r *repository) contentWords(ctx context.Context, query string, args []interface{}) (map[int][]*corpus.Word, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	words := map[int][]*corpus.Word{}
+	for rows.Next() {
+		var id int
+		var w corpus.Word
+		if err := rows.Scan(&id, &w.Word, &w.Lexical); err != nil {
+			return nil, err
 		}
-	*/
+		words[id] = append(words[id], &w)
+	}
 
-	wordToId, err := r.addOrRetrieveWordIds(tokens, languageId)
+	return words, rows.Err()
+}
+
+// GetTokens returns the tokens of the content with the given id, in order.
+func (r *repository) GetTokens(ctx context.Context, contentId int) ([]*corpus.Word, error) {
+	var tokenized bool
+	err := r.db.QueryRowContext(ctx, "SELECT tokenized FROM original_content WHERE id = $1", contentId).Scan(&tokenized)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if !tokenized {
+		return nil, ErrNotTokenized
 	}
 
-	tx, err := r.db.Begin()
+	words, err := r.contentWords(ctx, "SELECT content, word, lexical FROM token_strings WHERE content = $1 ORDER BY position", []interface{}{contentId})
 	if err != nil {
-		return err
+		return nil, err
 	}
+	if words[contentId] == nil {
+		return []*corpus.Word{}, nil
+	}
+	return words[contentId], nil
+}
 
-	//stmt, err := tx.Prepare("INSERT INTO tokenized_content (position, word, content) VALUES ($1, $2, $3)")
-	stmt, err := tx.Prepare(pq.CopyIn("tokenized_content", "position", "word", "content"))
+// GetTags returns the tags of the content with the number of contents of
+// each, by name.
+func (r *repository) GetTags(ctx context.Context) ([]*NameCount, error) {
+	return r.nameCounts(ctx, "SELECT tag, count(*) FROM content_to_tags GROUP BY tag")
+}
+
+// GetSources returns the sources of the content with the number of
+// contents of each, by name.
+func (r *repository) GetSources(ctx context.Context) ([]*NameCount, error) {
+	return r.nameCounts(ctx, "SELECT source, count(*) FROM content_to_sources GROUP BY source")
+}
+
+// nameCounts runs a query selecting names and counts, returning them
+// sorted by name. They are sorted here, as collations differ between
+// databases.
+func (r *repository) nameCounts(ctx context.Context, query string) ([]*NameCount, error) {
+	rows, err := r.db.QueryContext(ctx, query)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer rows.Close()
 
-	// Compile tokenized corpus
-	for i, token := range tokens {
-		//_, err = stmt.Exec(i, wordIds[i], contentId)
-		//fmt.Printf("%s %d %d\n", token.Word, wordToId[token.Word], contentId)
-		_, err = stmt.Exec(i, wordToId[token.Word], contentId)
-		if err != nil {
-			if rollbackErr := tx.Rollback(); rollbackErr != nil {
-				return rollbackErr
-			}
-			return err
+	counts := []*NameCount{}
+	for rows.Next() {
+		var c NameCount
+		if err := rows.Scan(&c.Name, &c.Count); err != nil {
+			return nil, err
 		}
+		counts = append(counts, &c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
-	_, err = stmt.Exec()
+	sort.Slice(counts, func(i, j int) bool {
+		return counts[i].Name < counts[j].Name
+	})
+	return counts, nil
+}
+
+func tokenStrings(words []*corpus.Word) []string {
+	tokens := make([]string, len(words))
+	for i, w := range words {
+		tokens[i] = w.Word
+	}
+	return tokens
+}
+
+// Concordance reads the tokens of the content in which word occurs a page
+// of content at a time.
+func (r *repository) Concordance(ctx context.Context, word string, window int, filter ContentFilter) ([]*ConcordanceLine, error) {
+	if window < 0 {
+		return nil, fmt.Errorf("negative concordance window %d", window)
+	}
+
+	ids, err := r.contentContaining(ctx, []string{word}, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := []*ConcordanceLine{}
+	err = r.eachContentWords(ctx, ids, func(id int, words []*corpus.Word) error {
+		lines = append(lines, ConcordanceLines(id, tokenStrings(words), word, window)...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return lines, nil
+}
+
+// RegisterTokens records the tokens of the content with the given id,
+// unless it has been tokenized already.
+func (r *repository) RegisterTokens(contentId int, tokens []*corpus.Word) error {
+	return r.RegisterTokenBatch([]*TokenizedContent{{ContentId: contentId, Tokens: tokens}})
+}
+
+// RegisterTokenBatch records the tokens of several contents in a single
+// transaction, skipping those tokenized already, and clears their
+// failures. Nothing is recorded if any of the contents is missing. The ids
+// of the batch are bound as parameters, so batches should be kept to a few
+// hundred contents.
+func (r *repository) RegisterTokenBatch(batch []*TokenizedContent) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	args := make([]interface{}, len(batch))
+	placeholders := make([]string, len(batch))
+	for i, c := range batch {
+		args[i] = c.ContentId
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	rows, err := r.db.Query("SELECT id, language, tokenized FROM original_content WHERE id IN ("+strings.Join(placeholders, ", ")+")", args...)
 	if err != nil {
 		return err
 	}
+	languageIds := map[int]int{}
+	tokenized := map[int]bool{}
+	for rows.Next() {
+		var id, languageId int
+		var done bool
+		if err := rows.Scan(&id, &languageId, &done); err != nil {
+			rows.Close()
+			return err
+		}
+		languageIds[id] = languageId
+		tokenized[id] = done
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Retrieve/add word ids corresponding to the tokens, content by content
+	// to bound the size of the statements
+	// TODO: address this bottleneck
+	tokenRows := [][]interface{}{}
+	registered := []interface{}{}
+	placeholders = []string{}
+	for _, c := range batch {
+		languageId, ok := languageIds[c.ContentId]
+		if !ok {
+			return fmt.Errorf("content %d: %w", c.ContentId, sql.ErrNoRows)
+		}
+		// Halt if content is already tokenized
+		if tokenized[c.ContentId] {
+			continue
+		}
+		tokenized[c.ContentId] = true // the batch may repeat it
+
+		wordToId, err := r.addOrRetrieveWordIds(c.Tokens, languageId)
+		if err != nil {
+			return err
+		}
+		for i, token := range c.Tokens {
+			tokenRows = append(tokenRows, []interface{}{i, wordToId[token.Word], c.ContentId})
+		}
+		registered = append(registered, c.ContentId)
+		placeholders = append(placeholders, fmt.Sprintf("$%d", len(registered)))
+	}
+	if len(registered) == 0 {
+		return nil
+	}
 
-	err = stmt.Close()
+	tx, err := r.db.Begin()
 	if err != nil {
 		return err
 	}
 
-	// Update content tokenized status
-	_, err = tx.Exec("UPDATE original_content SET tokenized = TRUE WHERE id = $1", contentId)
+	// Compile tokenized corpus
+	err = r.dialect.copyIn(tx, "tokenized_content", []string{"position", "word", "content"}, tokenRows)
 	if err != nil {
 		if rollbackErr := tx.Rollback(); rollbackErr != nil {
 			return rollbackErr
@@ -299,14 +903,57 @@ func (r *repository) RegisterTokens(contentId int, tokens []*corpus.Word) error
 		return err
 	}
 
+	// Update content tokenized status
+	in := strings.Join(placeholders, ", ")
+	for _, query := range []string{
+		"UPDATE original_content SET tokenized = TRUE WHERE id IN (" + in + ")",
+		"DELETE FROM tokenize_failures WHERE content IN (" + in + ")",
+	} {
+		if _, err = tx.Exec(query, registered...); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return rollbackErr
+			}
+			return err
+		}
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		return err
 	}
 
+	r.logger.Debug("tokens registered", "contents", len(registered), "tokens", len(tokenRows))
 	return nil
 }
 
+// RecordTokenizeFailure records that the content with the given id could
+// not be tokenized, counting the attempts.
+func (r *repository) RecordTokenizeFailure(contentId int, reason string) error {
+	_, err := r.db.Exec("INSERT INTO tokenize_failures (content, error, attempts, failed) VALUES ($1, $2, 1, $3) ON CONFLICT (content) DO UPDATE SET error = $2, attempts = tokenize_failures.attempts + 1, failed = $3",
+		contentId, reason, time.Now().UTC())
+	return err
+}
+
+// GetTokenizeFailures returns the contents that could not be tokenized, in
+// order of id.
+func (r *repository) GetTokenizeFailures(ctx context.Context) ([]*TokenizeFailure, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT content, error, attempts, failed FROM tokenize_failures ORDER BY content")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	failures := []*TokenizeFailure{}
+	for rows.Next() {
+		f := &TokenizeFailure{}
+		if err := rows.Scan(&f.ContentId, &f.Error, &f.Attempts, &f.Failed); err != nil {
+			return nil, err
+		}
+		failures = append(failures, f)
+	}
+	return failures, rows.Err()
+}
+
 // Below is this repository's implementation of colly's Storage interface
 
 func (r *repository) Init() error {
@@ -328,7 +975,7 @@ func (r *repository) IsVisited(requestId uint64) (bool, error) {
 	if err == sql.ErrNoRows {
 		return false, nil
 	} else if err != nil {
-		fmt.Printf("Repository error: %v\n", err)
+		r.logger.Error("error checking request history", logging.ErrorKey, err)
 		return false, err
 	}
 
@@ -343,7 +990,7 @@ func (r *repository) Cookies(u *url.URL) string {
 	var cookies string
 	err := r.db.QueryRow("SELECT cookies FROM cookie_history WHERE host = $1", u.Hostname()).Scan(&cookies)
 	if err != nil {
-		fmt.Printf("Repository error: %v\n", err)
+		r.logger.Error("error retrieving cookies", logging.UrlKey, u.String(), logging.ErrorKey, err)
 		return ""
 	}
 
@@ -357,10 +1004,94 @@ func (r *repository) SetCookies(u *url.URL, cookies string) {
 
 	_, err := r.db.Exec("INSERT INTO cookie_history (host, cookies) VALUES ($1, $2) ON CONFLICT DO NOTHING", u.Hostname(), cookies)
 	if err != nil {
-		fmt.Printf("Repository error: %v\n", err)
+		r.logger.Error("error saving cookies", logging.UrlKey, u.String(), logging.ErrorKey, err)
 	}
 }
 
+// ClearRequestHistory forgets the requests and cookies recorded by colly.
+// The history is kept only to resume an interrupted crawl, so a new crawl
+// clears it before it starts.
+func (r *repository) ClearRequestHistory() error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"request_history", "cookie_history"} {
+		if _, err := tx.Exec("DELETE FROM " + table); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// FETCH STATE
+
+// SaveFetchState saves the crawl state of the named fetcher,
+// replacing any state saved previously.
+func (r *repository) SaveFetchState(fetcher string, state []byte) error {
+	_, err := r.db.Exec("INSERT INTO fetch_state (fetcher, state, updated) VALUES ($1, $2, $3) ON CONFLICT (fetcher) DO UPDATE SET state = EXCLUDED.state, updated = EXCLUDED.updated",
+		fetcher, string(state), time.Now().UTC())
+	return err
+}
+
+// GetFetchState retrieves the crawl state last saved by the named fetcher.
+// If there is none, nil is returned.
+func (r *repository) GetFetchState(fetcher string) ([]byte, error) {
+	var state string
+	err := r.db.QueryRow("SELECT state FROM fetch_state WHERE fetcher = $1", fetcher).Scan(&state)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return []byte(state), nil
+}
+
+// FETCH STATISTICS
+
+// SaveFetchRun records the statistics of a fetch, setting run.Id.
+func (r *repository) SaveFetchRun(run *FetchRun) error {
+	failures, err := json.Marshal(run.Failures)
+	if err != nil {
+		return err
+	}
+
+	return r.db.QueryRow("INSERT INTO fetch_runs (fetcher, started, finished, pages_visited, articles, failures, saved, duplicates, save_failures) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id",
+		run.Fetcher, run.Started.UTC(), run.Finished.UTC(), run.PagesVisited, run.Articles, string(failures), run.Saved, run.Duplicates, run.SaveFailures).Scan(&run.Id)
+}
+
+// GetFetchRuns retrieves the statistics of the fetches started since
+// the given time, oldest first.
+func (r *repository) GetFetchRuns(since time.Time) ([]*FetchRun, error) {
+	runs := []*FetchRun{}
+	rows, err := r.db.Query("SELECT id, fetcher, started, finished, pages_visited, articles, failures, saved, duplicates, save_failures FROM fetch_runs WHERE started >= $1 ORDER BY started, id", since.UTC())
+	if err != nil {
+		return []*FetchRun{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		run := &FetchRun{}
+		var failures string
+		if err := rows.Scan(&run.Id, &run.Fetcher, &run.Started, &run.Finished, &run.PagesVisited, &run.Articles, &failures, &run.Saved, &run.Duplicates, &run.SaveFailures); err != nil {
+			return []*FetchRun{}, err
+		}
+		if err := json.Unmarshal([]byte(failures), &run.Failures); err != nil {
+			return []*FetchRun{}, err
+		}
+		runs = append(runs, run)
+	}
+
+	if err = rows.Err(); err != nil {
+		return []*FetchRun{}, err
+	}
+
+	return runs, nil
+}
+
 // LEXICON
 
 // AddLexeme adds an individual lexeme the the lexeme repository.
@@ -376,7 +1107,7 @@ func (r *repository) AddLexeme(name string, language string, lexeme string, freq
 		return err
 	}
 
-	_, err = r.db.Exec("INSERT INTO lexicon_words (word, frequency, lexicon) ($1, $2, $3) ON CONFLICT DO NOTHING", lexeme, frequency, lexiconId)
+	_, err = r.db.Exec("INSERT INTO lexicon_words (word, frequency, lexicon) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING", lexeme, frequency, lexiconId)
 	if err != nil {
 		return err
 	}
@@ -386,6 +1117,7 @@ func (r *repository) AddLexeme(name string, language string, lexeme string, freq
 
 // AddLexemes adds lexemes by bulk to the lexeme repository.
 // Due to limitations of pq.CopyIn() and unlike the AddLexeme() method, this will fail on duplicate entries.
+// SQLite databases are held to the same behaviour.
 func (r *repository) AddLexemes(name string, language string, lexemes []string, frequencies []int) error {
 	languageId, err := r.addOrRetrieveLanguageId(language)
 	if err != nil {
@@ -418,29 +1150,18 @@ func (r *repository) AddLexemes(name string, language string, lexemes []string,
 		return err
 	}
 
-	stmt, err := txn.Prepare(pq.CopyIn("lexicon_words", "word", "frequency", "lexicon"))
-	if err != nil {
-		return err
-	}
-
+	rows := make([][]interface{}, 0, len(lexemes))
 	for i, lexeme := range lexemes {
 		if i >= len(frequencies) {
 			break
 		}
-		_, err = stmt.Exec(lexeme, frequencies[i], lexiconId)
-		if err != nil {
-			fmt.Printf("AddLexeme error on: %s, %d (%d)\n", lexeme, frequencies[i], lexiconId)
-			return err
-		}
-	}
-
-	_, err = stmt.Exec()
-	if err != nil {
-		return err
+		rows = append(rows, []interface{}{lexeme, frequencies[i], lexiconId})
 	}
 
-	err = stmt.Close()
+	err = r.dialect.copyIn(txn, "lexicon_words", []string{"word", "frequency", "lexicon"}, rows)
 	if err != nil {
+		r.logger.Error("error adding lexemes", "lexicon_id", lexiconId, logging.ErrorKey, err)
+		txn.Rollback()
 		return err
 	}
 
@@ -489,6 +1210,72 @@ func (r *repository) GetLexemes(lexiconName string, language string) ([]string,
 	return lexemes, frequencies, nil
 }
 
+// GetLexeme returns the frequency of lexeme in the named lexicon, or
+// sql.ErrNoRows if either is missing.
+func (r *repository) GetLexeme(ctx context.Context, name string, language string, lexeme string) (int, error) {
+	var frequency int
+	err := r.db.QueryRowContext(ctx, "SELECT w.frequency FROM lexicon_words w JOIN lexica l ON l.id = w.lexicon JOIN languages g ON g.id = l.language WHERE l.name = $1 AND g.name = $2 AND w.word = $3",
+		name, language, lexeme).Scan(&frequency)
+	return frequency, err
+}
+
+// likeEscaper escapes the wildcards of LIKE patterns, with \ as the escape
+// character.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// FindLexemes returns up to limit lexemes of the named lexicon beginning
+// with prefix, if limit is positive, skipping the first offset: the lexeme
+// equal to prefix first, then by frequency and in the order they were
+// added. The prefix is matched with LIKE, so that the index on the words
+// of each lexicon is used.
+func (r *repository) FindLexemes(ctx context.Context, name string, language string, prefix string, limit int, offset int) ([]string, []int, error) {
+	if limit < 0 || offset < 0 {
+		return []string{}, []int{}, errors.New("negative lexeme limit or offset")
+	}
+
+	languageId, err := r.retrieveLanguageId(language)
+	if err != nil {
+		return []string{}, []int{}, err
+	}
+
+	lexiconId, err := r.retrieveLexiconId(name, languageId)
+	if err != nil {
+		return []string{}, []int{}, err
+	}
+
+	query := "SELECT word, frequency FROM lexicon_words WHERE lexicon = $1 AND word LIKE $2 ESCAPE '\\' ORDER BY word = $3 DESC, frequency DESC, id"
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	} else if offset > 0 {
+		query += " LIMIT " + r.dialect.noLimit
+	}
+	if offset > 0 {
+		query += fmt.Sprintf(" OFFSET %d", offset)
+	}
+	rows, err := r.db.QueryContext(ctx, query, lexiconId, likeEscaper.Replace(prefix)+"%", prefix)
+	if err != nil {
+		return []string{}, []int{}, err
+	}
+	defer rows.Close()
+
+	lexemes := []string{}
+	frequencies := []int{}
+	for rows.Next() {
+		var lexeme string
+		var frequency int
+		if err := rows.Scan(&lexeme, &frequency); err != nil {
+			return []string{}, []int{}, err
+		}
+		lexemes = append(lexemes, lexeme)
+		frequencies = append(frequencies, frequency)
+	}
+	if err := rows.Err(); err != nil {
+		return []string{}, []int{}, err
+	}
+
+	return lexemes, frequencies, nil
+}
+
 // HELPERS
 
 func (r *repository) retrieveLanguageId(name string) (int, error) {
@@ -505,7 +1292,7 @@ func (r *repository) addOrRetrieveLanguageId(name string) (int, error) {
 	var languageId int
 	err := r.db.QueryRow("SELECT id FROM languages WHERE name = $1", name).Scan(&languageId)
 	if err == sql.ErrNoRows {
-		fmt.Printf("FAILED TO GRAB LANGUAGE FROM TABLE (MUST BE FIRST OCCURRENCE?). Language: %s\n", name)
+		r.logger.Info("adding language", "language", name)
 		err = r.db.QueryRow("INSERT INTO languages (name) VALUES ($1) ON CONFLICT DO NOTHING RETURNING id", name).Scan(&languageId)
 		if err != nil {
 			return -1, err
@@ -573,7 +1360,7 @@ func (r *repository) testDoodler() error {
 		if err := rows.Scan(&word, &id); err != nil {
 			return err
 		}
-		fmt.Printf("%s %d\n", word, id)
+		r.logger.Debug("word id", "word", word, "id", id)
 	}
 	err = rows.Err()
 	if err != nil {
@@ -584,7 +1371,22 @@ func (r *repository) testDoodler() error {
 
 }
 
+// idsOf returns the ids of words known to r, which the caller must have
+// locked, for use once it is unlocked.
+func (r *repository) idsOf(words []*corpus.Word) map[string]int {
+	ids := make(map[string]int, len(words))
+	for _, word := range words {
+		if id, ok := r.wordIds[word.Word]; ok {
+			ids[word.Word] = id
+		}
+	}
+	return ids
+}
+
 func (r *repository) addOrRetrieveWordIds(words []*corpus.Word, languageId int) (map[string]int, error) {
+	r.wordIdsMu.Lock()
+	defer r.wordIdsMu.Unlock()
+
 	// TODO: whitespace isn't being inserted properly
 	wordSeen := map[string]bool{}
 
@@ -607,10 +1409,10 @@ func (r *repository) addOrRetrieveWordIds(words []*corpus.Word, languageId int)
 	}
 
 	if len(valueStrings) == 0 {
-		return r.wordIds, nil
+		return r.idsOf(words), nil
 	}
 
-	stmtString := fmt.Sprintf("INSERT INTO words (word, lexical, language) VALUES %s ON CONFLICT ON CONSTRAINT unique_word_lang_pair DO UPDATE SET language = words.language RETURNING word, id",
+	stmtString := fmt.Sprintf("INSERT INTO words (word, lexical, language) VALUES %s ON CONFLICT (word, language) DO UPDATE SET language = words.language RETURNING word, id",
 		strings.Join(valueStrings, ","))
 	rows, err := r.db.Query(stmtString, wordArgs...)
 	if err != nil {
@@ -631,7 +1433,7 @@ func (r *repository) addOrRetrieveWordIds(words []*corpus.Word, languageId int)
 		return map[string]int{}, err
 	}
 
-	return r.wordIds, nil
+	return r.idsOf(words), nil
 
 	/*
 		entryRows := make([]interface{}, 0, len(words))
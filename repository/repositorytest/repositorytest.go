@@ -0,0 +1,868 @@
+// Package repositorytest checks that implementations of
+// repository.Repository behave alike.
+package repositorytest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/qwwqe/tcsuite/content"
+	"github.com/qwwqe/tcsuite/entities/corpus"
+	"github.com/qwwqe/tcsuite/repository"
+)
+
+// Run runs the conformance tests against repositories returned by
+// newRepository, which is called once per test. The repositories must be
+// empty and have cookies enabled.
+func Run(t *testing.T, newRepository func(t *testing.T) repository.Repository) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, repo repository.Repository)
+	}{
+		{"Content", testContent},
+		{"Tokens", testTokens},
+		{"TokenBatches", testTokenBatches},
+		{"IterateContent", testIterateContent},
+		{"QueryContent", testQueryContent},
+		{"Search", testSearch},
+		{"Concordance", testConcordance},
+		{"IterateTokens", testIterateTokens},
+		{"TagsAndSources", testTagsAndSources},
+		{"Lexicon", testLexicon},
+		{"Storage", testStorage},
+		{"FetchState", testFetchState},
+		{"FetchRuns", testFetchRuns},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, newRepository(t))
+		})
+	}
+}
+
+func newContent(uri string, tags ...string) *content.FetchedContent {
+	return &content.FetchedContent{
+		Title:     "本州近海地震",
+		Date:      "2019-11-26 15:42:00",
+		Author:    "記者",
+		Abstract:  "地震",
+		Body:      "本次地震發生位置約位於日本本州西部近海。",
+		Tags:      tags,
+		CanonName: "自由時報",
+		Uri:       uri,
+		Language:  "zh-TW",
+	}
+}
+
+func testContent(t *testing.T, repo repository.Repository) {
+	fc := newContent("https://news.ltn.com.tw/news/world/breakingnews/3001234", "國際", "地震", "國際")
+
+	id, err := repo.SaveContent(fc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.SaveContent(fc); err == nil {
+		t.Errorf("SaveContent(duplicate) = _, nil; want error")
+	} else if _, ok := err.(*repository.DuplicateContentError); !ok {
+		t.Errorf("SaveContent(duplicate) = _, %v; want *DuplicateContentError", err)
+	}
+	if _, err := repo.SaveContent(&content.FetchedContent{Uri: "https://x.tw/", Title: "x", Body: "x"}); err == nil {
+		t.Errorf("SaveContent(no language) = _, nil; want error")
+	} else if _, ok := err.(*repository.SaveContentError); !ok {
+		t.Errorf("SaveContent(no language) = _, %v; want *SaveContentError", err)
+	}
+
+	if exists, err := repo.ContentExists(fc.Uri); err != nil || !exists {
+		t.Errorf("ContentExists() = %v, %v; want true, nil", exists, err)
+	}
+	if exists, err := repo.ContentExists("https://x.tw/"); err != nil || exists {
+		t.Errorf("ContentExists(unsaved) = %v, %v; want false, nil", exists, err)
+	}
+
+	got, err := repo.GetFetchedContent(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &content.FetchedContent{
+		Id:        id,
+		Title:     fc.Title,
+		Date:      fc.Date,
+		Author:    fc.Author,
+		Abstract:  fc.Abstract,
+		Body:      fc.Body,
+		Tags:      []string{"國際", "地震"},
+		CanonName: fc.CanonName,
+		Uri:       fc.Uri,
+		Language:  fc.Language,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetFetchedContent() = %+v; want %+v", got, want)
+	}
+	if _, err := repo.GetFetchedContent(id + 1); err == nil {
+		t.Errorf("GetFetchedContent(unsaved) = _, nil; want error")
+	}
+
+	other, err := repo.SaveContent(newContent("https://news.ltn.com.tw/news/politics/breakingnews/3001235", "政治"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		tag  string
+		want []int
+	}{
+		{"地震", []int{id}},
+		{"政治", []int{other}},
+		{"體育", []int{}},
+	}
+	for _, test := range tests {
+		tagged, err := repo.GetFetchedContentByTag(test.tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ids(tagged); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("GetFetchedContentByTag(%s) = %v; want %v", test.tag, got, test.want)
+		}
+	}
+}
+
+func testTokens(t *testing.T, repo repository.Repository) {
+	id, err := repo.SaveContent(newContent("https://news.ltn.com.tw/news/world/breakingnews/3001234"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	untokenized, err := repo.GetUntokenizedContent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(untokenized); !reflect.DeepEqual(got, []int{id}) {
+		t.Errorf("GetUntokenizedContent() = %v; want %v", got, []int{id})
+	}
+
+	tokens := []*corpus.Word{
+		{Word: "本", Lexical: true},
+		{Word: "次", Lexical: true},
+		{Word: "地震", Lexical: true},
+		{Word: "。", Lexical: false},
+		{Word: "地震", Lexical: true},
+	}
+	ctx := context.Background()
+	if _, err := repo.GetTokens(ctx, id); err != repository.ErrNotTokenized {
+		t.Errorf("GetTokens(untokenized) = _, %v; want %v", err, repository.ErrNotTokenized)
+	}
+	if err := repo.RegisterTokens(id, tokens); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := repo.GetTokens(ctx, id); err != nil || !reflect.DeepEqual(got, tokens) {
+		t.Errorf("GetTokens() = %v, %v; want %v, nil", got, err, tokens)
+	}
+	if _, err := repo.GetTokens(ctx, id+1); err != sql.ErrNoRows {
+		t.Errorf("GetTokens(unsaved) = _, %v; want %v", err, sql.ErrNoRows)
+	}
+	// Content is tokenized only once
+	if err := repo.RegisterTokens(id, tokens); err != nil {
+		t.Errorf("RegisterTokens(tokenized) = %v; want nil", err)
+	}
+	if err := repo.RegisterTokens(id+1, tokens); err == nil {
+		t.Errorf("RegisterTokens(unsaved) = nil; want error")
+	}
+
+	if untokenized, err = repo.GetUntokenizedContent(); err != nil || len(untokenized) != 0 {
+		t.Errorf("GetUntokenizedContent() after RegisterTokens() = %v, %v; want [], nil", ids(untokenized), err)
+	}
+}
+
+func testTokenBatches(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	contentIds := []int{}
+	for i := 0; i < 3; i++ {
+		id, err := repo.SaveContent(newContent(fmt.Sprintf("https://news.ltn.com.tw/news/world/breakingnews/300123%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		contentIds = append(contentIds, id)
+	}
+	first, second, third := contentIds[0], contentIds[1], contentIds[2]
+
+	if err := repo.RecordTokenizeFailure(second, "tokenizer panicked"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.RecordTokenizeFailure(third, "tokenizer panicked"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.RecordTokenizeFailure(third, "empty body"); err != nil {
+		t.Fatal(err)
+	}
+	failures, err := repo.GetTokenizeFailures(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tokenizeFailures(failures), []string{
+		fmt.Sprintf("%d: tokenizer panicked (1)", second),
+		fmt.Sprintf("%d: empty body (2)", third),
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetTokenizeFailures() = %v; want %v", got, want)
+	}
+	for _, f := range failures {
+		if f.Failed.IsZero() {
+			t.Errorf("GetTokenizeFailures(): failure of content %d has no time", f.ContentId)
+		}
+	}
+	if err := repo.RecordTokenizeFailure(third+1, "unsaved"); err == nil {
+		t.Errorf("RecordTokenizeFailure(unsaved) = nil; want error")
+	}
+
+	tokens := []*corpus.Word{{Word: "地震", Lexical: true}, {Word: "。", Lexical: false}}
+	others := []*corpus.Word{{Word: "本州", Lexical: true}, {Word: "地震", Lexical: true}}
+
+	// Nothing is recorded if a content is missing
+	err = repo.RegisterTokenBatch([]*repository.TokenizedContent{
+		{ContentId: first, Tokens: tokens},
+		{ContentId: third + 1, Tokens: tokens},
+	})
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("RegisterTokenBatch(unsaved) = %v; want %v", err, sql.ErrNoRows)
+	}
+	if _, err := repo.GetTokens(ctx, first); err != repository.ErrNotTokenized {
+		t.Errorf("GetTokens() after failed RegisterTokenBatch() = _, %v; want %v", err, repository.ErrNotTokenized)
+	}
+
+	if err := repo.RegisterTokens(first, tokens); err != nil {
+		t.Fatal(err)
+	}
+	// Tokenized content is skipped, and repeated content registered once
+	err = repo.RegisterTokenBatch([]*repository.TokenizedContent{
+		{ContentId: first, Tokens: others},
+		{ContentId: second, Tokens: others},
+		{ContentId: second, Tokens: tokens},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.RegisterTokenBatch(nil); err != nil {
+		t.Errorf("RegisterTokenBatch(nil) = %v; want nil", err)
+	}
+	for id, want := range map[int][]*corpus.Word{first: tokens, second: others} {
+		if got, err := repo.GetTokens(ctx, id); err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("GetTokens(%d) = %v, %v; want %v, nil", id, got, err, want)
+		}
+	}
+
+	// Registering tokens clears failures
+	if failures, err = repo.GetTokenizeFailures(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tokenizeFailures(failures), []string{fmt.Sprintf("%d: empty body (2)", third)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetTokenizeFailures() after RegisterTokenBatch() = %v; want %v", got, want)
+	}
+}
+
+// tokenizeFailures formats failures without their times.
+func tokenizeFailures(failures []*repository.TokenizeFailure) []string {
+	formatted := []string{}
+	for _, f := range failures {
+		formatted = append(formatted, fmt.Sprintf("%d: %s (%d)", f.ContentId, f.Error, f.Attempts))
+	}
+	return formatted
+}
+
+func testIterateContent(t *testing.T, repo repository.Repository) {
+	contents := []*content.FetchedContent{
+		newContent("https://news.ltn.com.tw/news/world/breakingnews/3001234", "國際"),
+		newContent("https://news.ltn.com.tw/news/politics/breakingnews/3001235", "政治"),
+		newContent("https://www.cw.com.tw/article/5002959", "政治"),
+		newContent("https://www.cw.com.tw/article/5002960"),
+	}
+	contents[1].Date = "2019-11-27 09:10:00"
+	contents[2].CanonName = "天下雜誌"
+	contents[2].Date = "2019-11-28 00:00:00"
+	contents[3].CanonName = "天下雜誌"
+	contents[3].Language = "en"
+
+	saved := []int{}
+	for _, c := range contents {
+		id, err := repo.SaveContent(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved = append(saved, id)
+	}
+
+	// Content may be tokenized while iterating
+	err := repo.IterateContent(context.Background(), repository.ContentFilter{Source: "天下雜誌"}, func(c *content.FetchedContent) error {
+		return repo.RegisterTokens(c.Id, []*corpus.Word{{Word: "地震", Lexical: true}})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	taipei := time.FixedZone("CST", 8*60*60)
+	tests := []struct {
+		name   string
+		filter repository.ContentFilter
+		want   []int
+	}{
+		{"all", repository.ContentFilter{}, saved},
+		{"source", repository.ContentFilter{Source: "自由時報"}, saved[:2]},
+		{"tag", repository.ContentFilter{Tag: "政治"}, saved[1:3]},
+		{"language", repository.ContentFilter{Language: "en"}, saved[3:]},
+		{"after", repository.ContentFilter{After: time.Date(2019, 11, 26, 15, 42, 0, 0, taipei)}, saved[1:3]},
+		{"before", repository.ContentFilter{Before: time.Date(2019, 11, 28, 0, 0, 0, 0, taipei)}, []int{saved[0], saved[1], saved[3]}},
+		{"tokenized", repository.ContentFilter{Tokenized: repository.IsTokenized}, saved[2:]},
+		{"untokenized", repository.ContentFilter{Tokenized: repository.NotTokenized}, saved[:2]},
+		{"combined", repository.ContentFilter{Source: "天下雜誌", Tag: "政治", Tokenized: repository.IsTokenized}, saved[2:3]},
+		{"none", repository.ContentFilter{Tag: "體育"}, []int{}},
+	}
+
+	for _, test := range tests {
+		got := []int{}
+		err := repo.IterateContent(context.Background(), test.filter, func(c *content.FetchedContent) error {
+			got = append(got, c.Id)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: IterateContent() = %v", test.name, err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: IterateContent() visited %v; want %v", test.name, got, test.want)
+		}
+
+		count, err := repo.CountContent(context.Background(), test.filter)
+		if err != nil || count != len(test.want) {
+			t.Errorf("%s: CountContent() = %d, %v; want %d, nil", test.name, count, err, len(test.want))
+		}
+	}
+
+	// Iteration stops at the first error
+	stop := errors.New("stop")
+	visited := 0
+	err = repo.IterateContent(context.Background(), repository.ContentFilter{}, func(c *content.FetchedContent) error {
+		visited++
+		return stop
+	})
+	if err != stop || visited != 1 {
+		t.Errorf("IterateContent() stopping = %v after %d; want %v after 1", err, visited, stop)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := repo.IterateContent(ctx, repository.ContentFilter{}, func(c *content.FetchedContent) error { return nil }); err == nil {
+		t.Errorf("IterateContent(cancelled) = nil; want error")
+	}
+}
+
+func testQueryContent(t *testing.T, repo repository.Repository) {
+	contents := []*content.FetchedContent{
+		newContent("https://news.ltn.com.tw/news/world/breakingnews/3001234", "國際", "地震"),
+		newContent("https://news.ltn.com.tw/news/politics/breakingnews/3001235", "政治"),
+		newContent("https://www.cw.com.tw/article/5002959", "政治", "國際"),
+		newContent("https://www.cw.com.tw/article/5002960"),
+	}
+	contents[0].Body = "地震。"
+	contents[1].Date = "2019-11-28 09:10:00"
+	contents[1].Author = "編輯"
+	contents[2].CanonName = "天下雜誌"
+	contents[2].Date = "2019-11-27 00:00:00"
+	contents[2].Body = "本次地震發生位置約位於日本本州西部近海，震源深度十公里。"
+	contents[3].CanonName = "天下雜誌"
+	contents[3].Language = "en"
+
+	saved := []int{}
+	for _, c := range contents {
+		id, err := repo.SaveContent(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved = append(saved, id)
+	}
+
+	tests := []struct {
+		name  string
+		query repository.ContentQuery
+		want  []int
+	}{
+		{"all", repository.ContentQuery{}, saved},
+		{"any tags", repository.ContentQuery{ContentFilter: repository.ContentFilter{AnyTags: []string{"地震", "政治"}}}, saved[:3]},
+		{"all tags", repository.ContentQuery{ContentFilter: repository.ContentFilter{AllTags: []string{"國際", "政治"}}}, saved[2:3]},
+		{"author", repository.ContentQuery{ContentFilter: repository.ContentFilter{Author: "編輯"}}, saved[1:2]},
+		{"min length", repository.ContentQuery{ContentFilter: repository.ContentFilter{MinBodyLength: 20}}, saved[1:]},
+		{"max length", repository.ContentQuery{ContentFilter: repository.ContentFilter{MaxBodyLength: 20}}, []int{saved[0], saved[1], saved[3]}},
+		{"length window", repository.ContentQuery{ContentFilter: repository.ContentFilter{MinBodyLength: 3, MaxBodyLength: 3}}, saved[:1]},
+		{"by date", repository.ContentQuery{OrderBy: repository.OrderByDate}, []int{saved[0], saved[3], saved[2], saved[1]}},
+		{"by date descending", repository.ContentQuery{OrderBy: repository.OrderByDate, Descending: true}, []int{saved[1], saved[2], saved[3], saved[0]}},
+		{"by length", repository.ContentQuery{OrderBy: repository.OrderByBodyLength}, []int{saved[0], saved[1], saved[3], saved[2]}},
+		{"limit", repository.ContentQuery{Limit: 2}, saved[:2]},
+		{"offset", repository.ContentQuery{Offset: 3}, saved[3:]},
+		{"page", repository.ContentQuery{Descending: true, Limit: 2, Offset: 1}, []int{saved[2], saved[1]}},
+		{"past end", repository.ContentQuery{Offset: 4}, []int{}},
+		{"combined", repository.ContentQuery{ContentFilter: repository.ContentFilter{Source: "天下雜誌", Language: "zh-TW"}, OrderBy: repository.OrderByDate}, saved[2:3]},
+	}
+
+	for _, test := range tests {
+		got, err := repo.QueryContent(context.Background(), test.query)
+		if err != nil {
+			t.Fatalf("%s: QueryContent() = %v", test.name, err)
+		}
+		if ids := ids(got); !reflect.DeepEqual(ids, test.want) {
+			t.Errorf("%s: QueryContent() = %v; want %v", test.name, ids, test.want)
+		}
+	}
+
+	// Content comes back as it was saved
+	got, err := repo.QueryContent(context.Background(), repository.ContentQuery{ContentFilter: repository.ContentFilter{Source: "天下雜誌"}})
+	if err != nil || len(got) != 2 {
+		t.Fatalf("QueryContent(source) = %d contents, %v; want 2, nil", len(got), err)
+	}
+	want := *contents[2]
+	want.Id = saved[2]
+	want.Tags = []string{"國際", "政治"}
+	if !reflect.DeepEqual(got[0], &want) {
+		t.Errorf("QueryContent(source)[0] = %+v; want %+v", got[0], &want)
+	}
+	if got[1].Language != "en" || len(got[1].Tags) != 0 {
+		t.Errorf("QueryContent(source)[1] = %+v; want language en and no tags", got[1])
+	}
+
+	for _, query := range []repository.ContentQuery{{OrderBy: "title"}, {Limit: -1}} {
+		if _, err := repo.QueryContent(context.Background(), query); err == nil {
+			t.Errorf("QueryContent(%+v) = _, nil; want error", query)
+		}
+	}
+}
+
+func testSearch(t *testing.T, repo repository.Repository) {
+	texts := [][]string{
+		{"日本", "本州", "西部", "近海", "發生", "地震", "。"},
+		{"地震", "後", "，", "台灣", "沒有", "災情", "，", "地震", "。"},
+		{"台灣", "選舉", "。"},
+		nil, // not tokenized
+	}
+
+	saved := []int{}
+	for i, text := range texts {
+		c := newContent(fmt.Sprintf("https://news.ltn.com.tw/news/world/breakingnews/%d", 3001234+i))
+		c.Body = "地震"
+		if i == 2 {
+			c.CanonName = "天下雜誌"
+		}
+		id, err := repo.SaveContent(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved = append(saved, id)
+
+		if text == nil {
+			continue
+		}
+		tokens := []*corpus.Word{}
+		for _, word := range text {
+			tokens = append(tokens, &corpus.Word{Word: word, Lexical: word != "。" && word != "，"})
+		}
+		if err := repo.RegisterTokens(id, tokens); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query  string
+		filter repository.ContentFilter
+		limit  int
+		want   []int
+	}{
+		{"地震", repository.ContentFilter{}, 0, []int{saved[1], saved[0]}}, // more often in the second
+		{"台", repository.ContentFilter{}, 0, []int{}},
+		{`"本州 西部"`, repository.ContentFilter{}, 0, saved[:1]},
+		{`"西部 本州"`, repository.ContentFilter{}, 0, []int{}},
+		{"地震 台灣", repository.ContentFilter{}, 0, saved[1:2]},
+		{"地震 AND 台灣", repository.ContentFilter{}, 0, saved[1:2]},
+		{"地震 OR 選舉", repository.ContentFilter{}, 0, []int{saved[1], saved[2], saved[0]}},
+		{"地震 -台灣", repository.ContentFilter{}, 0, saved[:1]},
+		{"NOT 地震", repository.ContentFilter{}, 0, saved[2:3]},
+		{"(本州 OR 選舉) 台灣", repository.ContentFilter{}, 0, saved[2:3]},
+		{"台灣", repository.ContentFilter{Source: "天下雜誌"}, 0, saved[2:3]},
+		{"地震", repository.ContentFilter{}, 1, saved[1:2]},
+	}
+
+	for _, test := range tests {
+		query, err := repository.ParseSearchQuery(test.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, err := repo.Search(context.Background(), query, test.filter, test.limit)
+		if err != nil {
+			t.Fatalf("Search(%s) = %v", test.query, err)
+		}
+		got := []int{}
+		for _, result := range results {
+			got = append(got, result.Content.Id)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Search(%s) = %v; want %v", test.query, got, test.want)
+		}
+	}
+
+	query, err := repository.ParseSearchQuery(`"本州 西部"`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := repo.Search(context.Background(), query, repository.ContentFilter{}, 0)
+	if err != nil || len(results) != 1 {
+		t.Fatalf("Search() = %d results, %v; want 1, nil", len(results), err)
+	}
+	result := results[0]
+	if result.Content.Title != "本州近海地震" || result.Content.Uri == "" || result.Matches != 1 || result.Score <= 0 {
+		t.Errorf("Search() = %+v, content %+v", result, result.Content)
+	}
+	if got, want := result.Snippet.Highlight("[", "]"), "日本[本州西部]近海發生地震。"; got != want {
+		t.Errorf("Search() snippet = %s; want %s", got, want)
+	}
+}
+
+func testConcordance(t *testing.T, repo repository.Repository) {
+	texts := [][]string{
+		{"日本", "本州", "西部", "近海", "發生", "地震", "。"},
+		{"地震", "後", "，", "台灣", "沒有", "災情", "，", "地震", "。"},
+		nil, // not tokenized
+	}
+
+	saved := []int{}
+	for i, text := range texts {
+		c := newContent(fmt.Sprintf("https://news.ltn.com.tw/news/world/breakingnews/%d", 3001234+i))
+		if i == 1 {
+			c.CanonName = "天下雜誌"
+			c.Date = "2019-11-28 00:00:00"
+		}
+		id, err := repo.SaveContent(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved = append(saved, id)
+
+		if text == nil {
+			continue
+		}
+		tokens := []*corpus.Word{}
+		for _, word := range text {
+			tokens = append(tokens, &corpus.Word{Word: word, Lexical: word != "。" && word != "，"})
+		}
+		if err := repo.RegisterTokens(id, tokens); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	all := []*repository.ConcordanceLine{
+		{ContentId: saved[0], Position: 5, Left: []string{"近海", "發生"}, Word: "地震", Right: []string{"。"}},
+		{ContentId: saved[1], Position: 0, Left: []string{}, Word: "地震", Right: []string{"後", "，"}},
+		{ContentId: saved[1], Position: 7, Left: []string{"災情", "，"}, Word: "地震", Right: []string{"。"}},
+	}
+	tests := []struct {
+		name   string
+		word   string
+		filter repository.ContentFilter
+		want   []*repository.ConcordanceLine
+	}{
+		{"all", "地震", repository.ContentFilter{}, all},
+		{"source", "地震", repository.ContentFilter{Source: "天下雜誌"}, all[1:]},
+		{"after", "地震", repository.ContentFilter{After: time.Date(2019, 11, 27, 0, 0, 0, 0, time.UTC)}, all[1:]},
+		{"before", "地震", repository.ContentFilter{Before: time.Date(2019, 11, 27, 0, 0, 0, 0, time.UTC)}, all[:1]},
+		{"absent", "颱風", repository.ContentFilter{}, []*repository.ConcordanceLine{}},
+	}
+
+	for _, test := range tests {
+		got, err := repo.Concordance(context.Background(), test.word, 2, test.filter)
+		if err != nil {
+			t.Fatalf("%s: Concordance() = %v", test.name, err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: Concordance() = %v; want %v", test.name, got, test.want)
+		}
+	}
+
+	if _, err := repo.Concordance(context.Background(), "地震", -1, repository.ContentFilter{}); err == nil {
+		t.Errorf("Concordance(negative window) = _, nil; want error")
+	}
+}
+
+func testIterateTokens(t *testing.T, repo repository.Repository) {
+	tokens := []*corpus.Word{
+		{Word: "地震", Lexical: true},
+		{Word: "後", Lexical: true},
+		{Word: "。", Lexical: false},
+		{Word: "地震", Lexical: true},
+	}
+
+	saved := []int{}
+	for i := 0; i < 3; i++ {
+		c := newContent(fmt.Sprintf("https://news.ltn.com.tw/news/world/breakingnews/%d", 3001234+i))
+		if i == 1 {
+			c.CanonName = "天下雜誌"
+		}
+		id, err := repo.SaveContent(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved = append(saved, id)
+		if i < 2 { // the last is not tokenized
+			if err := repo.RegisterTokens(id, tokens); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter repository.ContentFilter
+		want   []int
+	}{
+		{"all", repository.ContentFilter{}, saved[:2]},
+		{"source", repository.ContentFilter{Source: "天下雜誌"}, saved[1:2]},
+		{"none", repository.ContentFilter{Tag: "體育"}, []int{}},
+	}
+	for _, test := range tests {
+		got := []int{}
+		err := repo.IterateTokens(context.Background(), test.filter, func(contentId int, words []*corpus.Word) error {
+			if !reflect.DeepEqual(words, tokens) {
+				t.Errorf("%s: IterateTokens() tokens of %d = %v; want %v", test.name, contentId, words, tokens)
+			}
+			got = append(got, contentId)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: IterateTokens() = %v", test.name, err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: IterateTokens() visited %v; want %v", test.name, got, test.want)
+		}
+	}
+
+	stop := errors.New("stop")
+	visited := 0
+	err := repo.IterateTokens(context.Background(), repository.ContentFilter{}, func(int, []*corpus.Word) error {
+		visited++
+		return stop
+	})
+	if err != stop || visited != 1 {
+		t.Errorf("IterateTokens() stopping = %v after %d; want %v after 1", err, visited, stop)
+	}
+}
+
+func testLexicon(t *testing.T, repo repository.Repository) {
+	if err := repo.AddLexemes("test", "zh-TW", []string{"地震", "日本"}, []int{3, 5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.AddLexeme("test", "zh-TW", "本州", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.AddLexeme("test", "zh-TW", "本州", 2); err != nil {
+		t.Errorf("AddLexeme(duplicate) = %v; want nil", err)
+	}
+	if err := repo.AddLexemes("test", "zh-TW", []string{"西部", "地震"}, []int{1, 3}); err == nil {
+		t.Errorf("AddLexemes(duplicate) = nil; want error")
+	}
+	if err := repo.AddLexemes("other", "zh-TW", []string{"地震"}, []int{7}); err != nil {
+		t.Fatal(err)
+	}
+
+	lexemes, frequencies, err := repo.GetLexemes("test", "zh-TW")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(lexemes, []string{"地震", "日本", "本州"}) || !reflect.DeepEqual(frequencies, []int{3, 5, 1}) {
+		t.Errorf("GetLexemes() = %v, %v; want [地震 日本 本州], [3 5 1]", lexemes, frequencies)
+	}
+
+	if _, _, err := repo.GetLexemes("test", "en"); err == nil {
+		t.Errorf("GetLexemes(unknown language) = _, _, nil; want error")
+	}
+	if _, _, err := repo.GetLexemes("missing", "zh-TW"); err == nil {
+		t.Errorf("GetLexemes(unknown lexicon) = _, _, nil; want error")
+	}
+
+	if err := repo.AddLexemes("test", "zh-TW", []string{"日", "日本人"}, []int{2, 9}); err != nil {
+		t.Fatal(err)
+	}
+	// Wildcards of LIKE are matched literally, and case matters
+	if err := repo.AddLexemes("test", "zh-TW", []string{"GPS", "gps", "10%", "100"}, []int{1, 1, 1, 1}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		prefix      string
+		limit       int
+		offset      int
+		lexemes     []string
+		frequencies []int
+	}{
+		{"日", 0, 0, []string{"日", "日本人", "日本"}, []int{2, 9, 5}},
+		{"日本", 0, 0, []string{"日本", "日本人"}, []int{5, 9}},
+		{"日", 2, 0, []string{"日", "日本人"}, []int{2, 9}},
+		{"", 1, 0, []string{"日本人"}, []int{9}},
+		{"東", 0, 0, []string{}, []int{}},
+		{"日", 1, 1, []string{"日本人"}, []int{9}},
+		{"日", 0, 1, []string{"日本人", "日本"}, []int{9, 5}},
+		{"日", 2, 3, []string{}, []int{}},
+		{"G", 0, 0, []string{"GPS"}, []int{1}},
+		{"10%", 0, 0, []string{"10%"}, []int{1}},
+		{"1_", 0, 0, []string{}, []int{}},
+	}
+	for _, test := range tests {
+		lexemes, frequencies, err := repo.FindLexemes(context.Background(), "test", "zh-TW", test.prefix, test.limit, test.offset)
+		if err != nil || !reflect.DeepEqual(lexemes, test.lexemes) || !reflect.DeepEqual(frequencies, test.frequencies) {
+			t.Errorf("FindLexemes(%q, %d, %d) = %v, %v, %v; want %v, %v, nil", test.prefix, test.limit, test.offset, lexemes, frequencies, err, test.lexemes, test.frequencies)
+		}
+	}
+	if _, _, err := repo.FindLexemes(context.Background(), "test", "zh-TW", "日", 1, -1); err == nil {
+		t.Errorf("FindLexemes(negative offset) = _, _, nil; want error")
+	}
+	if _, _, err := repo.FindLexemes(context.Background(), "missing", "zh-TW", "", 0, 0); err != sql.ErrNoRows {
+		t.Errorf("FindLexemes(unknown lexicon) = _, _, %v; want %v", err, sql.ErrNoRows)
+	}
+
+	if frequency, err := repo.GetLexeme(context.Background(), "test", "zh-TW", "日本"); err != nil || frequency != 5 {
+		t.Errorf("GetLexeme(日本) = %d, %v; want 5, nil", frequency, err)
+	}
+	if frequency, err := repo.GetLexeme(context.Background(), "other", "zh-TW", "地震"); err != nil || frequency != 7 {
+		t.Errorf("GetLexeme(other, 地震) = %d, %v; want 7, nil", frequency, err)
+	}
+	for _, lookup := range [][3]string{{"test", "zh-TW", "日本國"}, {"test", "zh-TW", "gPS"}, {"test", "en", "日本"}, {"missing", "zh-TW", "日本"}} {
+		if _, err := repo.GetLexeme(context.Background(), lookup[0], lookup[1], lookup[2]); err != sql.ErrNoRows {
+			t.Errorf("GetLexeme(%q, %q, %q) = _, %v; want %v", lookup[0], lookup[1], lookup[2], err, sql.ErrNoRows)
+		}
+	}
+}
+
+func testTagsAndSources(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	if tags, err := repo.GetTags(ctx); err != nil || len(tags) != 0 {
+		t.Errorf("GetTags() of empty repository = %v, %v; want [], nil", tags, err)
+	}
+
+	contents := []*content.FetchedContent{
+		newContent("https://news.ltn.com.tw/news/world/breakingnews/3001234", "地震", "國際"),
+		newContent("https://news.ltn.com.tw/news/world/breakingnews/3001235", "地震"),
+		newContent("https://www.cw.com.tw/article/5098765"),
+	}
+	contents[2].CanonName = "天下雜誌"
+	for _, c := range contents {
+		if _, err := repo.SaveContent(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wantTags := []repository.NameCount{{Name: "國際", Count: 1}, {Name: "地震", Count: 2}}
+	if tags, err := repo.GetTags(ctx); err != nil || !reflect.DeepEqual(nameCounts(tags), wantTags) {
+		t.Errorf("GetTags() = %v, %v; want %v, nil", nameCounts(tags), err, wantTags)
+	}
+
+	wantSources := []repository.NameCount{{Name: "天下雜誌", Count: 1}, {Name: "自由時報", Count: 2}}
+	if sources, err := repo.GetSources(ctx); err != nil || !reflect.DeepEqual(nameCounts(sources), wantSources) {
+		t.Errorf("GetSources() = %v, %v; want %v, nil", nameCounts(sources), err, wantSources)
+	}
+}
+
+func nameCounts(counts []*repository.NameCount) []repository.NameCount {
+	values := make([]repository.NameCount, len(counts))
+	for i, c := range counts {
+		values[i] = *c
+	}
+	return values
+}
+
+func testStorage(t *testing.T, repo repository.Repository) {
+	if err := repo.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Request ids use all 64 bits
+	var requestId uint64 = 18446744073709551615
+	if err := repo.Visited(requestId); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Visited(requestId); err != nil {
+		t.Errorf("Visited(visited) = %v; want nil", err)
+	}
+	if visited, err := repo.IsVisited(requestId); err != nil || !visited {
+		t.Errorf("IsVisited() = %v, %v; want true, nil", visited, err)
+	}
+	if visited, err := repo.IsVisited(1); err != nil || visited {
+		t.Errorf("IsVisited(unvisited) = %v, %v; want false, nil", visited, err)
+	}
+
+	u, _ := url.Parse("https://news.ltn.com.tw/list/politics")
+	if cookies := repo.Cookies(u); cookies != "" {
+		t.Errorf("Cookies(unset) = %q; want \"\"", cookies)
+	}
+	repo.SetCookies(u, "a=b")
+	repo.SetCookies(u, "a=c")
+	if cookies := repo.Cookies(u); cookies != "a=b" {
+		t.Errorf("Cookies() = %q; want %q", cookies, "a=b")
+	}
+}
+
+func testFetchState(t *testing.T, repo repository.Repository) {
+	if state, err := repo.GetFetchState("liberty"); err != nil || state != nil {
+		t.Errorf("GetFetchState(unsaved) = %q, %v; want nil, nil", state, err)
+	}
+
+	if err := repo.SaveFetchState("liberty", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SaveFetchState("liberty", []byte(`{"queue": []}`)); err != nil {
+		t.Fatal(err)
+	}
+	if state, err := repo.GetFetchState("liberty"); err != nil || string(state) != `{"queue": []}` {
+		t.Errorf("GetFetchState() = %s, %v", state, err)
+	}
+}
+
+func testFetchRuns(t *testing.T, repo repository.Repository) {
+	started := time.Date(2019, 11, 27, 9, 0, 0, 0, time.FixedZone("CST", 8*60*60))
+
+	runs := []*repository.FetchRun{
+		{Fetcher: "liberty", Started: started.Add(time.Hour), Failures: map[string]int{}, Saved: 2},
+		{Fetcher: "liberty", Started: started, Failures: map[string]int{"BODY": 2}, Saved: 3},
+		{Fetcher: "womany", Started: started.Add(-time.Hour), Failures: map[string]int{}},
+	}
+	for _, run := range runs {
+		run.Finished = run.Started.Add(time.Minute)
+		if err := repo.SaveFetchRun(run); err != nil {
+			t.Fatal(err)
+		}
+		if run.Id == 0 {
+			t.Errorf("SaveFetchRun() did not set Id")
+		}
+	}
+
+	got, err := repo.GetFetchRuns(started.Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("GetFetchRuns() = %d runs; want 2", len(got))
+	}
+
+	// Oldest first
+	want := []*repository.FetchRun{runs[1], runs[0]}
+	for i, run := range got {
+		if run.Id != want[i].Id || !run.Started.Equal(want[i].Started) || !run.Finished.Equal(want[i].Finished) ||
+			run.Saved != want[i].Saved || !reflect.DeepEqual(run.Failures, want[i].Failures) {
+			t.Errorf("GetFetchRuns()[%d] = %+v; want %+v", i, run, want[i])
+		}
+	}
+}
+
+func ids(contents []*content.FetchedContent) []int {
+	ids := []int{}
+	for _, c := range contents {
+		ids = append(ids, c.Id)
+	}
+	return ids
+}
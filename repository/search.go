@@ -0,0 +1,621 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/qwwqe/tcsuite/content"
+)
+
+// SearchQuery is a parsed full-text search query. Queries match the token
+// sequences of tokenized content, so words only match on word boundaries.
+//
+// A query is a list of terms, all of which must match. Terms are words,
+// "quoted phrases" of consecutive words, or parenthesised queries; terms
+// may be joined by OR, which binds more loosely than the implicit AND, and
+// negated by NOT or a leading -. An unquoted term that the segmenter splits
+// into several words is matched as a phrase.
+type SearchQuery struct {
+	root   searchNode
+	leaves []*searchLeaf
+}
+
+// ParseSearchQuery parses query, splitting its terms into words with
+// segment. If segment is nil, terms are split on white space only.
+func ParseSearchQuery(query string, segment func(string) []string) (*SearchQuery, error) {
+	if segment == nil {
+		segment = strings.Fields
+	}
+
+	items, err := lexSearchQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, errors.New("empty search query")
+	}
+
+	p := &searchParser{items: items, segment: segment}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.items) {
+		return nil, fmt.Errorf("unexpected %s in search query", p.items[p.pos])
+	}
+
+	root.mark(true)
+	return &SearchQuery{root: root, leaves: p.leaves}, nil
+}
+
+// String returns the query in canonical form, with phrases quoted and
+// groups of alternatives parenthesised.
+func (q *SearchQuery) String() string {
+	return q.root.String()
+}
+
+// candidateWords returns words of which content matched by q contains at
+// least one, or nil if there are none such, as when q is negated.
+func (q *SearchQuery) candidateWords() []string {
+	return q.root.candidates()
+}
+
+// SEARCH RESULTS
+
+// SearchResult is a content matched by a search.
+type SearchResult struct {
+	Content *content.FetchedContent
+	Score   float64 // higher is better
+	Matches int     // occurrences of the terms of the query
+	Snippet Snippet
+}
+
+// Snippet is an excerpt of the text of a search result around its first
+// match.
+type Snippet struct {
+	Text       string
+	Highlights [][2]int // byte offsets in Text of the matched words, in order
+}
+
+// Highlight returns the text of the snippet with each match wrapped in
+// before and after.
+func (s Snippet) Highlight(before string, after string) string {
+	var b strings.Builder
+	last := 0
+	for _, h := range s.Highlights {
+		b.WriteString(s.Text[last:h[0]])
+		b.WriteString(before)
+		b.WriteString(s.Text[h[0]:h[1]])
+		b.WriteString(after)
+		last = h[1]
+	}
+	b.WriteString(s.Text[last:])
+	return b.String()
+}
+
+// Number of words on either side of the first match in a snippet
+var snippetContext = 12
+
+// SearchRanker ranks content matched by a query. It is used by both the
+// SQL and the in-memory repositories, and is exported for other
+// implementations of Repository.
+type SearchRanker struct {
+	query *SearchQuery
+	total int
+	hits  []*searchHit
+	df    []int // number of matched contents in which each term occurs
+}
+
+type searchHit struct {
+	contentId int
+	tf        []int // occurrences of each term
+	snippet   Snippet
+}
+
+// NewSearchRanker returns a ranker for the content matched by query out of
+// total contents searched.
+func NewSearchRanker(query *SearchQuery, total int) *SearchRanker {
+	return &SearchRanker{
+		query: query,
+		total: total,
+		df:    make([]int, len(query.leaves)),
+	}
+}
+
+// Add matches the query against the tokens of the content with the given
+// id, keeping the content if it matches. White space between tokens is
+// ignored.
+func (r *SearchRanker) Add(contentId int, tokens []string) bool {
+	// Indices in tokens of the words, skipping white space
+	positions := make([]int, 0, len(tokens))
+	words := make([]string, 0, len(tokens))
+	for i, token := range tokens {
+		if !isSpace(token) {
+			positions = append(positions, i)
+			words = append(words, token)
+		}
+	}
+
+	occurrences := make([][]int, len(r.query.leaves))
+	for i, leaf := range r.query.leaves {
+		occurrences[i] = leaf.find(words)
+	}
+	if !r.query.root.matches(occurrences) {
+		return false
+	}
+
+	hit := &searchHit{contentId: contentId, tf: make([]int, len(r.query.leaves))}
+	spans := [][2]int{} // of the terms matched, in tokens
+	for i, leaf := range r.query.leaves {
+		if !leaf.positive {
+			continue
+		}
+		hit.tf[i] = len(occurrences[i])
+		if hit.tf[i] > 0 {
+			r.df[i]++
+		}
+		for _, start := range occurrences[i] {
+			spans = append(spans, [2]int{positions[start], positions[start+len(leaf.words)-1] + 1})
+		}
+	}
+	hit.snippet = newSnippet(tokens, spans)
+
+	r.hits = append(r.hits, hit)
+	return true
+}
+
+// Results returns the content matched, best first, at most limit results
+// if limit is positive. Only the Id of the Content of each result is set.
+//
+// Content is scored by tf-idf: each term counts for the logarithm of its
+// occurrences, weighted by how rare the term is among the content matched
+// out of that searched.
+func (r *SearchRanker) Results(limit int) []*SearchResult {
+	total := r.total
+	if total < len(r.hits) {
+		total = len(r.hits)
+	}
+
+	results := make([]*SearchResult, 0, len(r.hits))
+	for _, hit := range r.hits {
+		result := &SearchResult{
+			Content: &content.FetchedContent{Id: hit.contentId},
+			Snippet: hit.snippet,
+		}
+		for i, tf := range hit.tf {
+			if tf == 0 {
+				continue
+			}
+			result.Matches += tf
+			result.Score += (1 + math.Log(float64(tf))) * math.Log(1+float64(total)/float64(r.df[i]))
+		}
+		results = append(results, result)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Content.Id < results[j].Content.Id
+	})
+
+	if limit > 0 && limit < len(results) {
+		results = results[:limit]
+	}
+	return results
+}
+
+// newSnippet excerpts tokens around the first of spans, highlighting the
+// spans within the excerpt. White space is collapsed to single spaces.
+func newSnippet(tokens []string, spans [][2]int) Snippet {
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i][0] < spans[j][0]
+	})
+
+	// The context is counted in words, skipping white space
+	start, end, context := 0, 0, 2*snippetContext
+	if len(spans) > 0 {
+		start, end, context = spans[0][0], spans[0][1], snippetContext
+		for n := 0; start > 0 && n < snippetContext; {
+			start--
+			if !isSpace(tokens[start]) {
+				n++
+			}
+		}
+	}
+	for n := 0; end < len(tokens) && n < context; end++ {
+		if !isSpace(tokens[end]) {
+			n++
+		}
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	offsets := make([]int, end-start+1) // of each token in the text
+	space := false
+	for i := start; i < end; i++ {
+		offsets[i-start] = b.Len()
+		if isSpace(tokens[i]) {
+			if !space && b.Len() > 0 {
+				b.WriteString(" ")
+			}
+			space = true
+			continue
+		}
+		space = false
+		b.WriteString(tokens[i])
+	}
+	offsets[end-start] = b.Len()
+	if end < len(tokens) {
+		b.WriteString("…")
+	}
+
+	snippet := Snippet{Text: b.String(), Highlights: [][2]int{}}
+	for _, span := range spans {
+		if span[0] < start || span[1] > end {
+			continue
+		}
+		h := [2]int{offsets[span[0]-start], offsets[span[1]-start]}
+		// Overlapping matches are highlighted together
+		if n := len(snippet.Highlights); n > 0 && h[0] < snippet.Highlights[n-1][1] {
+			if h[1] > snippet.Highlights[n-1][1] {
+				snippet.Highlights[n-1][1] = h[1]
+			}
+			continue
+		}
+		snippet.Highlights = append(snippet.Highlights, h)
+	}
+	return snippet
+}
+
+// QUERY TREE
+
+type searchNode interface {
+	// matches reports whether content in which each leaf occurs at the
+	// given positions is matched
+	matches(occurrences [][]int) bool
+	// mark marks the leaves that are not negated as positive
+	mark(positive bool)
+	candidates() []string
+	String() string
+}
+
+// searchLeaf is a word or phrase of the query.
+type searchLeaf struct {
+	index    int
+	words    []string
+	positive bool // not negated, so counted in scores and highlighted
+}
+
+func (l *searchLeaf) matches(occurrences [][]int) bool {
+	return len(occurrences[l.index]) > 0
+}
+
+func (l *searchLeaf) mark(positive bool) {
+	l.positive = positive
+}
+
+func (l *searchLeaf) candidates() []string {
+	return []string{l.words[0]}
+}
+
+func (l *searchLeaf) String() string {
+	if len(l.words) == 1 {
+		return l.words[0]
+	}
+	return `"` + strings.Join(l.words, " ") + `"`
+}
+
+// find returns the positions in words at which the leaf occurs.
+func (l *searchLeaf) find(words []string) []int {
+	positions := []int{}
+	for i := 0; i+len(l.words) <= len(words); i++ {
+		match := true
+		for j, word := range l.words {
+			if words[i+j] != word {
+				match = false
+				break
+			}
+		}
+		if match {
+			positions = append(positions, i)
+		}
+	}
+	return positions
+}
+
+type searchAnd []searchNode
+
+func (n searchAnd) matches(occurrences [][]int) bool {
+	for _, child := range n {
+		if !child.matches(occurrences) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n searchAnd) mark(positive bool) {
+	for _, child := range n {
+		child.mark(positive)
+	}
+}
+
+// candidates returns the fewest candidates of any of the children, all of
+// which must match.
+func (n searchAnd) candidates() []string {
+	var best []string
+	for _, child := range n {
+		if c := child.candidates(); c != nil && (best == nil || len(c) < len(best)) {
+			best = c
+		}
+	}
+	return best
+}
+
+func (n searchAnd) String() string {
+	terms := make([]string, len(n))
+	for i, child := range n {
+		terms[i] = child.String()
+	}
+	return strings.Join(terms, " ")
+}
+
+type searchOr []searchNode
+
+func (n searchOr) matches(occurrences [][]int) bool {
+	for _, child := range n {
+		if child.matches(occurrences) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n searchOr) mark(positive bool) {
+	for _, child := range n {
+		child.mark(positive)
+	}
+}
+
+func (n searchOr) candidates() []string {
+	words := []string{}
+	for _, child := range n {
+		c := child.candidates()
+		if c == nil {
+			return nil
+		}
+		words = append(words, c...)
+	}
+	return words
+}
+
+func (n searchOr) String() string {
+	terms := make([]string, len(n))
+	for i, child := range n {
+		terms[i] = child.String()
+	}
+	return "(" + strings.Join(terms, " OR ") + ")"
+}
+
+type searchNot struct {
+	child searchNode
+}
+
+func (n searchNot) matches(occurrences [][]int) bool {
+	return !n.child.matches(occurrences)
+}
+
+func (n searchNot) mark(positive bool) {
+	n.child.mark(!positive)
+}
+
+func (n searchNot) candidates() []string {
+	return nil
+}
+
+func (n searchNot) String() string {
+	return "-" + n.child.String()
+}
+
+// PARSING
+
+type searchItemKind int
+
+const (
+	searchWord searchItemKind = iota
+	searchPhrase
+	searchOpen
+	searchClose
+	searchAndOp
+	searchOrOp
+	searchNotOp
+)
+
+type searchItem struct {
+	kind searchItemKind
+	text string
+}
+
+func (i searchItem) String() string {
+	if i.kind == searchPhrase {
+		return `"` + i.text + `"`
+	}
+	return i.text
+}
+
+// lexSearchQuery splits query into words, phrases, parentheses and
+// operators.
+func lexSearchQuery(query string) ([]searchItem, error) {
+	items := []searchItem{}
+	for i := 0; i < len(query); {
+		r, width := utf8.DecodeRuneInString(query[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += width
+		case r == '(':
+			items = append(items, searchItem{kind: searchOpen, text: "("})
+			i += width
+		case r == ')':
+			items = append(items, searchItem{kind: searchClose, text: ")"})
+			i += width
+		case r == '-':
+			items = append(items, searchItem{kind: searchNotOp, text: "-"})
+			i += width
+		case r == '"':
+			end := strings.IndexByte(query[i+1:], '"')
+			if end < 0 {
+				return nil, errors.New("unterminated phrase in search query")
+			}
+			items = append(items, searchItem{kind: searchPhrase, text: query[i+1 : i+1+end]})
+			i += end + 2
+		default:
+			end := strings.IndexFunc(query[i:], func(r rune) bool {
+				return unicode.IsSpace(r) || r == '(' || r == ')' || r == '"'
+			})
+			if end < 0 {
+				end = len(query) - i
+			}
+			word := query[i : i+end]
+			switch word {
+			case "AND":
+				items = append(items, searchItem{kind: searchAndOp, text: word})
+			case "OR":
+				items = append(items, searchItem{kind: searchOrOp, text: word})
+			case "NOT":
+				items = append(items, searchItem{kind: searchNotOp, text: word})
+			default:
+				items = append(items, searchItem{kind: searchWord, text: word})
+			}
+			i += end
+		}
+	}
+	return items, nil
+}
+
+type searchParser struct {
+	items   []searchItem
+	pos     int
+	segment func(string) []string
+	leaves  []*searchLeaf
+}
+
+func (p *searchParser) peek() (searchItem, bool) {
+	if p.pos >= len(p.items) {
+		return searchItem{}, false
+	}
+	return p.items[p.pos], true
+}
+
+// parseOr parses alternatives: and ("OR" and)*
+func (p *searchParser) parseOr() (searchNode, error) {
+	alternatives := searchOr{}
+	for {
+		node, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		alternatives = append(alternatives, node)
+
+		if item, ok := p.peek(); !ok || item.kind != searchOrOp {
+			break
+		}
+		p.pos++
+	}
+
+	if len(alternatives) == 1 {
+		return alternatives[0], nil
+	}
+	return alternatives, nil
+}
+
+// parseAnd parses required terms: unary (["AND"] unary)*
+func (p *searchParser) parseAnd() (searchNode, error) {
+	terms := searchAnd{}
+	for {
+		item, ok := p.peek()
+		if ok && item.kind == searchAndOp && len(terms) > 0 {
+			p.pos++
+			item, ok = p.peek()
+		} else if !ok || item.kind == searchOrOp || item.kind == searchClose {
+			break
+		}
+		if !ok {
+			return nil, errors.New("unexpected end of search query")
+		}
+
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, node)
+	}
+
+	switch len(terms) {
+	case 0:
+		if item, ok := p.peek(); ok {
+			return nil, fmt.Errorf("unexpected %s in search query", item)
+		}
+		return nil, errors.New("unexpected end of search query")
+	case 1:
+		return terms[0], nil
+	}
+	return terms, nil
+}
+
+// parseUnary parses a possibly negated term: ("NOT" | "-") unary | term
+func (p *searchParser) parseUnary() (searchNode, error) {
+	item, ok := p.peek()
+	if !ok {
+		return nil, errors.New("unexpected end of search query")
+	}
+
+	switch item.kind {
+	case searchNotOp:
+		p.pos++
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return searchNot{child: child}, nil
+	case searchOpen:
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if item, ok := p.peek(); !ok || item.kind != searchClose {
+			return nil, errors.New("unbalanced parentheses in search query")
+		}
+		p.pos++
+		return node, nil
+	case searchWord:
+		p.pos++
+		return p.leaf(item, p.segment(item.text))
+	case searchPhrase:
+		p.pos++
+		words := []string{}
+		for _, field := range strings.Fields(item.text) {
+			words = append(words, p.segment(field)...)
+		}
+		return p.leaf(item, words)
+	}
+	return nil, fmt.Errorf("unexpected %s in search query", item)
+}
+
+func (p *searchParser) leaf(item searchItem, words []string) (searchNode, error) {
+	if len(words) == 0 {
+		return nil, fmt.Errorf("no words in %s in search query", item)
+	}
+
+	leaf := &searchLeaf{index: len(p.leaves), words: words}
+	p.leaves = append(p.leaves, leaf)
+	return leaf, nil
+}
@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	// Splits terms into single characters, as a stand-in for a tokenizer
+	segment := func(term string) []string {
+		return strings.Split(term, "")
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"地震", `"地 震"`},
+		{"地 震", "地 震"},
+		{`"本州 西部"`, `"本 州 西 部"`},
+		{"a OR b c", "(a OR b c)"},
+		{"a AND b OR NOT c", "(a b OR -c)"},
+		{"-a (b OR c)", "-a (b OR c)"},
+		{"COVID-19", `"C O V I D - 1 9"`},
+		{"a　b", "a b"}, // ideographic space
+	}
+	for _, test := range tests {
+		q, err := ParseSearchQuery(test.query, segment)
+		if err != nil {
+			t.Errorf("ParseSearchQuery(%s) = %v", test.query, err)
+			continue
+		}
+		if got := q.String(); got != test.want {
+			t.Errorf("ParseSearchQuery(%s) = %s; want %s", test.query, got, test.want)
+		}
+	}
+
+	for _, query := range []string{"", "  ", `"a`, `""`, "(a", "a)", "a OR", "OR a", "a AND", "NOT", "()"} {
+		if q, err := ParseSearchQuery(query, nil); err == nil {
+			t.Errorf("ParseSearchQuery(%q) = %s, nil; want error", query, q)
+		}
+	}
+}
+
+func TestSearchQueryCandidates(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{`"a b"`, []string{"a"}},
+		{"a OR b", []string{"a", "b"}},
+		{"(a OR b) c", []string{"c"}},
+		{"-a b", []string{"b"}},
+		{"-a", nil},
+		{"a OR -b", nil},
+	}
+	for _, test := range tests {
+		q, err := ParseSearchQuery(test.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := q.candidateWords()
+		if strings.Join(got, ",") != strings.Join(test.want, ",") || (got == nil) != (test.want == nil) {
+			t.Errorf("candidateWords(%s) = %q; want %q", test.query, got, test.want)
+		}
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	defer func(context int) { snippetContext = context }(snippetContext)
+	snippetContext = 2
+
+	tokens := []string{"a", " ", "b", "\n\n", "c", " ", "d", " ", "e", " ", "f", " ", "g", " ", "h"}
+	tests := []struct {
+		spans [][2]int
+		want  string
+	}{
+		{[][2]int{{6, 7}}, "…b c [d] e f…"},
+		{[][2]int{{2, 3}, {4, 5}}, "a [b] [c] d…"},
+		{[][2]int{{2, 5}, {4, 7}}, "a [b c d] e…"},
+		{[][2]int{{12, 13}, {14, 15}}, "…e f [g] [h]"},
+		{nil, "a b c d…"},
+	}
+	for _, test := range tests {
+		if got := newSnippet(tokens, test.spans).Highlight("[", "]"); got != test.want {
+			t.Errorf("newSnippet(%v) = %q; want %q", test.spans, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/qwwqe/tcsuite/content"
+	"github.com/qwwqe/tcsuite/entities/corpus"
+)
+
+// TestRequestHistory checks that the request history outlives the
+// repository, to resume a crawl, until it is cleared.
+func TestRequestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "corpus.db")
+
+	repo, err := NewRepository(RepositoryOptions{Driver: SQLite, Dsn: path, AutoMigrate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.Visited(1)
+	repo.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err = NewRepository(RepositoryOptions{Driver: SQLite, Dsn: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+	if visited, err := repo.IsVisited(1); err != nil || !visited {
+		t.Errorf("IsVisited() after reopening = %v, %v; want true, nil", visited, err)
+	}
+
+	if err := repo.ClearRequestHistory(); err != nil {
+		t.Fatal(err)
+	}
+	if visited, err := repo.IsVisited(1); err != nil || visited {
+		t.Errorf("IsVisited() after ClearRequestHistory() = %v, %v; want false, nil", visited, err)
+	}
+}
+
+func TestSQLiteMigrator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "corpus.db")
+
+	m, err := NewMigrator(RepositoryOptions{Driver: SQLite, Dsn: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	if _, err := NewRepository(RepositoryOptions{Driver: SQLite, Dsn: path}); err == nil {
+		t.Errorf("NewRepository() with pending migrations = _, nil; want error")
+	}
+
+	total := len(m.migrations)
+	if n, err := m.Migrate(); err != nil || n != total {
+		t.Fatalf("Migrate() = %d, %v; want %d, nil", n, err, total)
+	}
+	if n, err := m.Migrate(); err != nil || n != 0 {
+		t.Errorf("Migrate() again = %d, %v; want 0, nil", n, err)
+	}
+
+	if n, err := m.Rollback(total); err != nil || n != total {
+		t.Fatalf("Rollback() = %d, %v; want %d, nil", n, err, total)
+	}
+	if pending, err := m.Pending(); err != nil || len(pending) != total {
+		t.Errorf("Pending() after Rollback() = %v, %v", pending, err)
+	}
+	if n, err := m.Migrate(); err != nil || n != total {
+		t.Errorf("Migrate() after Rollback() = %d, %v; want %d, nil", n, err, total)
+	}
+
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if status.Applied.IsZero() {
+			t.Errorf("Status(): migration %04d_%s pending", status.Version, status.Name)
+		}
+	}
+}
+
+// TestIterateContentPages checks that content is iterated over page by
+// page, even as the content of earlier pages changes.
+func TestIterateContentPages(t *testing.T) {
+	defer func(size int) { contentPageSize = size }(contentPageSize)
+	contentPageSize = 2
+
+	repo, err := NewRepository(RepositoryOptions{Driver: SQLite, Dsn: filepath.Join(t.TempDir(), "corpus.db"), AutoMigrate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+
+	for i := 0; i < 5; i++ {
+		_, err := repo.SaveContent(&content.FetchedContent{
+			Title:    "title",
+			Date:     "2019-11-26 15:42:00",
+			Body:     "body",
+			Uri:      fmt.Sprintf("https://example.com/%d", i),
+			Language: "en",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	visited := 0
+	err = repo.IterateContent(context.Background(), ContentFilter{Tokenized: NotTokenized}, func(c *content.FetchedContent) error {
+		visited++
+		return repo.RegisterTokens(c.Id, []*corpus.Word{{Word: "body", Lexical: true}})
+	})
+	if err != nil || visited != 5 {
+		t.Errorf("IterateContent() = %v after %d; want nil after 5", err, visited)
+	}
+}
+
+// TestConcurrentRegisterTokens checks that contents sharing words may be
+// tokenized concurrently.
+func TestConcurrentRegisterTokens(t *testing.T) {
+	repo, err := NewRepository(RepositoryOptions{Driver: SQLite, Dsn: filepath.Join(t.TempDir(), "corpus.db"), AutoMigrate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+
+	ids := []int{}
+	for i := 0; i < 20; i++ {
+		id, err := repo.SaveContent(&content.FetchedContent{
+			Title:    "title",
+			Body:     "body",
+			Uri:      fmt.Sprintf("https://example.com/%d", i),
+			Language: "en",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(ids))
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id int) {
+			defer wg.Done()
+			errs <- repo.RegisterTokens(id, []*corpus.Word{
+				{Word: "shared", Lexical: true},
+				{Word: fmt.Sprintf("own%d", i), Lexical: true},
+			})
+		}(i, id)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	for i, id := range ids {
+		tokens, err := repo.GetTokens(context.Background(), id)
+		if err != nil || len(tokens) != 2 || tokens[0].Word != "shared" || tokens[1].Word != fmt.Sprintf("own%d", i) {
+			t.Errorf("GetTokens(%d) = %v, %v", id, tokens, err)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package server
+
+import (
+	"database/sql"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/qwwqe/tcsuite/content"
+	"github.com/qwwqe/tcsuite/repository"
+)
+
+// articleSummary is an article as listed, without its body.
+type articleSummary struct {
+	Id       int      `json:"id"`
+	Title    string   `json:"title"`
+	Date     string   `json:"date"`
+	Author   string   `json:"author"`
+	Abstract string   `json:"abstract"`
+	Tags     []string `json:"tags"`
+	Source   string   `json:"source"`
+	Uri      string   `json:"uri"`
+	Language string   `json:"language"`
+}
+
+type article struct {
+	articleSummary
+	Body string `json:"body"`
+}
+
+type articlePage struct {
+	Total    int               `json:"total"` // articles matched
+	Limit    int               `json:"limit"`
+	Offset   int               `json:"offset"`
+	Articles []*articleSummary `json:"articles"`
+}
+
+type token struct {
+	Word    string `json:"word"`
+	Lexical bool   `json:"lexical"`
+}
+
+type tokens struct {
+	Id     int      `json:"id"`
+	Tokens []*token `json:"tokens"`
+}
+
+type nameCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"` // articles
+}
+
+type entry struct {
+	Lexeme    string `json:"lexeme"`
+	Frequency int    `json:"frequency"`
+}
+
+type entryPage struct {
+	Lexicon  string   `json:"lexicon"`
+	Language string   `json:"language"`
+	Prefix   string   `json:"prefix"`
+	Limit    int      `json:"limit"`
+	Offset   int      `json:"offset"`
+	Entries  []*entry `json:"entries"`
+}
+
+func summarize(c *content.FetchedContent) *articleSummary {
+	return &articleSummary{
+		Id:       c.Id,
+		Title:    c.Title,
+		Date:     c.Date,
+		Author:   c.Author,
+		Abstract: c.Abstract,
+		Tags:     c.Tags,
+		Source:   c.CanonName,
+		Uri:      c.Uri,
+		Language: c.Language,
+	}
+}
+
+// listArticles lists the articles matched by the query parameters, as
+// parsed by contentQuery.
+func (s *Server) listArticles(req *http.Request) (interface{}, error) {
+	query, err := contentQuery(req.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := s.repo.CountContent(req.Context(), query.ContentFilter)
+	if err != nil {
+		return nil, err
+	}
+	contents, err := s.repo.QueryContent(req.Context(), query)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &articlePage{
+		Total:    total,
+		Limit:    query.Limit,
+		Offset:   query.Offset,
+		Articles: make([]*articleSummary, len(contents)),
+	}
+	for i, c := range contents {
+		page.Articles[i] = summarize(c)
+	}
+	return page, nil
+}
+
+func (s *Server) getArticle(req *http.Request, id string) (interface{}, error) {
+	contentId, err := articleId(id)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := s.repo.GetFetchedContent(contentId)
+	if err != nil {
+		return nil, err
+	}
+	return &article{articleSummary: *summarize(c), Body: c.Body}, nil
+}
+
+func (s *Server) getTokens(req *http.Request, id string) (interface{}, error) {
+	contentId, err := articleId(id)
+	if err != nil {
+		return nil, err
+	}
+
+	words, err := s.repo.GetTokens(req.Context(), contentId)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &tokens{Id: contentId, Tokens: make([]*token, len(words))}
+	for i, w := range words {
+		result.Tokens[i] = &token{Word: w.Word, Lexical: w.Lexical}
+	}
+	return result, nil
+}
+
+func (s *Server) listTags(req *http.Request) (interface{}, error) {
+	tags, err := s.repo.GetTags(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]*nameCount{"tags": nameCounts(tags)}, nil
+}
+
+func (s *Server) listSources(req *http.Request) (interface{}, error) {
+	sources, err := s.repo.GetSources(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]*nameCount{"sources": nameCounts(sources)}, nil
+}
+
+func nameCounts(counts []*repository.NameCount) []*nameCount {
+	result := make([]*nameCount, len(counts))
+	for i, c := range counts {
+		result[i] = &nameCount{Name: c.Name, Count: c.Count}
+	}
+	return result
+}
+
+// listEntries lists the entries of a lexicon beginning with the prefix
+// parameter, the entry equal to it first, then by frequency.
+func (s *Server) listEntries(req *http.Request, language string, name string) (interface{}, error) {
+	values := req.URL.Query()
+	limit, offset, err := pagination(values)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := values.Get("prefix")
+	lexemes, frequencies, err := s.repo.FindLexemes(req.Context(), name, language, prefix, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &entryPage{
+		Lexicon:  name,
+		Language: language,
+		Prefix:   prefix,
+		Limit:    limit,
+		Offset:   offset,
+		Entries:  []*entry{},
+	}
+	for i := range lexemes {
+		page.Entries = append(page.Entries, &entry{Lexeme: lexemes[i], Frequency: frequencies[i]})
+	}
+	return page, nil
+}
+
+func (s *Server) getEntry(req *http.Request, language string, name string, lexeme string) (interface{}, error) {
+	frequency, err := s.repo.GetLexeme(req.Context(), name, language, lexeme)
+	if err == sql.ErrNoRows {
+		return nil, notFound("no entry %q in lexicon %q", lexeme, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &entry{Lexeme: lexeme, Frequency: frequency}, nil
+}
+
+// PARAMETERS
+
+func articleId(id string) (int, error) {
+	contentId, err := strconv.Atoi(id)
+	if err != nil || contentId < 1 {
+		return 0, badRequest("invalid article id %q", id)
+	}
+	return contentId, nil
+}
+
+// contentQuery parses the parameters filtering, ordering and paginating
+// articles:
+//
+//	source, author, language  exact match
+//	tag                       repeated for articles with every tag
+//	any_tag                   repeated for articles with any of the tags
+//	after, before             publication date, YYYY-MM-DD, exclusive
+//	min_length, max_length    length of the body in characters
+//	tokenized                 true or false
+//	sort                      id (the default), date or length
+//	order                     asc (the default) or desc
+//	limit, offset             as parsed by pagination
+func contentQuery(values url.Values) (repository.ContentQuery, error) {
+	var query repository.ContentQuery
+	query.Source = values.Get("source")
+	query.Author = values.Get("author")
+	query.Language = values.Get("language")
+	query.AllTags = values["tag"]
+	query.AnyTags = values["any_tag"]
+
+	var err error
+	for key, date := range map[string]*time.Time{"after": &query.After, "before": &query.Before} {
+		if v := values.Get(key); v != "" {
+			if *date, err = time.Parse("2006-01-02", v); err != nil {
+				return query, badRequest("invalid %s: %q is not a date (YYYY-MM-DD)", key, v)
+			}
+		}
+	}
+	for key, length := range map[string]*int{"min_length": &query.MinBodyLength, "max_length": &query.MaxBodyLength} {
+		if *length, err = intParameter(values, key, 0); err != nil {
+			return query, err
+		}
+	}
+
+	switch v := values.Get("tokenized"); v {
+	case "":
+	case "true":
+		query.Tokenized = repository.IsTokenized
+	case "false":
+		query.Tokenized = repository.NotTokenized
+	default:
+		return query, badRequest("invalid tokenized: %q; want true or false", v)
+	}
+
+	switch v := repository.ContentOrder(values.Get("sort")); v {
+	case "", repository.OrderById, repository.OrderByDate, repository.OrderByBodyLength:
+		query.OrderBy = v
+	default:
+		return query, badRequest("invalid sort: %q; want id, date or length", v)
+	}
+	switch v := values.Get("order"); v {
+	case "", "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		return query, badRequest("invalid order: %q; want asc or desc", v)
+	}
+
+	query.Limit, query.Offset, err = pagination(values)
+	return query, err
+}
+
+// pagination parses the limit parameter, from 1 to MaxLimit and
+// DefaultLimit if absent, and the offset parameter.
+func pagination(values url.Values) (limit int, offset int, err error) {
+	if limit, err = intParameter(values, "limit", DefaultLimit); err != nil {
+		return 0, 0, err
+	}
+	if limit < 1 || limit > MaxLimit {
+		return 0, 0, badRequest("invalid limit: %d; want 1 to %d", limit, MaxLimit)
+	}
+	if offset, err = intParameter(values, "offset", 0); err != nil {
+		return 0, 0, err
+	}
+	// The databases add the limit to the offset, which must not overflow
+	if offset > math.MaxInt-limit {
+		return 0, 0, badRequest("invalid offset: %d; want at most %d", offset, math.MaxInt-limit)
+	}
+	return limit, offset, nil
+}
+
+// intParameter parses the non-negative integer parameter key, or returns
+// def if it is absent.
+func intParameter(values url.Values, key string, def int) (int, error) {
+	v := values.Get(key)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, badRequest("invalid %s: %q", key, v)
+	}
+	return n, nil
+}
@@ -0,0 +1,257 @@
+// Package server serves the content of a repository, its tokens and the
+// lexica over an HTTP JSON API, described by the OpenAPI document served at
+// /openapi.json. Given a lexicon, it also tokenizes text as the corpus was
+// tokenized.
+//
+// Responses carry an ETag computed from their body, so that clients may
+// revalidate them with If-None-Match rather than download them again.
+package server
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+
+	"github.com/qwwqe/tcsuite/lexicon"
+	"github.com/qwwqe/tcsuite/logging"
+	"github.com/qwwqe/tcsuite/repository"
+)
+
+//go:embed openapi.json
+var openAPI []byte
+
+// Number of articles and lexicon entries listed per page by default, and at
+// most
+var (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+// Server is an http.Handler serving the API over a repository.
+type Server struct {
+	repo       repository.Repository
+	logger     *slog.Logger
+	lexicon    lexicon.Lexicon
+	maxDepth   int
+	tokenizing chan struct{} // a semaphore bounding the texts tokenized at once
+}
+
+// Options configures a Server.
+type Options struct {
+	// Requests failing for other reasons than the request itself are
+	// logged to Logger
+	Logger *slog.Logger
+
+	// Lexicon with which text is tokenized by POST /tokenize, which is not
+	// served if nil. It is shared by all requests, and must not be modified
+	// while the server runs.
+	Lexicon  lexicon.Lexicon
+	MaxDepth int // of the tokenizer, unless given by the request
+
+	// Texts tokenized at once, as tokenization is bound by the CPU; further
+	// requests wait their turn. runtime.NumCPU() if 0.
+	Tokenizers int
+}
+
+// New returns a server of the content of repo.
+func New(repo repository.Repository, options Options) *Server {
+	maxDepth := options.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxDepth
+	}
+	tokenizers := options.Tokenizers
+	if tokenizers <= 0 {
+		tokenizers = runtime.NumCPU()
+	}
+	return &Server{
+		repo:       repo,
+		logger:     logging.Component(options.Logger, "server"),
+		lexicon:    options.Lexicon,
+		maxDepth:   maxDepth,
+		tokenizing: make(chan struct{}, tokenizers),
+	}
+}
+
+// httpError is an error to be reported with the given status.
+type httpError struct {
+	status int
+	err    error
+	allow  []string // methods allowed, for StatusMethodNotAllowed
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &httpError{status: http.StatusBadRequest, err: fmt.Errorf(format, args...)}
+}
+
+func notFound(format string, args ...interface{}) error {
+	return &httpError{status: http.StatusNotFound, err: fmt.Errorf(format, args...)}
+}
+
+func methodNotAllowed(allow ...string) error {
+	return &httpError{status: http.StatusMethodNotAllowed, err: errors.New("method not allowed"), allow: allow}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := s.route(req)
+	if err != nil {
+		s.writeError(w, req, err)
+		return
+	}
+	s.writeJSON(w, req, body)
+}
+
+// route calls the handler of the path of req, returning the body of the
+// response.
+func (s *Server) route(req *http.Request) (interface{}, error) {
+	segments, err := pathSegments(req.URL)
+	if err != nil {
+		return nil, badRequest("invalid path: %v", err)
+	}
+
+	if match(segments, "tokenize") {
+		if req.Method != http.MethodPost {
+			return nil, methodNotAllowed(http.MethodPost)
+		}
+		return s.tokenize(req)
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return nil, methodNotAllowed(http.MethodGet, http.MethodHead)
+	}
+
+	switch {
+	case match(segments, "openapi.json"):
+		return json.RawMessage(openAPI), nil
+	case match(segments, "articles"):
+		return s.listArticles(req)
+	case match(segments, "articles", "*"):
+		return s.getArticle(req, segments[1])
+	case match(segments, "articles", "*", "tokens"):
+		return s.getTokens(req, segments[1])
+	case match(segments, "tags"):
+		return s.listTags(req)
+	case match(segments, "sources"):
+		return s.listSources(req)
+	case match(segments, "lexica", "*", "*", "entries"):
+		return s.listEntries(req, segments[1], segments[2])
+	case match(segments, "lexica", "*", "*", "entries", "*"):
+		return s.getEntry(req, segments[1], segments[2], segments[4])
+	}
+	return nil, notFound("no such resource")
+}
+
+// pathSegments returns the unescaped segments of the path of u, without
+// the leading and trailing slashes.
+func pathSegments(u *url.URL) ([]string, error) {
+	path := strings.Trim(u.EscapedPath(), "/")
+	if path == "" {
+		return []string{}, nil
+	}
+
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		var err error
+		if segments[i], err = url.PathUnescape(segment); err != nil {
+			return nil, err
+		}
+	}
+	return segments, nil
+}
+
+// match reports whether segments follow pattern, where "*" matches any
+// non-empty segment.
+func match(segments []string, pattern ...string) bool {
+	if len(segments) != len(pattern) {
+		return false
+	}
+	for i, p := range pattern {
+		if segments[i] == "" || (p != "*" && p != segments[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// writeJSON writes body as JSON, or no body at all if the client holds it
+// already, as given by If-None-Match. Only the responses to GET and HEAD
+// are tagged.
+func (s *Server) writeJSON(w http.ResponseWriter, req *http.Request, body interface{}) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		s.writeError(w, req, err)
+		return
+	}
+
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write(append(data, '\n'))
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	etag := fmt.Sprintf("\"%x\"", sum[:16])
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if ifNoneMatch := req.Header.Get("If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(append(data, '\n'))
+}
+
+// etagMatches reports whether the If-None-Match header value header lists
+// etag, weakly compared.
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeError writes err as a JSON object, with the status of err if it is
+// an httpError. Other errors are logged.
+func (s *Server) writeError(w http.ResponseWriter, req *http.Request, err error) {
+	status := http.StatusInternalServerError
+	message := "internal server error"
+
+	var httpErr *httpError
+	switch {
+	case errors.As(err, &httpErr):
+		status = httpErr.status
+		message = httpErr.Error()
+		if len(httpErr.allow) > 0 {
+			w.Header().Set("Allow", strings.Join(httpErr.allow, ", "))
+		}
+	case errors.Is(err, sql.ErrNoRows):
+		status = http.StatusNotFound
+		message = "not found"
+	case errors.Is(err, repository.ErrNotTokenized):
+		status = http.StatusNotFound
+		message = err.Error()
+	default:
+		s.logger.Error("error serving request", logging.UrlKey, req.URL.String(), logging.ErrorKey, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{message})
+}
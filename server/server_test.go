@@ -0,0 +1,342 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/qwwqe/tcsuite/content"
+	"github.com/qwwqe/tcsuite/entities/corpus"
+	"github.com/qwwqe/tcsuite/lexicon"
+	"github.com/qwwqe/tcsuite/repository"
+	"github.com/qwwqe/tcsuite/repository/memory"
+)
+
+// newServer returns a test server of three articles, the last of which is
+// not tokenized, and of a lexicon "test" in zh-TW, with which it tokenizes
+// text.
+func newServer(t *testing.T) *httptest.Server {
+	repo := memory.New(repository.RepositoryOptions{})
+	articles := []struct {
+		source string
+		date   string
+		tags   []string
+		text   string
+	}{
+		{"自由時報", "2019-11-26 15:42:00", []string{"地震", "國際"}, "本次 地震 。"},
+		{"自由時報", "2019-11-27 09:00:00", []string{"地震"}, "日本 本州 西部 近海 地震 。"},
+		{"天下雜誌", "2019-11-28 12:30:00", nil, "地震"},
+	}
+	for i, a := range articles {
+		id, err := repo.SaveContent(&content.FetchedContent{
+			Title:     fmt.Sprintf("title %d", i+1),
+			Date:      a.date,
+			Body:      strings.ReplaceAll(a.text, " ", ""),
+			Tags:      a.tags,
+			CanonName: a.source,
+			Uri:       fmt.Sprintf("https://example.com/%d", i+1),
+			Language:  "zh-TW",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 2 {
+			continue
+		}
+
+		tokens := []*corpus.Word{}
+		for _, word := range strings.Fields(a.text) {
+			tokens = append(tokens, &corpus.Word{Word: word, Lexical: word != "。"})
+		}
+		if err := repo.RegisterTokens(id, tokens); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := repo.AddLexemes("test", "zh-TW", []string{"地震", "日本", "日", "日本人"}, []int{3, 5, 2, 9})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lex := lexicon.NewZhTwLexicon("test", "zh-TW")
+	if err := lex.LoadRepository(repo); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	server := httptest.NewServer(New(repo, Options{Logger: logger, Lexicon: lex}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// get requests path from server, decoding the body of the response into
+// v if the request succeeds.
+func get(t *testing.T, server *httptest.Server, path string, v interface{}) *http.Response {
+	t.Helper()
+	resp, err := http.Get(server.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK && v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+	}
+	return resp
+}
+
+func TestListArticles(t *testing.T) {
+	server := newServer(t)
+
+	tests := []struct {
+		query string
+		total int
+		want  []int
+	}{
+		{"", 3, []int{1, 2, 3}},
+		{"?source=" + url.QueryEscape("自由時報"), 2, []int{1, 2}},
+		{"?tag=" + url.QueryEscape("地震") + "&tag=" + url.QueryEscape("國際"), 1, []int{1}},
+		{"?any_tag=" + url.QueryEscape("國際") + "&any_tag=" + url.QueryEscape("地震"), 2, []int{1, 2}},
+		{"?after=2019-11-28", 1, []int{3}},
+		{"?tokenized=false", 1, []int{3}},
+		{"?min_length=3", 2, []int{1, 2}},
+		{"?sort=length&order=desc", 3, []int{2, 1, 3}},
+		{"?sort=date&order=desc&limit=2", 3, []int{3, 2}},
+		{"?limit=2&offset=2", 3, []int{3}},
+	}
+	for _, test := range tests {
+		var page articlePage
+		resp := get(t, server, "/articles"+test.query, &page)
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET /articles%s: status %d; want %d", test.query, resp.StatusCode, http.StatusOK)
+			continue
+		}
+
+		got := []int{}
+		for _, a := range page.Articles {
+			got = append(got, a.Id)
+		}
+		if page.Total != test.total || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("GET /articles%s = %d total, %v; want %d total, %v", test.query, page.Total, got, test.total, test.want)
+		}
+	}
+
+	for _, query := range []string{"?limit=0", "?limit=101", "?offset=-1", "?offset=9223372036854775800", "?after=yesterday", "?sort=title", "?order=up", "?tokenized=yes"} {
+		if resp := get(t, server, "/articles"+query, nil); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("GET /articles%s: status %d; want %d", query, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestGetArticle(t *testing.T) {
+	server := newServer(t)
+
+	var got map[string]interface{}
+	if resp := get(t, server, "/articles/1", &got); resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /articles/1: status %d; want %d", resp.StatusCode, http.StatusOK)
+	}
+	want := map[string]interface{}{
+		"id":       1.0,
+		"title":    "title 1",
+		"date":     "2019-11-26 15:42:00",
+		"author":   "",
+		"abstract": "",
+		"body":     "本次地震。",
+		"tags":     []interface{}{"國際", "地震"},
+		"source":   "自由時報",
+		"uri":      "https://example.com/1",
+		"language": "zh-TW",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GET /articles/1 = %v; want %v", got, want)
+	}
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/articles/4", http.StatusNotFound},
+		{"/articles/one", http.StatusBadRequest},
+		{"/articles/3/tokens", http.StatusNotFound}, // not tokenized
+		{"/articles/4/tokens", http.StatusNotFound},
+		{"/articles/1/words", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		if resp := get(t, server, test.path, nil); resp.StatusCode != test.status {
+			t.Errorf("GET %s: status %d; want %d", test.path, resp.StatusCode, test.status)
+		}
+	}
+}
+
+func TestGetTokens(t *testing.T) {
+	server := newServer(t)
+
+	var got tokens
+	if resp := get(t, server, "/articles/1/tokens", &got); resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /articles/1/tokens: status %d; want %d", resp.StatusCode, http.StatusOK)
+	}
+	want := tokens{Id: 1, Tokens: []*token{{"本次", true}, {"地震", true}, {"。", false}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GET /articles/1/tokens = %+v; want %+v", got, want)
+	}
+}
+
+func TestTagsAndSources(t *testing.T) {
+	server := newServer(t)
+
+	var tags map[string][]nameCount
+	get(t, server, "/tags", &tags)
+	wantTags := map[string][]nameCount{"tags": {{"國際", 1}, {"地震", 2}}}
+	if !reflect.DeepEqual(tags, wantTags) {
+		t.Errorf("GET /tags = %v; want %v", tags, wantTags)
+	}
+
+	var sources map[string][]nameCount
+	get(t, server, "/sources", &sources)
+	wantSources := map[string][]nameCount{"sources": {{"天下雜誌", 1}, {"自由時報", 2}}}
+	if !reflect.DeepEqual(sources, wantSources) {
+		t.Errorf("GET /sources = %v; want %v", sources, wantSources)
+	}
+}
+
+func TestLexicon(t *testing.T) {
+	server := newServer(t)
+
+	tests := []struct {
+		query string
+		want  []entry
+	}{
+		{"?prefix=" + url.QueryEscape("日"), []entry{{"日", 2}, {"日本人", 9}, {"日本", 5}}},
+		{"?prefix=" + url.QueryEscape("日") + "&limit=1&offset=1", []entry{{"日本人", 9}}},
+		{"?limit=2", []entry{{"日本人", 9}, {"日本", 5}}},
+		{"?prefix=" + url.QueryEscape("東"), []entry{}},
+	}
+	for _, test := range tests {
+		var page struct {
+			Entries []entry `json:"entries"`
+		}
+		path := "/lexica/zh-TW/test/entries" + test.query
+		if resp := get(t, server, path, &page); resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s: status %d; want %d", path, resp.StatusCode, http.StatusOK)
+			continue
+		}
+		if !reflect.DeepEqual(page.Entries, test.want) {
+			t.Errorf("GET %s = %v; want %v", path, page.Entries, test.want)
+		}
+	}
+
+	var got entry
+	path := "/lexica/zh-TW/test/entries/" + url.PathEscape("日本")
+	if resp := get(t, server, path, &got); resp.StatusCode != http.StatusOK || got != (entry{"日本", 5}) {
+		t.Errorf("GET %s = %d, %v; want %d, %v", path, resp.StatusCode, got, http.StatusOK, entry{"日本", 5})
+	}
+
+	for _, path := range []string{
+		"/lexica/zh-TW/test/entries/" + url.PathEscape("本"),
+		"/lexica/zh-TW/missing/entries",
+		"/lexica/en/test/entries",
+	} {
+		if resp := get(t, server, path, nil); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("GET %s: status %d; want %d", path, resp.StatusCode, http.StatusNotFound)
+		}
+	}
+
+	path = "/lexica/zh-TW/test/entries?offset=9223372036854775800"
+	if resp := get(t, server, path, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("GET %s: status %d; want %d", path, resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestETag(t *testing.T) {
+	server := newServer(t)
+
+	resp := get(t, server, "/articles/1", nil)
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatalf("GET /articles/1: no ETag")
+	}
+	if other := get(t, server, "/articles/2", nil).Header.Get("ETag"); other == etag {
+		t.Errorf("GET /articles/2: ETag %s of /articles/1", etag)
+	}
+
+	tests := []struct {
+		ifNoneMatch string
+		status      int
+	}{
+		{etag, http.StatusNotModified},
+		{`"other", W/` + etag, http.StatusNotModified},
+		{"*", http.StatusNotModified},
+		{`"other"`, http.StatusOK},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/articles/1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("If-None-Match", test.ifNoneMatch)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.status {
+			t.Errorf("If-None-Match %s: status %d; want %d", test.ifNoneMatch, resp.StatusCode, test.status)
+		}
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	server := newServer(t)
+
+	resp, err := http.Post(server.URL+"/articles", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") == "" {
+		t.Errorf("POST /articles: status %d, Allow %q; want %d", resp.StatusCode, resp.Header.Get("Allow"), http.StatusMethodNotAllowed)
+	}
+}
+
+// TestOpenAPI checks that every path of the OpenAPI document is served.
+func TestOpenAPI(t *testing.T) {
+	server := newServer(t)
+
+	var document struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if resp := get(t, server, "/openapi.json", &document); resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /openapi.json: status %d; want %d", resp.StatusCode, http.StatusOK)
+	}
+	if document.OpenAPI == "" || len(document.Paths) == 0 {
+		t.Fatalf("GET /openapi.json: no paths")
+	}
+
+	parameters := strings.NewReplacer("{id}", "1", "{language}", "zh-TW", "{name}", "test", "{lexeme}", url.PathEscape("地震"))
+	for path, operations := range document.Paths {
+		concrete := parameters.Replace(path)
+		for method := range operations {
+			req, err := http.NewRequest(strings.ToUpper(method), server.URL+concrete, strings.NewReader(`{"text": "地震"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("%s %s: status %d; want %d", req.Method, concrete, resp.StatusCode, http.StatusOK)
+			}
+		}
+	}
+}
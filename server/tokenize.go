@@ -0,0 +1,137 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/qwwqe/tcsuite/tokenizer"
+	"github.com/qwwqe/tcsuite/tokenizer/zhtw"
+)
+
+// Limits of the tokenizer: the search depth used unless given by the
+// request or the server options, the greatest depth a request may ask for,
+// as the cost of tokenization grows exponentially with it, and the size of
+// the text tokenized in bytes.
+var (
+	DefaultMaxDepth = 3
+	MaxMaxDepth     = 6
+	MaxTextBytes    = 64 << 10
+)
+
+// Formats of the tokens returned by POST /tokenize
+const (
+	TokensFormat = "tokens" // the default: tokens with their offsets and frequencies
+	WordsFormat  = "words"  // the words of the tokens only
+	TextFormat   = "text"   // the tokens separated by spaces, without white space
+)
+
+type tokenizeRequest struct {
+	Text     string `json:"text"`
+	MaxDepth int    `json:"max_depth"`
+	Format   string `json:"format"`
+}
+
+// tokenSpan is a token of tokenized text, with its offsets in the text.
+// The frequency of non-lexical tokens is 0.
+type tokenSpan struct {
+	Word      string `json:"word"`
+	Lexical   bool   `json:"lexical"`
+	Frequency int    `json:"frequency"` // in the lexicon
+	Start     int    `json:"start"`     // byte offsets, the end exclusive
+	End       int    `json:"end"`
+	RuneStart int    `json:"rune_start"` // rune offsets, the end exclusive
+	RuneEnd   int    `json:"rune_end"`
+}
+
+// tokenize tokenizes the text of the request as the corpus was tokenized,
+// with the zhtw tokenizer and the lexicon of the server. The lexicon is
+// only read, so that requests may be served concurrently.
+func (s *Server) tokenize(req *http.Request) (interface{}, error) {
+	if s.lexicon == nil {
+		return nil, notFound("tokenization is not enabled")
+	}
+
+	var request tokenizeRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, req.Body, int64(MaxTextBytes)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, &httpError{status: http.StatusRequestEntityTooLarge, err: err}
+		}
+		if err == io.EOF {
+			return nil, badRequest("empty request")
+		}
+		return nil, badRequest("invalid request: %v", err)
+	}
+
+	maxDepth := request.MaxDepth
+	if maxDepth == 0 {
+		maxDepth = s.maxDepth
+	}
+	if maxDepth < 1 || maxDepth > MaxMaxDepth {
+		return nil, badRequest("invalid max_depth: %d; want 1 to %d", maxDepth, MaxMaxDepth)
+	}
+	switch request.Format {
+	case "", TokensFormat, WordsFormat, TextFormat:
+	default:
+		return nil, badRequest("invalid format: %q; want %s, %s or %s", request.Format, TokensFormat, WordsFormat, TextFormat)
+	}
+	if !utf8.ValidString(request.Text) {
+		return nil, badRequest("invalid text: not UTF-8")
+	}
+
+	select {
+	case s.tokenizing <- struct{}{}:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	// Tokenizers hold only their options, so one is made for each request
+	t := zhtw.NewTokenizer(&tokenizer.Options{MaxDepth: maxDepth})
+	words, err := tokenizer.Tokenize(t, request.Text, s.lexicon)
+	<-s.tokenizing
+	if err != nil {
+		return nil, err
+	}
+
+	switch request.Format {
+	case WordsFormat:
+		result := make([]string, len(words))
+		for i, w := range words {
+			result[i] = w.Word
+		}
+		return map[string][]string{"words": result}, nil
+
+	case TextFormat:
+		segmented := []string{}
+		for _, w := range words {
+			if strings.TrimSpace(w.Word) != "" {
+				segmented = append(segmented, w.Word)
+			}
+		}
+		return map[string]string{"text": strings.Join(segmented, " ")}, nil
+	}
+
+	spans := make([]*tokenSpan, len(words))
+	offset, runeOffset := 0, 0
+	for i, w := range words {
+		span := &tokenSpan{
+			Word:      w.Word,
+			Lexical:   w.Lexical,
+			Start:     offset,
+			End:       offset + len(w.Word),
+			RuneStart: runeOffset,
+			RuneEnd:   runeOffset + utf8.RuneCountInString(w.Word),
+		}
+		if w.Lexical {
+			span.Frequency, _, _ = s.lexicon.GetLexemeFrequency(w.Word)
+		}
+		spans[i] = span
+		offset, runeOffset = span.End, span.RuneEnd
+	}
+	return map[string][]*tokenSpan{"tokens": spans}, nil
+}
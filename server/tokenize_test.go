@@ -0,0 +1,225 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qwwqe/tcsuite/repository"
+	"github.com/qwwqe/tcsuite/repository/memory"
+)
+
+// post posts body to /tokenize, decoding the body of the response into v
+// if the request succeeds.
+func post(t *testing.T, server *httptest.Server, body string, v interface{}) *http.Response {
+	t.Helper()
+	resp, err := http.Post(server.URL+"/tokenize", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK && v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("POST /tokenize %s: %v", body, err)
+		}
+	}
+	return resp
+}
+
+func TestTokenize(t *testing.T) {
+	server := newServer(t)
+
+	var got map[string][]tokenSpan
+	if resp := post(t, server, `{"text": "日本人地震 ok。"}`, &got); resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST /tokenize: status %d; want %d", resp.StatusCode, http.StatusOK)
+	}
+	want := map[string][]tokenSpan{"tokens": {
+		{Word: "日本人", Lexical: true, Frequency: 9, Start: 0, End: 9, RuneStart: 0, RuneEnd: 3},
+		{Word: "地震", Lexical: true, Frequency: 3, Start: 9, End: 15, RuneStart: 3, RuneEnd: 5},
+		{Word: " ", Start: 15, End: 16, RuneStart: 5, RuneEnd: 6},
+		{Word: "o", Start: 16, End: 17, RuneStart: 6, RuneEnd: 7},
+		{Word: "k", Start: 17, End: 18, RuneStart: 7, RuneEnd: 8},
+		{Word: "。", Start: 18, End: 21, RuneStart: 8, RuneEnd: 9},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("POST /tokenize = %+v; want %+v", got, want)
+	}
+
+	var words map[string][]string
+	post(t, server, `{"text": "日本人地震 ok。", "format": "words"}`, &words)
+	if want := []string{"日本人", "地震", " ", "o", "k", "。"}; !reflect.DeepEqual(words["words"], want) {
+		t.Errorf("POST /tokenize words = %v; want %v", words["words"], want)
+	}
+
+	var text map[string]string
+	post(t, server, `{"text": "日本人地震 ok。", "format": "text", "max_depth": 1}`, &text)
+	if want := "日本人 地震 o k 。"; text["text"] != want {
+		t.Errorf("POST /tokenize text = %q; want %q", text["text"], want)
+	}
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{``, http.StatusBadRequest},
+		{`{"text": 1}`, http.StatusBadRequest},
+		{`{"text": "地震", "depth": 3}`, http.StatusBadRequest},
+		{`{"text": "地震", "max_depth": 100}`, http.StatusBadRequest},
+		{`{"text": "地震", "format": "xml"}`, http.StatusBadRequest},
+		{`{"text": "` + strings.Repeat("地", MaxTextBytes) + `"}`, http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		body := test.body
+		if len(body) > 40 {
+			body = body[:40] + "…"
+		}
+		if resp := post(t, server, test.body, nil); resp.StatusCode != test.status {
+			t.Errorf("POST /tokenize %s: status %d; want %d", body, resp.StatusCode, test.status)
+		}
+	}
+
+	if resp := get(t, server, "/tokenize", nil); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET /tokenize: status %d; want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
+
+// TestTokenizeConcurrently checks that concurrent requests, sharing the
+// lexicon, are each tokenized alike.
+func TestTokenizeConcurrently(t *testing.T) {
+	server := newServer(t)
+	texts := map[string]string{
+		"日本人地震":  "日本人 地震",
+		"地震日本人。": "地震 日本人 。",
+		"日日本":    "日 日本",
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan string, 100)
+	for i := 0; i < 20; i++ {
+		for text, want := range texts {
+			wg.Add(1)
+			go func(text string, want string) {
+				defer wg.Done()
+				body, _ := json.Marshal(map[string]string{"text": text, "format": "text"})
+				resp, err := http.Post(server.URL+"/tokenize", "application/json", strings.NewReader(string(body)))
+				if err != nil {
+					errs <- err.Error()
+					return
+				}
+				defer resp.Body.Close()
+
+				var got map[string]string
+				if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+					errs <- err.Error()
+					return
+				}
+				if got["text"] != want {
+					errs <- "POST /tokenize " + text + " = " + got["text"] + "; want " + want
+				}
+			}(text, want)
+		}
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// panicLexicon is a lexicon that panics when looked up.
+type panicLexicon struct{}
+
+func (panicLexicon) AddLexeme(lexeme string, frequency int) error         { return nil }
+func (panicLexicon) AddLexemes(lexemes []string, frequencies []int) error { return nil }
+func (panicLexicon) GetLexemeFrequency(lexeme string) (int, bool, bool)   { panic("corrupt lexicon") }
+func (panicLexicon) LoadRepository(repo repository.Repository) error      { return nil }
+func (panicLexicon) NumEntries() int                                      { return 1 }
+
+func TestTokenizePanic(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	server := httptest.NewServer(New(memory.New(repository.RepositoryOptions{}), Options{Logger: logger, Lexicon: panicLexicon{}}))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/tokenize", "application/json", strings.NewReader(`{"text": "地震"}`))
+	if err != nil {
+		t.Fatalf("POST /tokenize with a panicking tokenizer: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("POST /tokenize with a panicking tokenizer: status %d, %v; want %d and a JSON error", resp.StatusCode, err, http.StatusInternalServerError)
+	}
+}
+
+// slowLexicon is an empty lexicon that is slow to look up, counting the
+// lookups at once.
+type slowLexicon struct {
+	panicLexicon
+	mu            sync.Mutex
+	current, most int
+}
+
+func (l *slowLexicon) GetLexemeFrequency(lexeme string) (int, bool, bool) {
+	l.mu.Lock()
+	l.current++
+	if l.current > l.most {
+		l.most = l.current
+	}
+	l.mu.Unlock()
+
+	time.Sleep(time.Millisecond)
+
+	l.mu.Lock()
+	l.current--
+	l.mu.Unlock()
+	return 0, false, false
+}
+
+func TestTokenizeBounded(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	lexicon := &slowLexicon{}
+	server := httptest.NewServer(New(memory.New(repository.RepositoryOptions{}), Options{Logger: logger, Lexicon: lexicon, Tokenizers: 2}))
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Post(server.URL+"/tokenize", "application/json", strings.NewReader(`{"text": "日本本州西部近海"}`))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("POST /tokenize: status %d; want %d", resp.StatusCode, http.StatusOK)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if lexicon.most > 2 {
+		t.Errorf("POST /tokenize tokenized %d texts at once; want at most 2", lexicon.most)
+	}
+}
+
+func TestTokenizeDisabled(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	server := httptest.NewServer(New(memory.New(repository.RepositoryOptions{}), Options{Logger: logger}))
+	defer server.Close()
+
+	if resp := post(t, server, `{"text": "地震"}`, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("POST /tokenize without lexicon: status %d; want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
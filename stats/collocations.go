@@ -0,0 +1,321 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/qwwqe/tcsuite/entities/corpus"
+	"github.com/qwwqe/tcsuite/repository"
+)
+
+// Measure is a measure of association by which collocations are ranked.
+type Measure string
+
+const (
+	ByLogLikelihood Measure = "ll" // the default
+	ByPMI           Measure = "pmi"
+	ByTScore        Measure = "t"
+	ByFrequency     Measure = "frequency"
+)
+
+// Association measures how much more often two items occur together than
+// they would by chance.
+type Association struct {
+	Frequency     int     `json:"frequency"` // observed co-occurrences
+	Expected      float64 `json:"expected"`  // co-occurrences expected by chance
+	PMI           float64 `json:"pmi"`       // pointwise mutual information, in bits
+	LogLikelihood float64 `json:"log_likelihood"`
+	TScore        float64 `json:"t_score"`
+}
+
+// associate computes the association of items co-occurring observed times,
+// where the first item has rowTotal opportunities of co-occurring with the
+// second, the second occurs columnTotal times, and there are total
+// opportunities in all.
+//
+// The log-likelihood ratio (G²) is signed: negative where the items occur
+// together less often than expected.
+func associate(observed int, rowTotal float64, columnTotal float64, total float64) Association {
+	o11 := float64(observed)
+	a := Association{
+		Frequency: observed,
+		Expected:  rowTotal * columnTotal / total,
+	}
+	if observed == 0 || a.Expected == 0 {
+		return a
+	}
+
+	a.PMI = math.Log2(o11 / a.Expected)
+	a.TScore = (o11 - a.Expected) / math.Sqrt(o11)
+
+	// Contingency table of the two items, with the frequencies expected of
+	// each cell
+	observedCells := []float64{o11, rowTotal - o11, columnTotal - o11, total - rowTotal - columnTotal + o11}
+	expectedCells := []float64{
+		a.Expected,
+		rowTotal * (total - columnTotal) / total,
+		(total - rowTotal) * columnTotal / total,
+		(total - rowTotal) * (total - columnTotal) / total,
+	}
+	for i, o := range observedCells {
+		if o > 0 && expectedCells[i] > 0 {
+			a.LogLikelihood += o * math.Log(o/expectedCells[i])
+		}
+	}
+	a.LogLikelihood *= 2
+	if o11 < a.Expected {
+		a.LogLikelihood = -a.LogLikelihood
+	}
+
+	return a
+}
+
+func (a Association) measure(m Measure) (float64, error) {
+	switch m {
+	case "", ByLogLikelihood:
+		return a.LogLikelihood, nil
+	case ByPMI:
+		return a.PMI, nil
+	case ByTScore:
+		return a.TScore, nil
+	case ByFrequency:
+		return float64(a.Frequency), nil
+	}
+	return 0, fmt.Errorf("unknown association measure %q", m)
+}
+
+// COLLOCATIONS
+
+// Collocation is a word found near the node word.
+type Collocation struct {
+	Collocate          string `json:"collocate"`
+	CollocateFrequency int    `json:"collocate_frequency"` // occurrences in the corpus
+	Association
+}
+
+// CollocationOptions selects the content and window in which collocations
+// are counted, and the collocations returned.
+type CollocationOptions struct {
+	Filter repository.ContentFilter
+
+	// Words on either side of the node word counted as co-occurring
+	Left  int
+	Right int
+
+	MinFrequency int     // of co-occurrence
+	Measure      Measure // by which the collocations are sorted, best first
+	Limit        int     // maximum number of collocations; 0 is unlimited
+}
+
+// Collocations returns the words co-occurring with word within the window
+// given by options, in the lexical words of the tokenized content matched
+// by options.Filter. Non-lexical tokens are skipped, so the window spans
+// punctuation.
+//
+// The opportunities for co-occurrence are the words in the windows of the
+// node word, taken to be of full size, out of all the words counted.
+func Collocations(ctx context.Context, repo repository.Repository, word string, options CollocationOptions) ([]*Collocation, error) {
+	if options.Left < 0 || options.Right < 0 || options.Left+options.Right == 0 {
+		return nil, errors.New("empty collocation window")
+	}
+	if _, err := (Association{}).measure(options.Measure); err != nil {
+		return nil, err
+	}
+
+	frequencies := map[string]int{}
+	cooccurrences := map[string]int{}
+	total := 0
+	err := repo.IterateTokens(ctx, options.Filter, func(contentId int, tokens []*corpus.Word) error {
+		words := lexical(tokens)
+		total += len(words)
+		for i, w := range words {
+			frequencies[w]++
+			if w != word {
+				continue
+			}
+			for j := i - options.Left; j <= i+options.Right; j++ {
+				if j >= 0 && j < len(words) && j != i {
+					cooccurrences[words[j]]++
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slots := float64(frequencies[word] * (options.Left + options.Right))
+	collocations := []*Collocation{}
+	for collocate, n := range cooccurrences {
+		if n < options.MinFrequency {
+			continue
+		}
+		collocations = append(collocations, &Collocation{
+			Collocate:          collocate,
+			CollocateFrequency: frequencies[collocate],
+			Association:        associate(n, slots, float64(frequencies[collocate]), float64(total)),
+		})
+	}
+
+	sort.Slice(collocations, func(i, j int) bool {
+		mi, _ := collocations[i].measure(options.Measure)
+		mj, _ := collocations[j].measure(options.Measure)
+		if mi != mj {
+			return mi > mj
+		}
+		return collocations[i].Collocate < collocations[j].Collocate
+	})
+	if options.Limit > 0 && options.Limit < len(collocations) {
+		collocations = collocations[:options.Limit]
+	}
+
+	return collocations, nil
+}
+
+// N-GRAMS
+
+// NGram is a sequence of consecutive lexical words.
+type NGram struct {
+	Words []string `json:"words"`
+	Association
+}
+
+// NGramOptions selects the content in which n-grams are counted, and the
+// n-grams returned.
+type NGramOptions struct {
+	Filter repository.ContentFilter
+
+	N    int    // number of words, at least 2
+	Word string // if set, only n-grams containing the word are returned
+
+	MinFrequency int
+	Measure      Measure // by which the n-grams are sorted, best first
+	Limit        int     // maximum number of n-grams; 0 is unlimited
+}
+
+// NGrams counts the n-grams of the lexical words of the tokenized content
+// matched by options.Filter. Non-lexical tokens are skipped, so n-grams
+// span punctuation, but not contents.
+//
+// The association of an n-gram is that of its first n-1 words with its
+// last: for bigrams, that of its two words.
+func NGrams(ctx context.Context, repo repository.Repository, options NGramOptions) ([]*NGram, error) {
+	n := options.N
+	if n < 2 {
+		return nil, fmt.Errorf("n-grams of %d words; want at least 2", n)
+	}
+	if _, err := (Association{}).measure(options.Measure); err != nil {
+		return nil, err
+	}
+
+	frequencies := map[string]int{}
+	ngrams := map[string]int{}
+	prefixes := map[string]int{} // occurrences of the first n-1 words of n-grams
+	total := 0
+	err := repo.IterateTokens(ctx, options.Filter, func(contentId int, tokens []*corpus.Word) error {
+		words := lexical(tokens)
+		for i, w := range words {
+			frequencies[w]++
+			if i+n > len(words) {
+				continue
+			}
+			total++
+			if n == 2 {
+				prefixes[w]++
+			}
+			if options.Word == "" || contains(words[i:i+n], options.Word) {
+				ngrams[ngramKey(words[i:i+n])]++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// For longer n-grams, the prefixes of the n-grams kept are counted in a
+	// second pass
+	if n > 2 {
+		for key, count := range ngrams {
+			if count >= options.MinFrequency {
+				prefixes[ngramKey(splitNgramKey(key)[:n-1])] = 0
+			}
+		}
+		err := repo.IterateTokens(ctx, options.Filter, func(contentId int, tokens []*corpus.Word) error {
+			words := lexical(tokens)
+			for i := 0; i+n <= len(words); i++ {
+				key := ngramKey(words[i : i+n-1])
+				if _, ok := prefixes[key]; ok {
+					prefixes[key]++
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := []*NGram{}
+	for key, count := range ngrams {
+		if count < options.MinFrequency {
+			continue
+		}
+		words := splitNgramKey(key)
+		prefix := prefixes[ngramKey(words[:n-1])]
+		result = append(result, &NGram{
+			Words:       words,
+			Association: associate(count, float64(prefix), float64(frequencies[words[n-1]]), float64(total)),
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		mi, _ := result[i].measure(options.Measure)
+		mj, _ := result[j].measure(options.Measure)
+		if mi != mj {
+			return mi > mj
+		}
+		return ngramKey(result[i].Words) < ngramKey(result[j].Words)
+	})
+	if options.Limit > 0 && options.Limit < len(result) {
+		result = result[:options.Limit]
+	}
+
+	return result, nil
+}
+
+// lexical returns the lexical words of tokens.
+func lexical(tokens []*corpus.Word) []string {
+	words := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		if token.Lexical {
+			words = append(words, token.Word)
+		}
+	}
+	return words
+}
+
+func contains(words []string, word string) bool {
+	for _, w := range words {
+		if w == word {
+			return true
+		}
+	}
+	return false
+}
+
+// N-grams are counted by their words joined by a separator found in no word
+const ngramSeparator = "\x00"
+
+func ngramKey(words []string) string {
+	return strings.Join(words, ngramSeparator)
+}
+
+func splitNgramKey(key string) []string {
+	return strings.Split(key, ngramSeparator)
+}
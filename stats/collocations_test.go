@@ -0,0 +1,140 @@
+package stats
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/qwwqe/tcsuite/repository"
+)
+
+func TestAssociate(t *testing.T) {
+	// 2 co-occurrences in 3 windows of a word occurring 3 times in 10
+	a := associate(2, 3, 3, 10)
+
+	want := Association{
+		Frequency:     2,
+		Expected:      0.9,
+		PMI:           math.Log2(2 / 0.9),
+		LogLikelihood: 2 * (2*math.Log(2/0.9) + 2*math.Log(1/2.1) + 6*math.Log(6/4.9)),
+		TScore:        1.1 / math.Sqrt(2),
+	}
+	if !closeTo(a, want) {
+		t.Errorf("associate() = %+v; want %+v", a, want)
+	}
+
+	// Less often than expected
+	if a := associate(1, 5, 5, 10); a.LogLikelihood >= 0 || a.PMI >= 0 || a.TScore >= 0 {
+		t.Errorf("associate(repelled) = %+v; want negative measures", a)
+	}
+	if a := associate(0, 3, 3, 10); a.PMI != 0 || a.LogLikelihood != 0 {
+		t.Errorf("associate(0) = %+v; want zero measures", a)
+	}
+}
+
+func closeTo(a Association, b Association) bool {
+	near := func(x, y float64) bool { return math.Abs(x-y) < 1e-9 }
+	return a.Frequency == b.Frequency && near(a.Expected, b.Expected) && near(a.PMI, b.PMI) &&
+		near(a.LogLikelihood, b.LogLikelihood) && near(a.TScore, b.TScore)
+}
+
+func TestCollocations(t *testing.T) {
+	repo := newRepository(t, []string{"自由時報", "天下雜誌"}, "打 電話 。 打 電話 給 他", "接 電話 。 打 球")
+
+	collocations, err := Collocations(context.Background(), repo, "電話", CollocationOptions{Left: 1, Measure: ByFrequency})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, c := range collocations {
+		got = append(got, c.Collocate)
+	}
+	if !reflect.DeepEqual(got, []string{"打", "接"}) {
+		t.Fatalf("Collocations() = %v; want [打 接]", got)
+	}
+	if c := collocations[0]; c.CollocateFrequency != 3 || !closeTo(c.Association, associate(2, 3, 3, 10)) {
+		t.Errorf("Collocations()[0] = %+v", c)
+	}
+
+	// To the right, skipping punctuation, across both sides, filtered
+	tests := []struct {
+		options CollocationOptions
+		want    []string
+	}{
+		{CollocationOptions{Right: 1, Measure: ByFrequency}, []string{"打", "給"}},
+		{CollocationOptions{Left: 1, Right: 1, MinFrequency: 2}, []string{"打"}},
+		{CollocationOptions{Left: 1, Right: 1, Measure: ByFrequency, Limit: 1}, []string{"打"}},
+		{CollocationOptions{Left: 1, Filter: repository.ContentFilter{Source: "天下雜誌"}}, []string{"接"}},
+	}
+	for _, test := range tests {
+		collocations, err := Collocations(context.Background(), repo, "電話", test.options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, c := range collocations {
+			got = append(got, c.Collocate)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Collocations(%+v) = %v; want %v", test.options, got, test.want)
+		}
+	}
+
+	for _, options := range []CollocationOptions{{}, {Left: -1, Right: 2}, {Left: 1, Measure: "dice"}} {
+		if _, err := Collocations(context.Background(), repo, "電話", options); err == nil {
+			t.Errorf("Collocations(%+v) = _, nil; want error", options)
+		}
+	}
+}
+
+func TestNGrams(t *testing.T) {
+	repo := newRepository(t, []string{"自由時報", "天下雜誌"}, "打 電話 。 打 電話 給 他", "接 電話 。 打 球")
+
+	bigrams, err := NGrams(context.Background(), repo, NGramOptions{N: 2, Word: "電話", Measure: ByFrequency})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ngramStrings(bigrams), []string{"打 電話", "電話 打", "接 電話", "電話 給"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("NGrams(2) = %v; want %v", got, want)
+	}
+	// 打 starts 3 of 8 bigrams, and 電話 occurs 3 times
+	if !closeTo(bigrams[0].Association, associate(2, 3, 3, 8)) {
+		t.Errorf("NGrams(2)[0] = %+v", bigrams[0])
+	}
+
+	trigrams, err := NGrams(context.Background(), repo, NGramOptions{N: 3, Word: "給", Measure: ByPMI})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ngramStrings(trigrams), []string{"電話 給 他", "打 電話 給"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("NGrams(3) = %v; want %v", got, want)
+	}
+	// 打 電話 starts 2 of 6 trigrams, and 給 occurs once
+	if !closeTo(trigrams[1].Association, associate(1, 2, 1, 6)) {
+		t.Errorf("NGrams(3)[1] = %+v", trigrams[1])
+	}
+
+	all, err := NGrams(context.Background(), repo, NGramOptions{N: 2, MinFrequency: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Errorf("NGrams(2, min 2) = %v; want 2 bigrams", ngramStrings(all))
+	}
+
+	for _, options := range []NGramOptions{{N: 1}, {N: 2, Measure: "dice"}} {
+		if _, err := NGrams(context.Background(), repo, options); err == nil {
+			t.Errorf("NGrams(%+v) = _, nil; want error", options)
+		}
+	}
+}
+
+func ngramStrings(ngrams []*NGram) []string {
+	s := []string{}
+	for _, n := range ngrams {
+		s = append(s, strings.Join(n.Words, " "))
+	}
+	return s
+}
@@ -0,0 +1,161 @@
+// Package stats computes word statistics over the tokenized content of a
+// repository, such as are needed to build graded vocabulary lists.
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/qwwqe/tcsuite/entities/corpus"
+	"github.com/qwwqe/tcsuite/repository"
+)
+
+// WordFrequency holds the statistics of a single word.
+type WordFrequency struct {
+	Word              string  `json:"word"`
+	Frequency         int     `json:"frequency"`          // occurrences
+	PerMillion        float64 `json:"per_million"`        // occurrences per million words
+	DocumentFrequency int     `json:"document_frequency"` // contents in which the word occurs
+	Dispersion        float64 `json:"dispersion"`         // Juilland's D
+}
+
+// Frequencies holds the statistics of the words of a slice of the corpus.
+type Frequencies struct {
+	Tokens   int              `json:"tokens"`   // words counted
+	Contents int              `json:"contents"` // contents counted
+	Words    []*WordFrequency `json:"words"`    // most frequent first
+}
+
+// wordCounts accumulates the statistics of a word over the contents.
+type wordCounts struct {
+	frequency int
+	documents int
+	// Sums of the relative frequencies of the word in each content, and of
+	// their squares
+	sum        float64
+	sumSquares float64
+}
+
+// WordFrequencies counts the lexical words of the tokenized content matched
+// by filter.
+//
+// Dispersion is measured by Juilland's D, taking each content as a part of
+// the corpus and the relative frequency of the word within it: 1 for a
+// word spread evenly over the contents, falling to 0 for a word found in
+// only one. It is undefined for fewer than two contents, and given as 0.
+func WordFrequencies(ctx context.Context, repo repository.Repository, filter repository.ContentFilter) (*Frequencies, error) {
+	f := &Frequencies{Words: []*WordFrequency{}}
+	counts := map[string]*wordCounts{}
+
+	err := repo.IterateTokens(ctx, filter, func(contentId int, tokens []*corpus.Word) error {
+		occurrences := map[string]int{}
+		size := 0
+		for _, token := range tokens {
+			if token.Lexical {
+				occurrences[token.Word]++
+				size++
+			}
+		}
+		if size == 0 {
+			return nil
+		}
+
+		f.Contents++
+		f.Tokens += size
+		for word, n := range occurrences {
+			c, ok := counts[word]
+			if !ok {
+				c = &wordCounts{}
+				counts[word] = c
+			}
+			p := float64(n) / float64(size)
+			c.frequency += n
+			c.documents++
+			c.sum += p
+			c.sumSquares += p * p
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for word, c := range counts {
+		f.Words = append(f.Words, &WordFrequency{
+			Word:              word,
+			Frequency:         c.frequency,
+			PerMillion:        float64(c.frequency) * 1e6 / float64(f.Tokens),
+			DocumentFrequency: c.documents,
+			Dispersion:        juilland(c, f.Contents),
+		})
+	}
+
+	sort.Slice(f.Words, func(i, j int) bool {
+		if f.Words[i].Frequency != f.Words[j].Frequency {
+			return f.Words[i].Frequency > f.Words[j].Frequency
+		}
+		return f.Words[i].Word < f.Words[j].Word
+	})
+
+	return f, nil
+}
+
+// juilland returns Juilland's D of a word over n parts:
+// D = 1 - V / sqrt(n - 1), where V is the coefficient of variation of the
+// relative frequencies of the word in the parts.
+func juilland(c *wordCounts, n int) float64 {
+	if n < 2 {
+		return 0
+	}
+
+	mean := c.sum / float64(n)
+	variance := c.sumSquares/float64(n) - mean*mean
+	if variance < 0 { // rounding
+		variance = 0
+	}
+	v := math.Sqrt(variance) / mean
+
+	d := 1 - v/math.Sqrt(float64(n-1))
+	return math.Max(0, math.Min(1, d))
+}
+
+// Limit keeps the words occurring at least min times, up to limit words if
+// limit is positive.
+func (f *Frequencies) Limit(min int, limit int) {
+	words := f.Words[:0]
+	for _, w := range f.Words {
+		if w.Frequency >= min {
+			words = append(words, w)
+		}
+	}
+	if limit > 0 && limit < len(words) {
+		words = words[:limit]
+	}
+	f.Words = words
+}
+
+// WriteTSV writes the statistics of the words as tab-separated values,
+// with a header.
+func (f *Frequencies) WriteTSV(w io.Writer) error {
+	if _, err := fmt.Fprintln(w, "word\tfrequency\tper_million\tdocument_frequency\tdispersion"); err != nil {
+		return err
+	}
+	for _, word := range f.Words {
+		_, err := fmt.Fprintf(w, "%s\t%d\t%.2f\t%d\t%.4f\n", word.Word, word.Frequency, word.PerMillion, word.DocumentFrequency, word.Dispersion)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteJSON writes f as indented JSON.
+func (f *Frequencies) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(f)
+}
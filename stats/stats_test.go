@@ -0,0 +1,138 @@
+package stats
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/qwwqe/tcsuite/content"
+	"github.com/qwwqe/tcsuite/entities/corpus"
+	"github.com/qwwqe/tcsuite/repository"
+	"github.com/qwwqe/tcsuite/repository/memory"
+)
+
+// newRepository returns a repository of contents tokenized into the words
+// of texts, "。" being non-lexical.
+func newRepository(t *testing.T, sources []string, texts ...string) repository.Repository {
+	repo := memory.New(repository.RepositoryOptions{})
+	for i, text := range texts {
+		id, err := repo.SaveContent(&content.FetchedContent{
+			Title:     "title",
+			Date:      "2019-11-26 15:42:00",
+			Body:      strings.ReplaceAll(text, " ", ""),
+			CanonName: sources[i],
+			Uri:       fmt.Sprintf("https://example.com/%d", i),
+			Language:  "zh-TW",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tokens := []*corpus.Word{}
+		for _, word := range strings.Fields(text) {
+			tokens = append(tokens, &corpus.Word{Word: word, Lexical: word != "。"})
+		}
+		if err := repo.RegisterTokens(id, tokens); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repo
+}
+
+func TestWordFrequencies(t *testing.T) {
+	repo := newRepository(t, []string{"自由時報", "天下雜誌"}, "地震 地震 台灣 日本 。", "地震 颱風 。")
+
+	f, err := WordFrequencies(context.Background(), repo, repository.ContentFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Tokens != 6 || f.Contents != 2 {
+		t.Errorf("WordFrequencies() counted %d tokens in %d contents; want 6 in 2", f.Tokens, f.Contents)
+	}
+
+	want := []*WordFrequency{
+		// Half of each content, so evenly dispersed
+		{Word: "地震", Frequency: 3, PerMillion: 500000, DocumentFrequency: 2, Dispersion: 1},
+		{Word: "台灣", Frequency: 1, PerMillion: 1e6 / 6, DocumentFrequency: 1, Dispersion: 0},
+		{Word: "日本", Frequency: 1, PerMillion: 1e6 / 6, DocumentFrequency: 1, Dispersion: 0},
+		{Word: "颱風", Frequency: 1, PerMillion: 1e6 / 6, DocumentFrequency: 1, Dispersion: 0},
+	}
+	if len(f.Words) != len(want) {
+		t.Fatalf("WordFrequencies() = %d words; want %d", len(f.Words), len(want))
+	}
+	for i, w := range want {
+		got := f.Words[i]
+		if got.Word != w.Word || got.Frequency != w.Frequency || got.DocumentFrequency != w.DocumentFrequency ||
+			math.Abs(got.PerMillion-w.PerMillion) > 1e-6 || math.Abs(got.Dispersion-w.Dispersion) > 1e-9 {
+			t.Errorf("WordFrequencies()[%d] = %+v; want %+v", i, got, w)
+		}
+	}
+
+	// Sliced by source, there is a single content
+	f, err = WordFrequencies(context.Background(), repo, repository.ContentFilter{Source: "天下雜誌"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Tokens != 2 || f.Contents != 1 || len(f.Words) != 2 || f.Words[0].Dispersion != 0 {
+		t.Errorf("WordFrequencies(source) = %+v", f)
+	}
+}
+
+func TestJuilland(t *testing.T) {
+	// A word found 1, 2 and 3 times in contents of 10 words each
+	c := &wordCounts{}
+	for _, p := range []float64{0.1, 0.2, 0.3} {
+		c.sum += p
+		c.sumSquares += p * p
+	}
+
+	// V = sd / mean = sqrt(2/3) / 2, D = 1 - V / sqrt(2)
+	want := 1 - math.Sqrt(2.0/3)/2/math.Sqrt(2)
+	if got := juilland(c, 3); math.Abs(got-want) > 1e-9 {
+		t.Errorf("juilland() = %f; want %f", got, want)
+	}
+}
+
+func TestLimit(t *testing.T) {
+	f := &Frequencies{Words: []*WordFrequency{{Word: "a", Frequency: 3}, {Word: "b", Frequency: 2}, {Word: "c", Frequency: 1}}}
+	f.Limit(2, 0)
+	if len(f.Words) != 2 {
+		t.Errorf("Limit(2, 0) kept %d words; want 2", len(f.Words))
+	}
+	f.Limit(0, 1)
+	if len(f.Words) != 1 || f.Words[0].Word != "a" {
+		t.Errorf("Limit(0, 1) kept %v; want [a]", f.Words)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	f := &Frequencies{Tokens: 4, Contents: 2, Words: []*WordFrequency{
+		{Word: "地震", Frequency: 3, PerMillion: 750000, DocumentFrequency: 2, Dispersion: 0.5},
+	}}
+
+	var b bytes.Buffer
+	if err := f.WriteTSV(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := "word\tfrequency\tper_million\tdocument_frequency\tdispersion\n地震\t3\t750000.00\t2\t0.5000\n"
+	if b.String() != want {
+		t.Errorf("WriteTSV() = %q; want %q", b.String(), want)
+	}
+
+	b.Reset()
+	if err := f.WriteJSON(&b); err != nil {
+		t.Fatal(err)
+	}
+	var got Frequencies
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&got, f) {
+		t.Errorf("WriteJSON() = %s", b.String())
+	}
+}
@@ -8,9 +8,13 @@ import (
 )
 
 func BenchmarkPopulatePrefixTrie(b *testing.B) {
-	repo := r.GetRepository(r.RepositoryOptions{
-		RestoreRequestHistory: false,
+	repo, err := r.NewRepository(r.RepositoryOptions{
+		AutoMigrate: true,
 	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer repo.Close()
 
 	lexiconName := "Traditional Chinese Comprehensive"
 	lexiconLang := language.MustParse("zh-tw").String()
@@ -6,6 +6,7 @@ import (
 	r "github.com/qwwqe/tcsuite/repository"
 	"github.com/qwwqe/tcsuite/tokenizer"
 	"github.com/qwwqe/tcsuite/tokenizer/zhtw"
+	"path/filepath"
 	"testing"
 )
 
@@ -14,16 +15,33 @@ var correctTokenization = []string{
 	"本", "次", "地震", "發生", "位置", "約", "位於", "日本", "本州", "西部", "近海", "。",
 }
 
+// Lexemes of the test text, with made-up frequencies
+var lexemes = []string{"本", "次", "地震", "發生", "位置", "約", "位於", "日本", "本州", "西部", "近海", "。"}
+var frequencies = []int{1374, 1127, 108, 589, 156, 480, 102, 301, 5, 61, 9, 0}
+
+// TestTokenize runs against a scratch SQLite database holding just the
+// lexemes of the test text, so that it needs no database server.
 func TestTokenize(t *testing.T) {
-	repo := r.GetRepository(r.RepositoryOptions{
-		RestoreRequestHistory: false,
+	repo, err := r.NewRepository(r.RepositoryOptions{
+		Driver:      r.SQLite,
+		Dsn:         filepath.Join(t.TempDir(), "tokenize.db"),
+		AutoMigrate: true,
 	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
 
 	lexiconName := "Traditional Chinese Comprehensive"
 	lexiconLang := languages.ZH_TW
 
+	err = repo.AddLexemes(lexiconName, lexiconLang, lexemes, frequencies)
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	lexicon := l.NewZhTwLexicon(lexiconName, lexiconLang)
-	err := lexicon.LoadRepository(repo)
+	err = lexicon.LoadRepository(repo)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -53,15 +71,19 @@ func TestTokenize(t *testing.T) {
 }
 
 func BenchmarkTokenizer(b *testing.B) {
-	repo := r.GetRepository(r.RepositoryOptions{
-		RestoreRequestHistory: false,
+	repo, err := r.NewRepository(r.RepositoryOptions{
+		AutoMigrate: true,
 	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer repo.Close()
 
 	lexiconName := "Traditional Chinese Comprehensive"
 	lexiconLang := languages.ZH_TW
 
 	lexicon := l.NewZhTwLexicon(lexiconName, lexiconLang)
-	err := lexicon.LoadRepository(repo)
+	err = lexicon.LoadRepository(repo)
 	if err != nil {
 		b.Fatal(err)
 	}
@@ -0,0 +1,128 @@
+package tokenizer
+
+import (
+	"log/slog"
+	"runtime"
+	"sync"
+
+	"github.com/qwwqe/tcsuite/content"
+	l "github.com/qwwqe/tcsuite/lexicon"
+	"github.com/qwwqe/tcsuite/logging"
+	"github.com/qwwqe/tcsuite/repository"
+)
+
+// PoolOptions configures a Pool.
+type PoolOptions struct {
+	Tokenizer  Interface
+	Lexicon    l.Lexicon // shared by the workers, and only read
+	Repository repository.Repository
+
+	Workers int // contents tokenized at once; runtime.NumCPU() if 0
+	Queue   int // contents waiting before Add blocks; Workers if 0
+
+	Logger *slog.Logger // if nil, slog's default logger is used
+}
+
+// PoolStats counts the contents handled by a Pool.
+type PoolStats struct {
+	Tokenized int
+	Failed    int
+}
+
+// Pool tokenizes contents and registers their tokens on a bounded number
+// of workers, so that contents may be tokenized as they are fetched.
+// Failures are logged, counted and recorded in the repository, leaving the
+// contents to be tokenized later.
+type Pool struct {
+	options PoolOptions
+	logger  *slog.Logger
+	jobs    chan poolJob
+	wg      sync.WaitGroup
+
+	mu    sync.Mutex
+	stats PoolStats
+}
+
+type poolJob struct {
+	contentId int
+	text      string
+}
+
+// NewPool starts the workers of a pool, which Close stops.
+func NewPool(options PoolOptions) *Pool {
+	if options.Workers <= 0 {
+		options.Workers = runtime.NumCPU()
+	}
+	if options.Queue <= 0 {
+		options.Queue = options.Workers
+	}
+
+	p := &Pool{
+		options: options,
+		logger:  logging.Component(options.Logger, "tokenizer"),
+		jobs:    make(chan poolJob, options.Queue),
+	}
+	p.wg.Add(options.Workers)
+	for i := 0; i < options.Workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// Add queues text, the body of the content with the given id, to be
+// tokenized, blocking while the queue is full. It must not be called after
+// Close.
+func (p *Pool) Add(contentId int, text string) {
+	p.jobs <- poolJob{contentId: contentId, text: text}
+}
+
+// OnContentSaved queues the body of c, saved under id, to be tokenized. It
+// is meant as the fetcher.FetcherOptions hook of the same name.
+func (p *Pool) OnContentSaved(id int, c *content.FetchedContent) {
+	p.Add(id, c.Body)
+}
+
+// Stats returns the number of contents handled so far.
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+// Close waits for the queued contents to be tokenized and stops the
+// workers, returning the number of contents handled.
+func (p *Pool) Close() PoolStats {
+	close(p.jobs)
+	p.wg.Wait()
+	return p.Stats()
+}
+
+func (p *Pool) work() {
+	defer p.wg.Done()
+	for job := range p.jobs {
+		err := p.tokenize(job)
+
+		p.mu.Lock()
+		if err != nil {
+			p.stats.Failed++
+		} else {
+			p.stats.Tokenized++
+		}
+		p.mu.Unlock()
+
+		if err != nil {
+			p.logger.Error("error tokenizing content", logging.ContentIdKey, job.contentId, logging.ErrorKey, err)
+			if err := p.options.Repository.RecordTokenizeFailure(job.contentId, err.Error()); err != nil {
+				p.logger.Error("error recording tokenize failure", logging.ContentIdKey, job.contentId, logging.ErrorKey, err)
+			}
+		}
+	}
+}
+
+func (p *Pool) tokenize(job poolJob) error {
+	tokens, err := Tokenize(p.options.Tokenizer, job.text, p.options.Lexicon)
+	if err != nil {
+		return err
+	}
+	return p.options.Repository.RegisterTokens(job.contentId, tokens)
+}
@@ -0,0 +1,101 @@
+package tokenizer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"reflect"
+	"testing"
+
+	"github.com/qwwqe/tcsuite/content"
+	"github.com/qwwqe/tcsuite/entities/corpus"
+	l "github.com/qwwqe/tcsuite/lexicon"
+	"github.com/qwwqe/tcsuite/repository"
+	"github.com/qwwqe/tcsuite/repository/memory"
+)
+
+// runeTokenizer tokenizes text into its characters, failing on empty text
+// and panicking on "panic".
+type runeTokenizer struct{}
+
+func (runeTokenizer) Tokenize(text string, lexicon l.Lexicon) ([]*corpus.Word, error) {
+	if text == "" {
+		return nil, errors.New("empty text")
+	}
+	if text == "panic" {
+		panic("bad document")
+	}
+	words := []*corpus.Word{}
+	for _, r := range text {
+		words = append(words, &corpus.Word{Word: string(r), Lexical: r != '。'})
+	}
+	return words, nil
+}
+
+func TestPool(t *testing.T) {
+	repo := memory.New(repository.RepositoryOptions{})
+	pool := NewPool(PoolOptions{
+		Tokenizer:  runeTokenizer{},
+		Repository: repo,
+		Workers:    4,
+		Logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+
+	contents := []*content.FetchedContent{}
+	for i := 0; i < 50; i++ {
+		c := &content.FetchedContent{
+			Title:    "title",
+			Body:     fmt.Sprintf("地震%d。", i),
+			Uri:      fmt.Sprintf("https://example.com/%d", i),
+			Language: "zh-TW",
+		}
+		switch i {
+		case 7:
+			c.Body = ""
+		case 9:
+			c.Body = "panic"
+		}
+		id, err := repo.SaveContent(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Id = id
+		contents = append(contents, c)
+		pool.OnContentSaved(id, c)
+	}
+	pool.Add(len(contents)+1, "未存") // not saved
+
+	if stats := pool.Close(); stats != (PoolStats{Tokenized: 48, Failed: 3}) {
+		t.Errorf("Close() = %+v; want {Tokenized:48 Failed:3}", stats)
+	}
+
+	// Failures of saved content are recorded
+	failures, err := repo.GetTokenizeFailures(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[int]string{}
+	for _, f := range failures {
+		got[f.ContentId] = f.Error
+	}
+	if want := map[int]string{8: "empty text", 10: "tokenizer panicked: bad document"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetTokenizeFailures() = %v; want %v", got, want)
+	}
+
+	for _, c := range contents {
+		tokens, err := repo.GetTokens(context.Background(), c.Id)
+		if c.Body == "" || c.Body == "panic" {
+			if err != repository.ErrNotTokenized {
+				t.Errorf("GetTokens(%d) of %q = _, %v; want %v", c.Id, c.Body, err, repository.ErrNotTokenized)
+			}
+			continue
+		}
+
+		want, _ := runeTokenizer{}.Tokenize(c.Body, nil)
+		if err != nil || !reflect.DeepEqual(tokens, want) {
+			t.Errorf("GetTokens(%d) = %v, %v; want tokens of %q", c.Id, tokens, err, c.Body)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package tokenizer
 
 import (
+	"fmt"
+
 	"github.com/qwwqe/tcsuite/entities/corpus"
 	l "github.com/qwwqe/tcsuite/lexicon"
 	//	"io"
@@ -13,3 +15,14 @@ type Interface interface {
 type Options struct {
 	MaxDepth int
 }
+
+// Tokenize tokenizes text with t, turning a panic of the tokenizer into an
+// error so that a bad document does not stop those tokenized alongside it.
+func Tokenize(t Interface, text string, lexicon l.Lexicon) (tokens []*corpus.Word, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("tokenizer panicked: %v", r)
+		}
+	}()
+	return t.Tokenize(text, lexicon)
+}